@@ -6,9 +6,16 @@ import (
 	"github.com/kataras/iris/v12/mvc"
 	"imoc-product/backend/web/controllers"
 	"imoc-product/common"
+	"imoc-product/datamodels"
+	"imoc-product/rabbitmq"
 	"imoc-product/repositories"
+	"imoc-product/rpc"
 	"imoc-product/services"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -19,6 +26,14 @@ func main() {
 	// 3.注册模板
 	template := iris.HTML("./backend/web/views", ".html").Layout(
 		"shared/layout.html").Reload(true)
+	// 订单状态名称
+	template.AddFunc("orderStatus", func(status string) string {
+		value, err := strconv.ParseInt(status, 10, 64)
+		if err != nil {
+			return status
+		}
+		return datamodels.OrderStatusName(value)
+	})
 	app.RegisterView(template)
 	// 4.设置模板目标
 	app.HandleDir("/assets", "./backend/web/assets")
@@ -43,6 +58,14 @@ func main() {
 	product.Register(ctx, productService)
 	product.Handle(new(controllers.ProductController))
 
+	// 秒杀活动
+	campaignRepository := repositories.NewCampaignManager("campaign", db)
+	campaignService := services.NewCampaignService(campaignRepository)
+	campaignParty := app.Party("/campaign")
+	campaign := mvc.New(campaignParty)
+	campaign.Register(ctx, campaignService, productService)
+	campaign.Handle(new(controllers.CampaignController))
+
 	orderRepository := repositories.NewOrderManagerRepository("order_table", db)
 	orderService := services.NewOrderService(orderRepository)
 	orderParty := app.Party("/order")
@@ -50,6 +73,35 @@ func main() {
 	order.Register(ctx, orderService)
 	order.Handle(new(controllers.OrderController))
 
+	// 死信订单管理
+	rabbitMQ := rabbitmq.NewRabbitMQSimpleDurable("imoocProduct")
+	defer rabbitMQ.Destory()
+	deadLetterParty := app.Party("/deadletter")
+	deadLetter := mvc.New(deadLetterParty)
+	deadLetter.Register(ctx, rabbitMQ)
+	deadLetter.Handle(new(controllers.DeadLetterController))
+
+	// 黑名单，修改后通过RPC通知验证集群
+	validateHosts := strings.Split(os.Getenv("VALIDATE_HOSTS"), ",")
+	if validateHosts[0] == "" {
+		validateHosts = []string{"127.0.0.1:8083"}
+	}
+	secret, err := common.SecretFromEnv("RPC_SECRET")
+	if err != nil {
+		log.Fatal(err)
+	}
+	rpc.Secret = secret
+	rpcPool := rpc.NewPool("backend", rpc.Secret)
+	defer rpcPool.Close()
+	blacklistRepository := repositories.NewBlacklistManager("blacklist", db)
+	blacklistService := services.NewBlacklistService(blacklistRepository,
+		repositories.NewBlacklistAuditManager("blacklist_audit", db),
+		rpc.NewBlacklistNotifier(rpcPool, validateHosts, time.Second))
+	blacklistParty := app.Party("/blacklist")
+	blacklist := mvc.New(blacklistParty)
+	blacklist.Register(ctx, blacklistService)
+	blacklist.Handle(new(controllers.BlacklistController))
+
 	// 6.启动服务
 	app.Run(iris.Addr("localhost:8080"), iris.WithoutServerError(iris.ErrServerClosed), iris.WithOptimizations)
 
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"github.com/kataras/iris/v12"
+	"imoc-product/common"
+	"imoc-product/datamodels"
+	"imoc-product/services"
+	"time"
+)
+
+// 黑名单管理接口，返回统一的JSON响应
+type BlacklistController struct {
+	Ctx              iris.Context
+	BlacklistService services.IBlacklistService
+}
+
+// 后台添加黑名单的操作人
+const blacklistOperator = "admin"
+
+// 黑名单列表 GET /blacklist/list，active=true时只返回有效的记录
+func (b *BlacklistController) GetList() {
+	w := b.Ctx.ResponseWriter()
+	getList := b.BlacklistService.GetAll
+	if b.Ctx.URLParamDefault("active", "false") == "true" {
+		getList = b.BlacklistService.GetActive
+	}
+	blacklistArray, err := getList()
+	if err != nil {
+		b.Ctx.Application().Logger().Debug(err)
+		common.WriteResponse(w, common.CodeUnavailable, "", nil)
+		return
+	}
+	common.WriteOK(w, blacklistArray)
+}
+
+// 添加黑名单 POST /blacklist/add
+// 参数：UserID，Reason，TTL 有效时长如 30m、24h，为空时永久有效
+func (b *BlacklistController) PostAdd() {
+	w := b.Ctx.ResponseWriter()
+	userID, err := b.Ctx.PostValueInt64("UserID")
+	if err != nil || userID <= 0 {
+		common.WriteResponse(w, common.CodeInvalidRequest, "用户ID格式错误", nil)
+		return
+	}
+	var ttl time.Duration
+	if ttlString := b.Ctx.PostValue("TTL"); ttlString != "" {
+		ttl, err = time.ParseDuration(ttlString)
+		if err != nil || ttl <= 0 {
+			common.WriteResponse(w, common.CodeInvalidRequest, "有效时长格式错误", nil)
+			return
+		}
+	}
+	blacklist, err := b.BlacklistService.Add(userID, b.Ctx.PostValue("Reason"), ttl, blacklistOperator)
+	if err != nil {
+		b.Ctx.Application().Logger().Debug(err)
+		common.WriteResponse(w, common.CodeUnavailable, "", nil)
+		return
+	}
+	common.WriteOK(w, blacklist)
+}
+
+// 移出黑名单 POST /blacklist/remove，参数：UserID
+func (b *BlacklistController) PostRemove() {
+	w := b.Ctx.ResponseWriter()
+	userID, err := b.Ctx.PostValueInt64("UserID")
+	if err != nil || userID <= 0 {
+		common.WriteResponse(w, common.CodeInvalidRequest, "用户ID格式错误", nil)
+		return
+	}
+	removed, err := b.BlacklistService.Remove(userID, blacklistOperator)
+	if err != nil {
+		b.Ctx.Application().Logger().Debug(err)
+		common.WriteResponse(w, common.CodeUnavailable, "", nil)
+		return
+	}
+	if !removed {
+		common.WriteResponse(w, common.CodeNotFound, "用户不在黑名单中", nil)
+		return
+	}
+	common.WriteOK(w, nil)
+}
+
+// 审计记录 GET /blacklist/audit
+// 参数：UserID 查询指定用户，为空时返回最近的记录；Limit 默认100
+func (b *BlacklistController) GetAudit() {
+	w := b.Ctx.ResponseWriter()
+	var auditArray []*datamodels.BlacklistAudit
+	var err error
+	if b.Ctx.URLParamExists("UserID") {
+		userID, parseErr := b.Ctx.URLParamInt64("UserID")
+		if parseErr != nil || userID <= 0 {
+			common.WriteResponse(w, common.CodeInvalidRequest, "用户ID格式错误", nil)
+			return
+		}
+		auditArray, err = b.BlacklistService.GetAuditsByUserID(userID)
+	} else {
+		limit := b.Ctx.URLParamIntDefault("Limit", 100)
+		if limit <= 0 || limit > 1000 {
+			common.WriteResponse(w, common.CodeInvalidRequest, "Limit取值范围1-1000", nil)
+			return
+		}
+		auditArray, err = b.BlacklistService.GetAudits(limit)
+	}
+	if err != nil {
+		b.Ctx.Application().Logger().Debug(err)
+		common.WriteResponse(w, common.CodeUnavailable, "", nil)
+		return
+	}
+	common.WriteOK(w, auditArray)
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+	"imoc-product/common"
+	"imoc-product/datamodels"
+	"imoc-product/services"
+	"strconv"
+	"time"
+)
+
+type CampaignController struct {
+	Ctx             iris.Context
+	CampaignService services.ICampaignService
+	ProductService  services.IProductService
+}
+
+// 表单中的时间格式，与datetime-local输入框一致
+const campaignTimeLayout = "2006-01-02T15:04"
+
+func (c *CampaignController) GetAll() mvc.View {
+	campaignArray, err := c.CampaignService.GetAllCampaign()
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	return mvc.View{
+		Name: "campaign/view.html",
+		Data: iris.Map{
+			"campaignArray": campaignArray,
+			"now":           time.Now(),
+		},
+	}
+}
+
+// 解析活动表单，时间字段单独解析
+func (c *CampaignController) parseForm() (*datamodels.Campaign, error) {
+	campaign := &datamodels.Campaign{}
+	if err := c.Ctx.Request().ParseForm(); err != nil {
+		return nil, err
+	}
+	dec := common.NewDecoder(&common.DecoderOptions{TagName: "imooc", IgnoreUnknownKeys: true})
+	if err := dec.Decode(c.Ctx.Request().Form, campaign); err != nil {
+		return nil, err
+	}
+	var err error
+	campaign.StartTime, err = time.ParseInLocation(campaignTimeLayout, c.Ctx.FormValue("StartTime"), time.Local)
+	if err != nil {
+		return nil, err
+	}
+	campaign.EndTime, err = time.ParseInLocation(campaignTimeLayout, c.Ctx.FormValue("EndTime"), time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// 添加活动
+func (c *CampaignController) GetAdd() mvc.View {
+	productArray, err := c.ProductService.GetAllProduct()
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	return mvc.View{
+		Name: "campaign/add.html",
+		Data: iris.Map{
+			"productArray": productArray,
+		},
+	}
+}
+
+func (c *CampaignController) PostAdd() {
+	campaign, err := c.parseForm()
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+		c.Ctx.Redirect("/campaign/add")
+		return
+	}
+	_, err = c.CampaignService.InsertCampaign(campaign)
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	c.Ctx.Redirect("/campaign/all")
+}
+
+func (c *CampaignController) GetManager() mvc.View {
+	id, err := strconv.ParseInt(c.Ctx.URLParam("id"), 10, 64)
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	campaign, err := c.CampaignService.GetCampaignByID(id)
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	productArray, err := c.ProductService.GetAllProduct()
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	return mvc.View{
+		Name: "campaign/manager.html",
+		Data: iris.Map{
+			"campaign":     campaign,
+			"productArray": productArray,
+		},
+	}
+}
+
+// 修改活动
+func (c *CampaignController) PostUpdate() {
+	campaign, err := c.parseForm()
+	if err == nil {
+		err = c.CampaignService.UpdateCampaign(campaign)
+	}
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	c.Ctx.Redirect("/campaign/all")
+}
+
+func (c *CampaignController) GetDelete() {
+	idString := c.Ctx.URLParam("id")
+	id, err := strconv.ParseInt(idString, 10, 64)
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	if c.CampaignService.DeleteCampaignByID(id) {
+		c.Ctx.Application().Logger().Debug("删除活动成功。ID为:" + idString)
+	} else {
+		c.Ctx.Application().Logger().Debug("删除活动失败。ID为:" + idString)
+	}
+	c.Ctx.Redirect("/campaign/all")
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+	"imoc-product/rabbitmq"
+)
+
+// 死信订单每页显示数量
+var deadLetterLimit = 100
+
+type DeadLetterController struct {
+	Ctx      iris.Context
+	RabbitMQ *rabbitmq.RabbitMQ
+}
+
+func (d *DeadLetterController) GetAll() mvc.View {
+	messages, err := d.RabbitMQ.ListDead(deadLetterLimit)
+	if err != nil {
+		d.Ctx.Application().Logger().Debug(err)
+	}
+	return mvc.View{
+		Name: "deadletter/view.html",
+		Data: iris.Map{
+			"messages": messages,
+		},
+	}
+}
+
+// 重新投递死信订单
+func (d *DeadLetterController) GetReplay() {
+	id := d.Ctx.URLParam("id")
+	if err := d.RabbitMQ.ReplayDead(id); err != nil {
+		d.Ctx.Application().Logger().Debug("重新投递死信订单失败。ID为:" + id + "，" + err.Error())
+	} else {
+		d.Ctx.Application().Logger().Debug("重新投递死信订单成功。ID为:" + id)
+	}
+	d.Ctx.Redirect("/deadletter/all")
+}
+
+// 丢弃死信订单
+func (d *DeadLetterController) GetDelete() {
+	id := d.Ctx.URLParam("id")
+	if err := d.RabbitMQ.DropDead(id); err != nil {
+		d.Ctx.Application().Logger().Debug("删除死信订单失败。ID为:" + id + "，" + err.Error())
+	} else {
+		d.Ctx.Application().Logger().Debug("删除死信订单成功。ID为:" + id)
+	}
+	d.Ctx.Redirect("/deadletter/all")
+}
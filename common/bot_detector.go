@@ -0,0 +1,258 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 每个滑动窗口划分的桶数，桶越多计数越精确
+const windowBuckets = 10
+
+// 滑动窗口计数，窗口按时间划分为若干个桶，过期的桶在写入时清零
+type slidingWindow struct {
+	buckets    [windowBuckets]int64
+	bucketSize int64
+	// 最新一个桶的序号
+	head int64
+}
+
+// 在now时刻增加一次，返回窗口内的总数
+func (w *slidingWindow) add(now time.Time) int64 {
+	index := now.UnixNano() / w.bucketSize
+	if index-w.head >= windowBuckets {
+		w.buckets = [windowBuckets]int64{}
+	} else {
+		for i := w.head + 1; i <= index; i++ {
+			w.buckets[i%windowBuckets] = 0
+		}
+	}
+	if index > w.head {
+		w.head = index
+	}
+	w.buckets[index%windowBuckets]++
+	var total int64
+	for _, n := range w.buckets {
+		total += n
+	}
+	return total
+}
+
+// 检测规则，窗口内次数超过Limit视为异常
+type DetectorRule struct {
+	// 窗口长度，如 1s、1m
+	Window string `json:"window"`
+	// 窗口内允许的最大次数，0为不检测
+	Limit int64 `json:"limit"`
+}
+
+// 异常检测配置
+type DetectorConfig struct {
+	// 每个用户的请求频率
+	UserRate DetectorRule `json:"userRate"`
+	// 每个用户的突发请求，窗口较短
+	UserBurst DetectorRule `json:"userBurst"`
+	// 每个IP的请求频率
+	IPRate DetectorRule `json:"ipRate"`
+	// 每个IP签名校验失败的次数
+	SignFail DetectorRule `json:"signFail"`
+	// 自动拉黑的时长，为空时永久有效
+	BanTTL string `json:"banTTL"`
+}
+
+// 默认的检测配置
+func DefaultDetectorConfig() DetectorConfig {
+	return DetectorConfig{
+		UserRate:  DetectorRule{Window: "1m", Limit: 60},
+		UserBurst: DetectorRule{Window: "1s", Limit: 10},
+		IPRate:    DetectorRule{Window: "1m", Limit: 600},
+		SignFail:  DetectorRule{Window: "1m", Limit: 10},
+		BanTTL:    "1h",
+	}
+}
+
+// 按key统计一条规则的滑动窗口
+type windowCounter struct {
+	name    string
+	window  time.Duration
+	limit   int64
+	windows map[string]*slidingWindow
+	sync.Mutex
+}
+
+func newWindowCounter(name string, rule DetectorRule) (*windowCounter, error) {
+	if rule.Limit <= 0 {
+		return nil, nil
+	}
+	window, err := time.ParseDuration(rule.Window)
+	if err != nil || window < windowBuckets {
+		return nil, fmt.Errorf("检测规则%s的窗口格式错误：%q", name, rule.Window)
+	}
+	return &windowCounter{name: name, window: window, limit: rule.Limit, windows: make(map[string]*slidingWindow)}, nil
+}
+
+// 计数，超过阈值时返回违规并重新计数，避免同一个key重复触发
+func (c *windowCounter) observe(key string, now time.Time) *Offense {
+	if c == nil || key == "" {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	w, ok := c.windows[key]
+	if !ok {
+		w = &slidingWindow{bucketSize: int64(c.window) / windowBuckets}
+		c.windows[key] = w
+	}
+	count := w.add(now)
+	if count <= c.limit {
+		return nil
+	}
+	delete(c.windows, key)
+	return &Offense{Rule: c.name, Key: key, Count: count, Limit: c.limit, Window: c.window, Time: now}
+}
+
+// 清理窗口内没有请求的key
+func (c *windowCounter) cleanup(now time.Time) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	index := now.UnixNano() / (int64(c.window) / windowBuckets)
+	for key, w := range c.windows {
+		if index-w.head >= windowBuckets {
+			delete(c.windows, key)
+		}
+	}
+}
+
+// 一次违规
+type Offense struct {
+	Rule   string
+	Key    string
+	Count  int64
+	Limit  int64
+	Window time.Duration
+	Time   time.Time
+}
+
+func (o *Offense) String() string {
+	return fmt.Sprintf("%s: %s 在%s内%d次，阈值%d", o.Rule, o.Key, o.Window, o.Count, o.Limit)
+}
+
+// 检测规则名称
+const (
+	RuleUserRate  = "userRate"
+	RuleUserBurst = "userBurst"
+	RuleIPRate    = "ipRate"
+	RuleSignFail  = "signFail"
+)
+
+// 异常请求检测，按用户和IP统计滑动窗口内的请求数和签名失败次数
+// 用户维度在用户所属的验证节点上统计，IP维度在接收请求的节点上统计
+type BotDetector struct {
+	userRate  *windowCounter
+	userBurst *windowCounter
+	ipRate    *windowCounter
+	signFail  *windowCounter
+	// 自动拉黑的时长，0为永久有效
+	BanTTL time.Duration
+	// 被封禁的IP及解封时间，IP不在hash环上路由，只在本机生效
+	ipBans map[string]time.Time
+	done   chan struct{}
+	sync.Mutex
+}
+
+func NewBotDetector(config DetectorConfig) (*BotDetector, error) {
+	d := &BotDetector{ipBans: make(map[string]time.Time), done: make(chan struct{})}
+	var err error
+	if d.userRate, err = newWindowCounter(RuleUserRate, config.UserRate); err != nil {
+		return nil, err
+	}
+	if d.userBurst, err = newWindowCounter(RuleUserBurst, config.UserBurst); err != nil {
+		return nil, err
+	}
+	if d.ipRate, err = newWindowCounter(RuleIPRate, config.IPRate); err != nil {
+		return nil, err
+	}
+	if d.signFail, err = newWindowCounter(RuleSignFail, config.SignFail); err != nil {
+		return nil, err
+	}
+	if config.BanTTL != "" {
+		if d.BanTTL, err = time.ParseDuration(config.BanTTL); err != nil {
+			return nil, fmt.Errorf("自动拉黑时长格式错误：%w", err)
+		}
+	}
+	go d.cleanupLoop()
+	return d, nil
+}
+
+// 记录一次用户请求，先检查突发再检查频率
+func (d *BotDetector) ObserveUser(uid string, now time.Time) *Offense {
+	if offense := d.userBurst.observe(uid, now); offense != nil {
+		return offense
+	}
+	return d.userRate.observe(uid, now)
+}
+
+// 记录一次IP请求
+func (d *BotDetector) ObserveIP(ip string, now time.Time) *Offense {
+	return d.ipRate.observe(ip, now)
+}
+
+// 记录一次签名校验失败
+func (d *BotDetector) ObserveSignFailure(ip string, now time.Time) *Offense {
+	return d.signFail.observe(ip, now)
+}
+
+// 封禁IP，ttl<=0为永久
+func (d *BotDetector) BanIP(ip string, ttl time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+	var until time.Time
+	if ttl > 0 {
+		until = time.Now().Add(ttl)
+	}
+	d.ipBans[ip] = until
+}
+
+// IP是否被封禁
+func (d *BotDetector) IPBanned(ip string) bool {
+	d.Lock()
+	defer d.Unlock()
+	until, ok := d.ipBans[ip]
+	if !ok {
+		return false
+	}
+	if !until.IsZero() && time.Now().After(until) {
+		delete(d.ipBans, ip)
+		return false
+	}
+	return true
+}
+
+func (d *BotDetector) Close() {
+	close(d.done)
+}
+
+func (d *BotDetector) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case now := <-ticker.C:
+			for _, c := range []*windowCounter{d.userRate, d.userBurst, d.ipRate, d.signFail} {
+				c.cleanup(now)
+			}
+			d.Lock()
+			for ip, until := range d.ipBans {
+				if !until.IsZero() && now.After(until) {
+					delete(d.ipBans, ip)
+				}
+			}
+			d.Unlock()
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+// 对齐到桶边界的起始时间
+var detectorStart = time.Unix(1000, 0)
+
+func newTestDetector(t *testing.T, config DetectorConfig) *BotDetector {
+	d, err := NewBotDetector(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+// 窗口内达到阈值时放行，超过一次时返回违规，违规后重新计数
+func TestDetectorThresholds(t *testing.T) {
+	tests := []struct {
+		rule    string
+		config  DetectorConfig
+		observe func(d *BotDetector, key string, now time.Time) *Offense
+	}{
+		{RuleUserRate, DetectorConfig{UserRate: DetectorRule{Window: "1m", Limit: 5}}, (*BotDetector).ObserveUser},
+		{RuleUserBurst, DetectorConfig{UserBurst: DetectorRule{Window: "1s", Limit: 3}}, (*BotDetector).ObserveUser},
+		{RuleIPRate, DetectorConfig{IPRate: DetectorRule{Window: "1m", Limit: 4}}, (*BotDetector).ObserveIP},
+		{RuleSignFail, DetectorConfig{SignFail: DetectorRule{Window: "1m", Limit: 2}}, (*BotDetector).ObserveSignFailure},
+	}
+	for _, test := range tests {
+		t.Run(test.rule, func(t *testing.T) {
+			d := newTestDetector(t, test.config)
+			limit := map[string]int64{
+				RuleUserRate:  test.config.UserRate.Limit,
+				RuleUserBurst: test.config.UserBurst.Limit,
+				RuleIPRate:    test.config.IPRate.Limit,
+				RuleSignFail:  test.config.SignFail.Limit,
+			}[test.rule]
+			for round := 0; round < 2; round++ {
+				for i := int64(1); i <= limit; i++ {
+					if offense := test.observe(d, "a", detectorStart); offense != nil {
+						t.Fatalf("第%d轮第%d次触发违规：%s", round, i, offense)
+					}
+				}
+				offense := test.observe(d, "a", detectorStart)
+				if offense == nil {
+					t.Fatalf("第%d轮超过阈值%d没有触发违规", round, limit)
+				}
+				if offense.Rule != test.rule || offense.Key != "a" || offense.Count != limit+1 || offense.Limit != limit {
+					t.Errorf("违规信息错误：%+v", offense)
+				}
+			}
+			// 其他key单独计数
+			if offense := test.observe(d, "b", detectorStart); offense != nil {
+				t.Errorf("其他key触发违规：%s", offense)
+			}
+		})
+	}
+}
+
+// 过期的桶移出窗口，窗口内的请求仍然计数
+func TestDetectorSlidingWindow(t *testing.T) {
+	d := newTestDetector(t, DetectorConfig{IPRate: DetectorRule{Window: "1s", Limit: 3}})
+	steps := []struct {
+		offset time.Duration
+		offend bool
+	}{
+		{0, false},
+		{0, false},
+		{500 * time.Millisecond, false},
+		// 第一个桶移出窗口，剩下500ms时的1次
+		{time.Second, false},
+		{1100 * time.Millisecond, false},
+		{1200 * time.Millisecond, true},
+		// 违规后重新计数
+		{1200 * time.Millisecond, false},
+		// 超过整个窗口没有请求，计数清零
+		{5 * time.Second, false},
+		{5 * time.Second, false},
+		{5 * time.Second, false},
+		{5 * time.Second, true},
+	}
+	for i, step := range steps {
+		offense := d.ObserveIP("10.0.0.1", detectorStart.Add(step.offset))
+		if (offense != nil) != step.offend {
+			t.Fatalf("第%d步(%v)：违规%v，应为%v", i, step.offset, offense, step.offend)
+		}
+	}
+}
+
+// 突发规则先于频率规则检查
+func TestDetectorUserBurstAndRate(t *testing.T) {
+	d := newTestDetector(t, DetectorConfig{
+		UserRate:  DetectorRule{Window: "1m", Limit: 4},
+		UserBurst: DetectorRule{Window: "1s", Limit: 2},
+	})
+	steps := []struct {
+		offset time.Duration
+		rule   string
+	}{
+		{0, ""},
+		{0, ""},
+		{0, RuleUserBurst},
+		// 突发违规的请求不计入频率
+		{2 * time.Second, ""},
+		{4 * time.Second, ""},
+		{6 * time.Second, RuleUserRate},
+	}
+	for i, step := range steps {
+		offense := d.ObserveUser("1", detectorStart.Add(step.offset))
+		var rule string
+		if offense != nil {
+			rule = offense.Rule
+		}
+		if rule != step.rule {
+			t.Fatalf("第%d步：违规规则%q，应为%q", i, rule, step.rule)
+		}
+	}
+}
+
+func TestDetectorDisabledRule(t *testing.T) {
+	d := newTestDetector(t, DetectorConfig{})
+	for i := 0; i < 100; i++ {
+		if d.ObserveUser("1", detectorStart) != nil || d.ObserveIP("10.0.0.1", detectorStart) != nil ||
+			d.ObserveSignFailure("10.0.0.1", detectorStart) != nil {
+			t.Fatal("未配置的规则触发违规")
+		}
+	}
+	d = newTestDetector(t, DetectorConfig{UserRate: DetectorRule{Window: "1m", Limit: 1}})
+	for i := 0; i < 3; i++ {
+		if d.ObserveUser("", detectorStart) != nil {
+			t.Fatal("空key触发违规")
+		}
+	}
+}
+
+func TestDetectorConfigError(t *testing.T) {
+	tests := []DetectorConfig{
+		{UserRate: DetectorRule{Window: "", Limit: 1}},
+		{UserBurst: DetectorRule{Window: "abc", Limit: 1}},
+		{IPRate: DetectorRule{Window: "5ns", Limit: 1}},
+		{BanTTL: "forever"},
+	}
+	for _, config := range tests {
+		if d, err := NewBotDetector(config); err == nil {
+			d.Close()
+			t.Errorf("%+v: 配置错误没有返回错误", config)
+		}
+	}
+	// 不检测的规则不校验窗口
+	d := newTestDetector(t, DetectorConfig{SignFail: DetectorRule{Window: "abc", Limit: 0}})
+	if d.signFail != nil {
+		t.Error("阈值为0的规则仍然检测")
+	}
+}
+
+func TestDetectorCleanup(t *testing.T) {
+	d := newTestDetector(t, DetectorConfig{IPRate: DetectorRule{Window: "1s", Limit: 10}})
+	d.ObserveIP("10.0.0.1", detectorStart)
+	d.ObserveIP("10.0.0.2", detectorStart.Add(900*time.Millisecond))
+	d.ipRate.cleanup(detectorStart.Add(time.Second))
+	if _, ok := d.ipRate.windows["10.0.0.1"]; ok {
+		t.Error("窗口内没有请求的key没有清理")
+	}
+	if _, ok := d.ipRate.windows["10.0.0.2"]; !ok {
+		t.Error("窗口内仍有请求的key被清理")
+	}
+}
+
+func TestDetectorIPBan(t *testing.T) {
+	d := newTestDetector(t, DetectorConfig{})
+	d.BanIP("10.0.0.1", 0)
+	d.BanIP("10.0.0.2", -time.Second)
+	d.BanIP("10.0.0.3", time.Hour)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if !d.IPBanned(ip) {
+			t.Errorf("%s没有封禁", ip)
+		}
+	}
+	// 到期后解封
+	d.ipBans["10.0.0.3"] = time.Now().Add(-time.Second)
+	if d.IPBanned("10.0.0.3") || d.IPBanned("10.0.0.4") {
+		t.Error("未封禁或已到期的IP仍然封禁")
+	}
+}
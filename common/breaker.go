@@ -0,0 +1,117 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("节点熔断中")
+
+// 熔断器状态
+const (
+	BreakerClosed = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// 单个节点的熔断状态
+type breakerState struct {
+	state int
+	// 连续失败次数
+	failures int
+	// 熔断开始时间
+	openedAt time.Time
+	// 半开状态下是否已有探测请求
+	probing bool
+}
+
+// 按节点熔断，连续失败达到阈值后熔断，冷却时间后放行一个探测请求，
+// 探测成功则恢复，失败则继续熔断
+type BreakerGroup struct {
+	threshold int
+	cooldown  time.Duration
+	states    map[string]*breakerState
+	// 当前时间，测试时替换
+	now func() time.Time
+	sync.Mutex
+}
+
+func NewBreakerGroup(threshold int, cooldown time.Duration) *BreakerGroup {
+	return &BreakerGroup{
+		threshold: threshold,
+		cooldown:  cooldown,
+		states:    make(map[string]*breakerState),
+		now:       time.Now,
+	}
+}
+
+func (b *BreakerGroup) get(node string) *breakerState {
+	s, ok := b.states[node]
+	if !ok {
+		s = &breakerState{}
+		b.states[node] = s
+	}
+	return s
+}
+
+// 判断是否可以请求节点，返回false时直接失败
+func (b *BreakerGroup) Allow(node string) bool {
+	b.Lock()
+	defer b.Unlock()
+	s := b.get(node)
+	switch s.state {
+	case BreakerOpen:
+		if b.now().Sub(s.openedAt) < b.cooldown {
+			return false
+		}
+		s.state = BreakerHalfOpen
+		s.probing = true
+		return true
+	case BreakerHalfOpen:
+		if s.probing {
+			return false
+		}
+		s.probing = true
+		return true
+	}
+	return true
+}
+
+// 记录请求成功
+func (b *BreakerGroup) Success(node string) {
+	b.Lock()
+	defer b.Unlock()
+	s := b.get(node)
+	s.state = BreakerClosed
+	s.failures = 0
+	s.probing = false
+}
+
+// 记录请求失败
+func (b *BreakerGroup) Failure(node string) {
+	b.Lock()
+	defer b.Unlock()
+	s := b.get(node)
+	s.failures++
+	s.probing = false
+	if s.state == BreakerHalfOpen || s.failures >= b.threshold {
+		s.state = BreakerOpen
+		s.openedAt = b.now()
+	}
+}
+
+// 请求被调用方取消，不能说明节点是否可用，不计入失败，
+// 半开状态下释放探测名额，下一个请求可以继续探测
+func (b *BreakerGroup) Cancel(node string) {
+	b.Lock()
+	defer b.Unlock()
+	b.get(node).probing = false
+}
+
+// 节点当前的熔断状态
+func (b *BreakerGroup) State(node string) int {
+	b.Lock()
+	defer b.Unlock()
+	return b.get(node).state
+}
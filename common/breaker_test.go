@@ -0,0 +1,130 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+// 可以手动推进的时钟
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestBreaker(threshold int, cooldown time.Duration) (*BreakerGroup, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1600000000, 0)}
+	b := NewBreakerGroup(threshold, cooldown)
+	b.now = clock.Now
+	return b, clock
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	const node = "10.0.0.1:8083"
+	b, clock := newTestBreaker(3, time.Second)
+
+	// 连续失败未达到阈值时保持关闭
+	for i := 0; i < 2; i++ {
+		if !b.Allow(node) {
+			t.Fatalf("第%d次请求被拒绝", i+1)
+		}
+		b.Failure(node)
+	}
+	if b.State(node) != BreakerClosed {
+		t.Fatalf("失败2次后状态为%d，应为关闭", b.State(node))
+	}
+	// 成功后重新计数
+	b.Success(node)
+	for i := 0; i < 3; i++ {
+		b.Allow(node)
+		b.Failure(node)
+	}
+	if b.State(node) != BreakerOpen {
+		t.Fatalf("连续失败3次后状态为%d，应为熔断", b.State(node))
+	}
+	if b.Allow(node) {
+		t.Fatal("冷却时间内放行了请求")
+	}
+
+	// 冷却后只放行一个探测请求
+	clock.Advance(time.Second)
+	if !b.Allow(node) {
+		t.Fatal("冷却后没有放行探测请求")
+	}
+	if b.State(node) != BreakerHalfOpen {
+		t.Fatalf("探测时状态为%d，应为半开", b.State(node))
+	}
+	if b.Allow(node) {
+		t.Fatal("探测期间放行了第二个请求")
+	}
+
+	// 探测请求被调用方取消，不计入失败，下一个请求可以继续探测
+	b.Cancel(node)
+	if b.State(node) != BreakerHalfOpen {
+		t.Fatalf("探测取消后状态为%d，应为半开", b.State(node))
+	}
+	if !b.Allow(node) {
+		t.Fatal("探测取消后没有放行下一个探测请求")
+	}
+	if b.Allow(node) {
+		t.Fatal("探测期间放行了第二个请求")
+	}
+
+	// 探测失败重新熔断
+	b.Failure(node)
+	if b.State(node) != BreakerOpen || b.Allow(node) {
+		t.Fatal("探测失败后没有重新熔断")
+	}
+
+	// 再次冷却后探测成功，恢复关闭
+	clock.Advance(time.Second)
+	if !b.Allow(node) {
+		t.Fatal("冷却后没有放行探测请求")
+	}
+	b.Success(node)
+	if b.State(node) != BreakerClosed {
+		t.Fatalf("探测成功后状态为%d，应为关闭", b.State(node))
+	}
+	for i := 0; i < 5; i++ {
+		if !b.Allow(node) {
+			t.Fatal("恢复后请求被拒绝")
+		}
+	}
+}
+
+// 关闭状态下取消请求不影响计数
+func TestBreakerCancelWhenClosed(t *testing.T) {
+	const node = "10.0.0.1:8083"
+	b, _ := newTestBreaker(2, time.Second)
+	b.Allow(node)
+	b.Failure(node)
+	b.Allow(node)
+	b.Cancel(node)
+	if b.State(node) != BreakerClosed {
+		t.Fatalf("取消后状态为%d，应为关闭", b.State(node))
+	}
+	b.Allow(node)
+	b.Failure(node)
+	if b.State(node) != BreakerOpen {
+		t.Fatalf("连续失败2次后状态为%d，应为熔断", b.State(node))
+	}
+}
+
+// 不同节点的状态互不影响
+func TestBreakerPerNode(t *testing.T) {
+	b, _ := newTestBreaker(1, time.Second)
+	b.Allow("a")
+	b.Failure("a")
+	if b.Allow("a") {
+		t.Fatal("节点a没有熔断")
+	}
+	if !b.Allow("b") {
+		t.Fatal("节点b被节点a的失败熔断")
+	}
+}
@@ -2,7 +2,7 @@ package common
 
 import (
 	"errors"
-	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 	"sync"
@@ -28,14 +28,29 @@ func (x units) Swap(i, j int) {
 
 var errEmpty = errors.New("hash 环没有数据")
 
+// 默认的负载上限系数
+const DefaultLoadFactor = 1.25
+
 // 创建结构体保存一致性hash信息
 type Consistent struct {
 	// hash环, key为哈希值, 值存放节点信息
 	circle map[uint32]string
 	// 已经排序的节点hash切片
 	sortedHashes units
-	// 虚拟节点个数, 用来增加hash的平衡性
+	// 每个权重单位的虚拟节点个数, 用来增加hash的平衡性
 	VirtualNode int
+	// hash函数，需要在添加节点前设置，为nil时使用CRC32
+	Hash HashFunc
+	// 有界负载的上限系数，节点负载不超过平均负载乘以该系数
+	LoadFactor float64
+	// 配置的节点权重，节点移除后仍然保留，重新加入时使用
+	weights map[string]int
+	// 在环上的节点及其权重
+	nodes       map[string]int
+	totalWeight int
+	// 有界负载模式下每个节点当前的负载
+	loads     map[string]int64
+	totalLoad int64
 	// map 读写锁
 	sync.RWMutex
 }
@@ -47,26 +62,25 @@ func NewConsistent() *Consistent {
 		circle: make(map[uint32]string),
 		// 设置虚拟节点个数
 		VirtualNode: 20,
+		LoadFactor:  DefaultLoadFactor,
+		weights:     make(map[string]int),
+		nodes:       make(map[string]int),
+		loads:       make(map[string]int64),
 	}
 }
 
 // 自动生成key值
 func (c *Consistent) generateKey(element string, index int) string {
-	// 副本key生成逻辑
+	// 副本key生成逻辑，格式决定虚拟节点在环上的位置，修改后所有用户的映射都会变化
 	return element + strconv.Itoa(index)
 }
 
 // 获取hash位置
 func (c *Consistent) hashKey(key string) uint32 {
-	if len(key) < 64 {
-		// 声明一个数组长度为64
-		var srcatch [64]byte
-		// 拷贝数据到数组中
-		copy(srcatch[:], key)
-		// 使用IEEE多项式返回数据的CRC-32校验和
-		return crc32.ChecksumIEEE(srcatch[:len(key)])
+	if c.Hash == nil {
+		return HashCRC32([]byte(key))
 	}
-	return crc32.ChecksumIEEE([]byte(key))
+	return c.Hash([]byte(key))
 }
 
 // 更新排序，方便查找
@@ -90,29 +104,73 @@ func (c *Consistent) updateSortedHashes() {
 
 }
 
+// 添加节点，使用配置的权重，未配置时权重为1
 func (c *Consistent) Add(element string) {
 	// 加锁
 	c.Lock()
 	// 解锁
 	defer c.Unlock()
-	c.add(element)
+	c.add(element, c.weightOf(element))
+}
+
+// 按权重添加节点，权重越大分到的虚拟节点越多
+func (c *Consistent) AddWeighted(element string, weight int) {
+	c.Lock()
+	defer c.Unlock()
+	c.weights[element] = weight
+	c.add(element, weight)
+}
+
+// 设置节点权重，节点已在环上时立即生效
+func (c *Consistent) SetWeight(element string, weight int) {
+	c.Lock()
+	defer c.Unlock()
+	c.weights[element] = weight
+	if _, ok := c.nodes[element]; ok {
+		c.add(element, weight)
+	}
+}
+
+func (c *Consistent) weightOf(element string) int {
+	if weight, ok := c.weights[element]; ok {
+		return weight
+	}
+	return 1
 }
 
 // 添加节点
-func (c *Consistent) add(element string) {
+func (c *Consistent) add(element string, weight int) {
+	if _, ok := c.nodes[element]; ok {
+		c.remove(element)
+	}
+	if weight <= 0 {
+		return
+	}
 	// 循环虚拟节点，设置副本
-	for i := 0; i < c.VirtualNode; i++ {
+	for i := 0; i < c.VirtualNode*weight; i++ {
 		c.circle[c.hashKey(c.generateKey(element, i))] = element
 	}
+	c.nodes[element] = weight
+	c.totalWeight += weight
 	// 更新排序
 	c.updateSortedHashes()
 }
 
 // 删除节点
 func (c *Consistent) remove(element string) {
-	for i := 0; i < c.VirtualNode; i++ {
-		delete(c.circle, c.hashKey(c.generateKey(element, i)))
+	weight, ok := c.nodes[element]
+	if !ok {
+		return
+	}
+	for i := 0; i < c.VirtualNode*weight; i++ {
+		key := c.hashKey(c.generateKey(element, i))
+		// 不同节点的虚拟节点hash冲突时不删除其他节点
+		if c.circle[key] == element {
+			delete(c.circle, key)
+		}
 	}
+	delete(c.nodes, element)
+	c.totalWeight -= weight
 	c.updateSortedHashes()
 }
 
@@ -123,6 +181,18 @@ func (c *Consistent) Remove(element string) {
 	c.remove(element)
 }
 
+// 环上的所有节点
+func (c *Consistent) Members() []string {
+	c.RLock()
+	defer c.RUnlock()
+	members := make([]string, 0, len(c.nodes))
+	for node := range c.nodes {
+		members = append(members, node)
+	}
+	sort.Strings(members)
+	return members
+}
+
 // 顺时针查找最近的节点
 func (c *Consistent) search(key uint32) int {
 	// 查找算法
@@ -151,3 +221,144 @@ func (c *Consistent) Get(name string) (string, error) {
 	i := c.search(key)
 	return c.circle[c.sortedHashes[i]], nil
 }
+
+// 获取数据标识顺时针方向上的n个不同节点，第一个为所属节点，其余为副本节点
+// 环上节点不足n个时返回全部节点
+func (c *Consistent) GetN(name string, n int) ([]string, error) {
+	c.RLock()
+	defer c.RUnlock()
+	if len(c.circle) == 0 {
+		return nil, errEmpty
+	}
+	if n > len(c.nodes) {
+		n = len(c.nodes)
+	}
+	start := c.search(c.hashKey(name))
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(c.sortedHashes) && len(nodes) < n; i++ {
+		node := c.circle[c.sortedHashes[(start+i)%len(c.sortedHashes)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+// 节点在有界负载模式下的负载上限，按权重分摊
+func (c *Consistent) capacity(element string) int64 {
+	factor := c.LoadFactor
+	if factor < 1 {
+		factor = 1
+	}
+	share := float64(c.nodes[element]) / float64(c.totalWeight)
+	return int64(math.Ceil(float64(c.totalLoad+1) * factor * share))
+}
+
+// 有界负载的一致性hash，从最近的节点开始顺时针查找第一个未超过负载上限的节点，
+// 并为其增加一个负载，处理完成后需要调用Release
+func (c *Consistent) Acquire(name string) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.circle) == 0 {
+		return "", errEmpty
+	}
+	start := c.search(c.hashKey(name))
+	node := c.circle[c.sortedHashes[start]]
+	checked := make(map[string]bool, len(c.nodes))
+	for i := 0; i < len(c.sortedHashes) && len(checked) < len(c.nodes); i++ {
+		candidate := c.circle[c.sortedHashes[(start+i)%len(c.sortedHashes)]]
+		if checked[candidate] {
+			continue
+		}
+		checked[candidate] = true
+		if c.loads[candidate]+1 <= c.capacity(candidate) {
+			node = candidate
+			break
+		}
+	}
+	c.loads[node]++
+	c.totalLoad++
+	return node, nil
+}
+
+// 释放Acquire增加的负载
+func (c *Consistent) Release(element string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.loads[element] <= 0 {
+		return
+	}
+	c.loads[element]--
+	c.totalLoad--
+	if c.loads[element] == 0 {
+		delete(c.loads, element)
+	}
+}
+
+// 有界负载模式下每个节点当前的负载
+func (c *Consistent) Loads() map[string]int64 {
+	c.RLock()
+	defer c.RUnlock()
+	loads := make(map[string]int64, len(c.loads))
+	for node, load := range c.loads {
+		loads[node] = load
+	}
+	return loads
+}
+
+// 节点在hash环上的分布
+type NodeShare struct {
+	Weight int
+	// 实际分到的hash空间比例
+	Share float64
+	// 按权重应分到的比例
+	Expected float64
+}
+
+// hash环的分布统计
+type DistributionStats struct {
+	Nodes map[string]*NodeShare
+	// 实际比例与应分比例之比的最大值和最小值，越接近1越均衡
+	MaxRatio float64
+	MinRatio float64
+	// 实际比例与应分比例之比的标准差
+	StdDev float64
+}
+
+// 统计每个节点分到的hash空间，key均匀分布时即为每个节点承担的请求比例
+func (c *Consistent) Distribution() *DistributionStats {
+	c.RLock()
+	defer c.RUnlock()
+	stats := &DistributionStats{Nodes: make(map[string]*NodeShare, len(c.nodes))}
+	if len(c.sortedHashes) == 0 {
+		return stats
+	}
+	for node, weight := range c.nodes {
+		stats.Nodes[node] = &NodeShare{Weight: weight, Expected: float64(weight) / float64(c.totalWeight)}
+	}
+	// hash值落在 [前一个虚拟节点, 当前虚拟节点) 的key属于当前虚拟节点，第一个虚拟节点还包括环尾部
+	const ringSize = float64(1 << 32)
+	prev := uint64(c.sortedHashes[len(c.sortedHashes)-1])
+	for i, hash := range c.sortedHashes {
+		arc := uint64(hash) - prev
+		if i == 0 {
+			arc = uint64(hash) + (1 << 32) - prev
+		}
+		stats.Nodes[c.circle[hash]].Share += float64(arc) / ringSize
+		prev = uint64(hash)
+	}
+	stats.MinRatio = math.MaxFloat64
+	var sum, sumSquare float64
+	for _, share := range stats.Nodes {
+		ratio := share.Share / share.Expected
+		stats.MaxRatio = math.Max(stats.MaxRatio, ratio)
+		stats.MinRatio = math.Min(stats.MinRatio, ratio)
+		sum += ratio
+		sumSquare += ratio * ratio
+	}
+	n := float64(len(stats.Nodes))
+	stats.StdDev = math.Sqrt(math.Max(sumSquare/n-(sum/n)*(sum/n), 0))
+	return stats
+}
@@ -0,0 +1,223 @@
+package common
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+var hashNames = []string{"crc32", "fnv", "murmur3", "xxhash"}
+
+var testHosts = []string{"10.0.0.1:8083", "10.0.0.2:8083", "10.0.0.3:8083", "10.0.0.4:8083", "10.0.0.5:8083"}
+
+// 散列均匀的hash函数，分布按下面的方差估计检查
+// crc32和fnv是线性的，对只有末尾字符不同的虚拟节点key散列较差，虚拟节点在环上聚集，
+// 偏差明显超过估计值，只检查比例之和
+var uniformHashes = map[string]bool{"murmur3": true, "xxhash": true}
+
+// 散列均匀时，节点分到的hash空间是 虚拟节点数*权重 段弧长之和，
+// 实际比例与应分比例p之比的标准差约为 sqrt((1-p)/(虚拟节点数*权重))，
+// 偏差超过boundSigma个标准差视为分布不均，单个节点误报的概率约为7e-6
+const boundSigma = 4.5
+
+func shareBound(share *NodeShare, virtualNode int) float64 {
+	return boundSigma * math.Sqrt((1-share.Expected)/float64(virtualNode*share.Weight))
+}
+
+// 检查各节点比例之和为1，散列均匀时每个节点的实际比例都在应分比例的范围内
+func checkShares(t *testing.T, hashName string, stats *DistributionStats, virtualNode int) {
+	var total float64
+	for host, share := range stats.Nodes {
+		ratio := share.Share / share.Expected
+		if bound := shareBound(share, virtualNode); uniformHashes[hashName] && math.Abs(ratio-1) > bound {
+			t.Errorf("%s: 权重%d 实际比例/应分比例=%.3f，超出1±%.3f", host, share.Weight, ratio, bound)
+		}
+		total += share.Share
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("hash空间比例之和为%f", total)
+	}
+}
+
+func newTestRing(t testing.TB, hashName string, virtualNode int) *Consistent {
+	ring := NewConsistent()
+	hash, err := HashFuncByName(hashName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.Hash = hash
+	ring.VirtualNode = virtualNode
+	for _, host := range testHosts {
+		ring.Add(host)
+	}
+	return ring
+}
+
+// 一半请求来自少量热点用户，其余随机
+func requestKeys(num int) []string {
+	r := rand.New(rand.NewSource(1))
+	keys := make([]string, num)
+	for i := range keys {
+		if r.Float64() < 0.5 {
+			keys[i] = strconv.Itoa(r.Intn(10))
+		} else {
+			keys[i] = strconv.Itoa(r.Intn(1000000))
+		}
+	}
+	return keys
+}
+
+func TestConsistentSpread(t *testing.T) {
+	for _, name := range hashNames {
+		t.Run(name, func(t *testing.T) {
+			stats := newTestRing(t, name, 100).Distribution()
+			t.Logf("max/expected=%.3f min/expected=%.3f stddev=%.3f", stats.MaxRatio, stats.MinRatio, stats.StdDev)
+			checkShares(t, name, stats, 100)
+		})
+	}
+}
+
+func TestConsistentWeight(t *testing.T) {
+	for _, name := range hashNames {
+		t.Run(name, func(t *testing.T) {
+			ring := newTestRing(t, name, 100)
+			ring.SetWeight(testHosts[0], 3)
+			ring.SetWeight(testHosts[1], 2)
+			stats := ring.Distribution()
+			// 总权重 3+2+1+1+1
+			for host, weight := range map[string]int{testHosts[0]: 3, testHosts[1]: 2, testHosts[2]: 1} {
+				share := stats.Nodes[host]
+				if share.Weight != weight || math.Abs(share.Expected-float64(weight)/8) > 1e-9 {
+					t.Errorf("%s: 权重%d应分比例%.3f", host, share.Weight, share.Expected)
+				}
+			}
+			t.Logf("max/expected=%.3f min/expected=%.3f", stats.MaxRatio, stats.MinRatio)
+			checkShares(t, name, stats, 100)
+			if stats.Nodes[testHosts[0]].Share <= stats.Nodes[testHosts[1]].Share ||
+				stats.Nodes[testHosts[1]].Share <= stats.Nodes[testHosts[2]].Share {
+				t.Errorf("分到的比例没有随权重增加")
+			}
+			// 权重0的节点移出hash环，恢复权重后需要重新添加
+			ring.SetWeight(testHosts[4], 0)
+			if _, ok := ring.Distribution().Nodes[testHosts[4]]; ok {
+				t.Errorf("权重为0的节点仍在hash环上")
+			}
+			ring.SetWeight(testHosts[4], 1)
+			ring.Add(testHosts[4])
+			if share, ok := ring.Distribution().Nodes[testHosts[4]]; !ok || share.Weight != 1 {
+				t.Errorf("恢复权重后节点没有重新加入")
+			}
+		})
+	}
+}
+
+// 热点用户的请求一直保持不释放，每个节点的负载不超过 总负载*系数*权重比例
+func TestConsistentBoundedLoad(t *testing.T) {
+	keys := requestKeys(100000)
+	for _, name := range hashNames {
+		t.Run(name, func(t *testing.T) {
+			// 普通一致性hash，请求全部落在所属节点
+			plain := newTestRing(t, name, 100)
+			plainLoads := make(map[string]int64)
+			for _, key := range keys {
+				host, err := plain.Get(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				plainLoads[host]++
+			}
+			bounded := newTestRing(t, name, 100)
+			acquireAll(t, bounded, keys)
+			average := int64(len(keys) / len(testHosts))
+			t.Logf("average=%d get max=%d acquire max=%d", average, maxLoad(plainLoads), maxLoad(bounded.Loads()))
+			limit := int64(math.Ceil(float64(average) * DefaultLoadFactor))
+			if maxLoad(plainLoads) <= limit {
+				t.Fatalf("普通一致性hash没有出现热点，测试数据无效")
+			}
+			if maxLoad(bounded.Loads()) > limit {
+				t.Errorf("有界负载的最大负载%d超过上限%d", maxLoad(bounded.Loads()), limit)
+			}
+			// 加权后上限按权重分摊
+			weighted := newTestRing(t, name, 100)
+			weighted.SetWeight(testHosts[0], 2)
+			acquireAll(t, weighted, keys)
+			// 全部释放后负载归零
+			for host, load := range weighted.Loads() {
+				for i := int64(0); i < load; i++ {
+					weighted.Release(host)
+				}
+			}
+			if len(weighted.Loads()) != 0 {
+				t.Errorf("释放后仍有负载：%v", weighted.Loads())
+			}
+		})
+	}
+}
+
+// 保持所有请求不释放，检查每个节点的负载都不超过按权重分摊的上限
+func acquireAll(t *testing.T, ring *Consistent, keys []string) {
+	for _, key := range keys {
+		if _, err := ring.Acquire(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var total int64
+	for host, load := range ring.Loads() {
+		share := float64(ring.nodes[host]) / float64(ring.totalWeight)
+		limit := int64(math.Ceil(float64(len(keys)) * ring.LoadFactor * share))
+		if load > limit {
+			t.Errorf("%s: 负载%d超过上限%d", host, load, limit)
+		}
+		total += load
+	}
+	if total != int64(len(keys)) {
+		t.Errorf("总负载%d，应为%d", total, len(keys))
+	}
+}
+
+func maxLoad(loads map[string]int64) int64 {
+	var max int64
+	for _, load := range loads {
+		if load > max {
+			max = load
+		}
+	}
+	return max
+}
+
+func benchmarkGet(b *testing.B, hashName string) {
+	ring := newTestRing(b, hashName, 100)
+	keys := requestKeys(1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ring.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkGetCRC32(b *testing.B) {
+	benchmarkGet(b, "crc32")
+}
+
+func BenchmarkGetFNV(b *testing.B) {
+	benchmarkGet(b, "fnv")
+}
+
+func BenchmarkGetMurmur3(b *testing.B) {
+	benchmarkGet(b, "murmur3")
+}
+
+func BenchmarkGetXXHash(b *testing.B) {
+	benchmarkGet(b, "xxhash")
+}
+
+// 有界负载每次获取后立即释放，衡量额外的加锁和查找开销
+func BenchmarkAcquireXXHash(b *testing.B) {
+	ring := newTestRing(b, "xxhash", 100)
+	keys := requestKeys(1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		host, _ := ring.Acquire(keys[i%len(keys)])
+		ring.Release(host)
+	}
+}
@@ -1,31 +1,28 @@
 package common
 
-import (
-	"net/http"
-	"strings"
-)
+import "net/http"
 
 // 声明一个新的数据类型(函数类型)
 type FilterHandle func(rw http.ResponseWriter, req *http.Request) error
 
 // 拦截器结构体
 type Filter struct {
-	// 用来存储要拦截的uri
-	filterMap map[string]FilterHandle
+	// 用来存储要拦截的uri，每个uri按注册顺序执行多个拦截器
+	filterMap map[string][]FilterHandle
 }
 
 // Filter初始化函数
 func NewFilter() *Filter {
-	return &Filter{filterMap: make(map[string]FilterHandle)}
+	return &Filter{filterMap: make(map[string][]FilterHandle)}
 }
 
-// 注册拦截器
-func (f *Filter) RegisterFilterUri(uri string, handle FilterHandle) {
-	f.filterMap[uri] = handle
+// 注册拦截器，同一个uri多次注册时追加在后面
+func (f *Filter) RegisterFilterUri(uri string, handles ...FilterHandle) {
+	f.filterMap[uri] = append(f.filterMap[uri], handles...)
 }
 
 // 根据uri获取对应的handler
-func (f *Filter) GetFilterHandle(uri string) FilterHandle {
+func (f *Filter) GetFilterHandles(uri string) []FilterHandle {
 	return f.filterMap[uri]
 }
 
@@ -35,16 +32,14 @@ type WebHandle func(rw http.ResponseWriter, req *http.Request)
 // 执行拦截器，返回函数类型
 func (f *Filter) Handle(webHandle WebHandle) func(rw http.ResponseWriter, req *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
-		for path, handle := range f.filterMap {
-			if strings.Contains(req.RequestURI, path) {
-				//执行拦截业务逻辑
-				err := handle(rw, req)
-				if err != nil {
-					rw.Write([]byte(err.Error()))
-					return
-				}
-				// 跳出循环
-				break
+		// 按路径精确匹配，避免/check同时匹配到/checkRight
+		for _, handle := range f.filterMap[req.URL.Path] {
+			//执行拦截业务逻辑
+			err := handle(rw, req)
+			if err != nil {
+				// 拦截器没有指定错误码时视为没有权限
+				WriteError(rw, err, CodeForbidden)
+				return
 			}
 		}
 		// 执行正常注册的函数
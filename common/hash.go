@@ -0,0 +1,127 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"hash/fnv"
+	"math/bits"
+)
+
+// 一致性hash使用的hash函数
+type HashFunc func(data []byte) uint32
+
+// 按名称选择hash函数：crc32、fnv、murmur3、xxhash
+// crc32和fnv对相近的虚拟节点key散列较差，分布更均匀的是murmur3和xxhash，更换后所有映射都会变化
+func HashFuncByName(name string) (HashFunc, error) {
+	switch name {
+	case "", "crc32":
+		return HashCRC32, nil
+	case "fnv":
+		return HashFNV, nil
+	case "murmur3":
+		return HashMurmur3, nil
+	case "xxhash":
+		return HashXXHash, nil
+	}
+	return nil, errors.New("未知的hash函数：" + name)
+}
+
+// 使用IEEE多项式的CRC-32校验和
+func HashCRC32(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// FNV-1a
+func HashFNV(data []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(data)
+	return h.Sum32()
+}
+
+// MurmurHash3 x86 32位版本，种子为0
+func HashMurmur3(data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	var h uint32
+	n := len(data)
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+		data = data[4:]
+	}
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(n)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// xxHash 32位版本，种子为0
+func HashXXHash(data []byte) uint32 {
+	var (
+		p1 uint32 = 2654435761
+		p2 uint32 = 2246822519
+		p3 uint32 = 3266489917
+		p4 uint32 = 668265263
+		p5 uint32 = 374761393
+	)
+	round := func(v uint32, lane uint32) uint32 {
+		return bits.RotateLeft32(v+lane*p2, 13) * p1
+	}
+	n := len(data)
+	var h uint32
+	if n >= 16 {
+		v1, v2, v3, v4 := p1+p2, p2, uint32(0), -p1
+		for len(data) >= 16 {
+			v1 = round(v1, binary.LittleEndian.Uint32(data[0:]))
+			v2 = round(v2, binary.LittleEndian.Uint32(data[4:]))
+			v3 = round(v3, binary.LittleEndian.Uint32(data[8:]))
+			v4 = round(v4, binary.LittleEndian.Uint32(data[12:]))
+			data = data[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = p5
+	}
+	h += uint32(n)
+	for len(data) >= 4 {
+		h += binary.LittleEndian.Uint32(data) * p3
+		h = bits.RotateLeft32(h, 17) * p4
+		data = data[4:]
+	}
+	for _, b := range data {
+		h += uint32(b) * p5
+		h = bits.RotateLeft32(h, 11) * p1
+	}
+	h ^= h >> 15
+	h *= p2
+	h ^= h >> 13
+	h *= p3
+	h ^= h >> 16
+	return h
+}
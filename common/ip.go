@@ -1,6 +1,10 @@
 package common
 
-import "net"
+import (
+	"net"
+	"net/http"
+	"strings"
+)
 
 func GetIntranetIp() (string, error) {
 	addrs, err := net.InterfaceAddrs()
@@ -18,3 +22,21 @@ func GetIntranetIp() (string, error) {
 	}
 	return "", err
 }
+
+// 获取客户端IP
+// 只有部署在可信的反向代理之后才能信任X-Forwarded-For，否则客户端可以任意伪造
+func GetClientIp(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+		if realIp := r.Header.Get("X-Real-IP"); realIp != "" {
+			return strings.TrimSpace(realIp)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
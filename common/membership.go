@@ -0,0 +1,185 @@
+package common
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 节点健康检查，返回节点是否可用
+type HealthCheck func(node string) bool
+
+// 节点加入或离开hash环时的回调
+type MembershipListener func(node string, alive bool)
+
+// 连续失败多少次后将节点移出hash环
+var healthFailThreshold = 3
+
+// 集群成员管理，定期检查静态节点列表中的节点，
+// 节点失效时从一致性hash环中移除，恢复后重新加入
+type Membership struct {
+	consistent *Consistent
+	// 本机节点，始终在hash环中
+	local string
+	peers []string
+	// 在hash环中的节点
+	alive map[string]bool
+	// 连续检查失败的次数
+	failures map[string]int
+	check    HealthCheck
+	interval time.Duration
+	// 成员变化的回调
+	listeners []MembershipListener
+	done      chan struct{}
+	sync.Mutex
+}
+
+// 创建集群成员管理并同步检查一次所有节点，之后按interval定期检查
+func NewMembership(consistent *Consistent, local string, peers []string, interval time.Duration, check HealthCheck) *Membership {
+	m := &Membership{
+		consistent: consistent,
+		local:      local,
+		alive:      make(map[string]bool),
+		failures:   make(map[string]int),
+		check:      check,
+		interval:   interval,
+		done:       make(chan struct{}),
+	}
+	m.setAlive(local, true)
+	m.SetPeers(peers)
+	m.checkAll(true)
+	go m.run()
+	return m
+}
+
+// HTTP健康检查，请求节点的path并要求返回200
+func NewHTTPHealthCheck(path string, timeout time.Duration) HealthCheck {
+	client := &http.Client{Timeout: timeout}
+	return func(node string) bool {
+		response, err := client.Get("http://" + node + path)
+		if err != nil {
+			return false
+		}
+		response.Body.Close()
+		return response.StatusCode == http.StatusOK
+	}
+}
+
+// 注册成员变化的回调
+func (m *Membership) OnChange(listener MembershipListener) {
+	m.Lock()
+	defer m.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// 更新节点列表，不在列表中的节点立即移出hash环，新节点在下次检查后加入
+func (m *Membership) SetPeers(peers []string) {
+	m.Lock()
+	m.peers = nil
+	keep := map[string]bool{m.local: true}
+	for _, peer := range peers {
+		if peer != m.local && !keep[peer] {
+			keep[peer] = true
+			m.peers = append(m.peers, peer)
+		}
+	}
+	var removed []string
+	for node := range m.alive {
+		if !keep[node] {
+			removed = append(removed, node)
+		}
+	}
+	m.Unlock()
+	for _, node := range removed {
+		m.setAlive(node, false)
+	}
+}
+
+// 当前在hash环中的节点
+func (m *Membership) Members() []string {
+	m.Lock()
+	defer m.Unlock()
+	members := make([]string, 0, len(m.alive))
+	for node := range m.alive {
+		members = append(members, node)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (m *Membership) Close() {
+	close(m.done)
+}
+
+func (m *Membership) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.checkAll(false)
+		}
+	}
+}
+
+// 并发检查所有节点，first为true时一次失败即认为节点不可用
+func (m *Membership) checkAll(first bool) {
+	m.Lock()
+	peers := append([]string(nil), m.peers...)
+	m.Unlock()
+
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			ok := m.check(peer)
+			m.Lock()
+			if ok {
+				m.failures[peer] = 0
+			} else {
+				m.failures[peer]++
+			}
+			dead := !ok && (first || m.failures[peer] >= healthFailThreshold)
+			m.Unlock()
+			if ok {
+				m.setAlive(peer, true)
+			} else if dead {
+				m.setAlive(peer, false)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// 修改节点状态，状态变化时更新hash环并通知回调
+func (m *Membership) setAlive(node string, alive bool) {
+	m.Lock()
+	if m.alive[node] == alive {
+		m.Unlock()
+		return
+	}
+	if alive {
+		m.alive[node] = true
+		m.consistent.Add(node)
+	} else {
+		delete(m.alive, node)
+		delete(m.failures, node)
+		m.consistent.Remove(node)
+	}
+	listeners := append([]MembershipListener(nil), m.listeners...)
+	m.Unlock()
+
+	if alive {
+		log.Printf("节点加入hash环：%s", node)
+	} else {
+		log.Printf("节点移出hash环：%s", node)
+	}
+	for _, listener := range listeners {
+		listener(node, alive)
+	}
+}
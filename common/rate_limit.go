@@ -0,0 +1,147 @@
+package common
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 令牌桶配置
+type RateLimitConfig struct {
+	// 每秒补充的令牌数，0为不限流
+	Rate float64 `json:"rate"`
+	// 桶容量，即允许的突发请求数，小于1时按1处理
+	Burst int `json:"burst"`
+}
+
+// 一个路由的限流配置，按不同维度分别限流，未配置的维度不限流
+type RouteRateLimitConfig struct {
+	// 整个路由的总流量
+	Global RateLimitConfig `json:"global"`
+	// 每个客户端IP
+	IP RateLimitConfig `json:"ip"`
+	// 每个用户，应放在身份验证之后，否则可以伪造uid绕过
+	User RateLimitConfig `json:"user"`
+	// 每个商品
+	Product RateLimitConfig `json:"product"`
+}
+
+// 令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 按key分别计算的令牌桶限流器
+type RateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	// 上次清理已补满的桶的时间
+	lastSweep time.Time
+	// 当前时间，测试时替换
+	now func() time.Time
+	sync.Mutex
+}
+
+// 创建限流器，Rate<=0时返回nil，nil限流器放行所有请求
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	if config.Rate <= 0 {
+		return nil
+	}
+	burst := float64(config.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: config.Rate, burst: burst, buckets: make(map[string]*tokenBucket), lastSweep: time.Now(), now: time.Now}
+}
+
+// 取一个令牌，没有令牌时返回需要等待的时间
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := l.now()
+	l.Lock()
+	defer l.Unlock()
+	l.sweep(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	} else {
+		bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+		bucket.last = now
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := (1 - bucket.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// 每分钟清理一次已经补满的桶，补满的桶与新建的桶没有区别
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// 从请求中取限流的key，返回空字符串时不限流
+type LimitKeyFunc func(req *http.Request) string
+
+// 所有请求共用一个桶
+func GlobalKey(req *http.Request) string {
+	return "*"
+}
+
+// 按客户端IP限流
+func IPKey(trustForwarded bool) LimitKeyFunc {
+	return func(req *http.Request) string {
+		return GetClientIp(req, trustForwarded)
+	}
+}
+
+// 按cookie限流，如用户ID
+func CookieKey(name string) LimitKeyFunc {
+	return func(req *http.Request) string {
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// 按查询参数限流，如商品ID
+func QueryKey(name string) LimitKeyFunc {
+	return func(req *http.Request) string {
+		return req.URL.Query().Get(name)
+	}
+}
+
+// 限流拦截器，超过限制时设置Retry-After并返回RATE_LIMITED
+func RateLimitFilter(limiter *RateLimiter, key LimitKeyFunc) FilterHandle {
+	return func(rw http.ResponseWriter, req *http.Request) error {
+		k := key(req)
+		if k == "" {
+			return nil
+		}
+		allowed, wait := limiter.Allow(k)
+		if allowed {
+			return nil
+		}
+		// Retry-After只支持整秒，向上取整
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return NewCodeError(CodeRateLimited, "")
+	}
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(rate float64, burst int) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1600000000, 0)}
+	l := NewRateLimiter(RateLimitConfig{Rate: rate, Burst: burst})
+	l.now = clock.Now
+	l.lastSweep = clock.Now()
+	return l, clock
+}
+
+// 新的桶允许burst次突发，之后按rate补充
+func TestRateLimiterBurst(t *testing.T) {
+	tests := []struct {
+		burst   int
+		allowed int
+	}{
+		{5, 5},
+		{1, 1},
+		// 小于1时按1处理
+		{0, 1},
+		{-3, 1},
+	}
+	for _, test := range tests {
+		l, _ := newTestLimiter(1, test.burst)
+		for i := 0; i < test.allowed; i++ {
+			if ok, _ := l.Allow("a"); !ok {
+				t.Fatalf("burst=%d: 第%d次请求被拒绝", test.burst, i+1)
+			}
+		}
+		if ok, wait := l.Allow("a"); ok || wait != time.Second {
+			t.Errorf("burst=%d: 超过突发数量后得到%v，等待%v", test.burst, ok, wait)
+		}
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l, clock := newTestLimiter(10, 2)
+	steps := []struct {
+		advance time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{0, true, 0},
+		{0, true, 0},
+		{0, false, 100 * time.Millisecond},
+		// 被拒绝的请求不消耗令牌
+		{40 * time.Millisecond, false, 60 * time.Millisecond},
+		{60 * time.Millisecond, true, 0},
+		{0, false, 100 * time.Millisecond},
+		// 长时间空闲后最多补满到burst
+		{time.Hour, true, 0},
+		{0, true, 0},
+		{0, false, 100 * time.Millisecond},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		ok, wait := l.Allow("a")
+		// 浮点计算的误差
+		if diff := wait - step.wait; ok != step.allowed || diff > time.Microsecond || diff < -time.Microsecond {
+			t.Fatalf("第%d步：得到%v 等待%v，应为%v 等待%v", i, ok, wait, step.allowed, step.wait)
+		}
+	}
+}
+
+// 每个key使用单独的桶
+func TestRateLimiterPerKey(t *testing.T) {
+	l, clock := newTestLimiter(1, 1)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("a的第一次请求被拒绝")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("a超过限制后仍然放行")
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("a超过限制影响了b")
+	}
+	clock.Advance(time.Second)
+	for _, key := range []string{"a", "b"} {
+		if ok, _ := l.Allow(key); !ok {
+			t.Errorf("%s补充令牌后仍然拒绝", key)
+		}
+	}
+}
+
+// 补满的桶被清理，清理后与新建的桶相同
+func TestRateLimiterSweep(t *testing.T) {
+	l, clock := newTestLimiter(1, 2)
+	l.Allow("a")
+	clock.Advance(59 * time.Second)
+	l.Allow("b")
+	l.Allow("b")
+	// 距上次清理满1分钟，a已补满，b只补充了1个令牌
+	clock.Advance(time.Second)
+	l.Allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("已补满的桶没有清理")
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("剩余%d个桶", len(l.buckets))
+	}
+	// 清理后重新创建的桶仍然允许突发
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("清理后第%d次请求被拒绝", i+1)
+		}
+	}
+}
+
+func TestRateLimiterNil(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{Rate: 0, Burst: 10})
+	if l != nil {
+		t.Fatal("Rate为0时应返回nil")
+	}
+	for i := 0; i < 100; i++ {
+		if ok, wait := l.Allow("a"); !ok || wait != 0 {
+			t.Fatal("nil限流器拒绝了请求")
+		}
+	}
+}
+
+func TestRateLimitFilter(t *testing.T) {
+	l, _ := newTestLimiter(0.4, 1)
+	filter := RateLimitFilter(l, QueryKey("productID"))
+	request := func(query string) (*httptest.ResponseRecorder, error) {
+		rw := httptest.NewRecorder()
+		return rw, filter(rw, httptest.NewRequest(http.MethodGet, "/check?"+query, nil))
+	}
+	if _, err := request("productID=1"); err != nil {
+		t.Fatal(err)
+	}
+	rw, err := request("productID=1")
+	codeErr, ok := err.(*CodeError)
+	if !ok || codeErr.Code != CodeRateLimited {
+		t.Fatalf("超过限制得到%v", err)
+	}
+	// 等待2.5秒，向上取整
+	if retry := rw.Header().Get("Retry-After"); retry != "3" {
+		t.Errorf("Retry-After为%q", retry)
+	}
+	// 取不到key时不限流
+	for i := 0; i < 3; i++ {
+		if _, err := request(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// 生成全局唯一的请求ID，用于订单幂等
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// 随机数不可用时退化为时间戳
+		return strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return hex.EncodeToString(b)
+}
+
+// 请求ID最大长度，与order_table.requestID字段一致
+const maxRequestIDLen = 64
+
+// 使用客户端传入的请求ID，未传或不合法时生成新的
+func RequestIDFrom(requestID string) string {
+	if requestID == "" || len(requestID) > maxRequestIDLen {
+		return NewRequestID()
+	}
+	for _, c := range requestID {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+			return NewRequestID()
+		}
+	}
+	return requestID
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// 接口错误码，客户端按错误码区分失败原因，取值保持稳定
+type Code string
+
+const (
+	CodeOK Code = "OK"
+	// 请求参数错误
+	CodeInvalidRequest Code = "INVALID_REQUEST"
+	// 未登录或登录信息无效
+	CodeUnauthenticated Code = "UNAUTHENTICATED"
+	// 没有权限
+	CodeForbidden Code = "FORBIDDEN"
+	// 秒杀令牌无效或过期
+	CodeTokenInvalid Code = "TOKEN_INVALID"
+	// 用户在黑名单中
+	CodeBlacklisted Code = "BLACKLISTED"
+	CodeNotFound    Code = "NOT_FOUND"
+	// 商品已售罄
+	CodeSoldOut Code = "SOLD_OUT"
+	// 超过限购数量
+	CodePurchaseLimited Code = "PURCHASE_LIMITED"
+	// 不在秒杀活动时间内
+	CodeNotInCampaign Code = "NOT_IN_CAMPAIGN"
+	// 访问过于频繁
+	CodeRateLimited Code = "RATE_LIMITED"
+	CodeInternal    Code = "INTERNAL"
+	// 依赖的服务暂时不可用，可以稍后重试
+	CodeUnavailable Code = "UNAVAILABLE"
+)
+
+var codeStatus = map[Code]int{
+	CodeOK:              http.StatusOK,
+	CodeInvalidRequest:  http.StatusBadRequest,
+	CodeUnauthenticated: http.StatusUnauthorized,
+	CodeForbidden:       http.StatusForbidden,
+	CodeTokenInvalid:    http.StatusForbidden,
+	CodeBlacklisted:     http.StatusForbidden,
+	CodeNotFound:        http.StatusNotFound,
+	CodeSoldOut:         http.StatusConflict,
+	CodePurchaseLimited: http.StatusConflict,
+	CodeNotInCampaign:   http.StatusConflict,
+	CodeRateLimited:     http.StatusTooManyRequests,
+	CodeInternal:        http.StatusInternalServerError,
+	CodeUnavailable:     http.StatusServiceUnavailable,
+}
+
+var codeMessage = map[Code]string{
+	CodeOK:              "成功",
+	CodeInvalidRequest:  "请求参数错误",
+	CodeUnauthenticated: "请先登录",
+	CodeForbidden:       "没有权限",
+	CodeTokenInvalid:    "秒杀令牌无效或已过期",
+	CodeBlacklisted:     "账号已被限制",
+	CodeNotFound:        "资源不存在",
+	CodeSoldOut:         "商品已售罄",
+	CodePurchaseLimited: "超过限购数量",
+	CodeNotInCampaign:   "不在活动时间内",
+	CodeRateLimited:     "访问过于频繁，请稍后再试",
+	CodeInternal:        "服务器内部错误",
+	CodeUnavailable:     "服务繁忙，请稍后再试",
+}
+
+// 错误码对应的HTTP状态码
+func (c Code) Status() int {
+	if status, ok := codeStatus[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// 错误码的默认说明
+func (c Code) Message() string {
+	return codeMessage[c]
+}
+
+// 统一的响应结构
+type Response struct {
+	Code    Code        `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// 带错误码的错误
+type CodeError struct {
+	Code    Code
+	Message string
+}
+
+func (e *CodeError) Error() string {
+	return e.Message
+}
+
+// 创建带错误码的错误，message为空时使用错误码的默认说明
+func NewCodeError(code Code, message string) *CodeError {
+	if message == "" {
+		message = code.Message()
+	}
+	return &CodeError{Code: code, Message: message}
+}
+
+// 按错误码写入响应
+func WriteResponse(w http.ResponseWriter, code Code, message string, data interface{}) {
+	if message == "" {
+		message = code.Message()
+	}
+	body, err := json.Marshal(&Response{Code: code, Message: message, Data: data})
+	if err != nil {
+		code = CodeInternal
+		body, _ = json.Marshal(&Response{Code: code, Message: code.Message()})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code.Status())
+	w.Write(body)
+}
+
+// 写入成功响应
+func WriteOK(w http.ResponseWriter, data interface{}) {
+	WriteResponse(w, CodeOK, "", data)
+}
+
+// 写入错误响应，没有错误码的错误按defaultCode处理
+func WriteError(w http.ResponseWriter, err error, defaultCode Code) {
+	if codeErr, ok := err.(*CodeError); ok {
+		WriteResponse(w, codeErr.Code, codeErr.Message, nil)
+		return
+	}
+	WriteResponse(w, defaultCode, err.Error(), nil)
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSeckillTokenInvalid = errors.New("秒杀令牌无效！")
+	ErrSeckillTokenExpired = errors.New("秒杀令牌已过期！")
+)
+
+// 秒杀令牌签名的内容
+func seckillTokenValues(uid string, productID string, expire string) url.Values {
+	values := url.Values{}
+	values.Set("uid", uid)
+	values.Set("productID", productID)
+	values.Set("expire", expire)
+	return values
+}
+
+// 生成用户对某个商品的秒杀令牌，格式为 过期时间.签名
+func NewSeckillToken(secret string, uid string, productID string, expire time.Time) string {
+	expireString := strconv.FormatInt(expire.Unix(), 10)
+	return expireString + "." + SignParams(seckillTokenValues(uid, productID, expireString), secret)
+}
+
+// 校验秒杀令牌
+func VerifySeckillToken(secret string, token string, uid string, productID string, now time.Time) error {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return ErrSeckillTokenInvalid
+	}
+	expire, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrSeckillTokenInvalid
+	}
+	values := seckillTokenValues(uid, productID, parts[0])
+	values.Set(SignKey, parts[1])
+	if !VerifyParams(values, secret) {
+		return ErrSeckillTokenInvalid
+	}
+	if now.Unix() > expire {
+		return ErrSeckillTokenExpired
+	}
+	return nil
+}
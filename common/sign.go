@@ -0,0 +1,74 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 签名参数名
+const SignKey = "sign"
+
+// 按参数名排序后拼接为 k1=v1&k2=v2，使用HMAC-SHA256签名，sign参数不参与签名
+func SignParams(values url.Values, secret string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if k != SignKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var builder strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			builder.WriteByte('&')
+		}
+		builder.WriteString(k)
+		builder.WriteByte('=')
+		builder.WriteString(values.Get(k))
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(builder.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 校验参数签名
+func VerifyParams(values url.Values, secret string) bool {
+	sign, err := hex.DecodeString(values.Get(SignKey))
+	if err != nil || len(sign) == 0 {
+		return false
+	}
+	expected, _ := hex.DecodeString(SignParams(values, secret))
+	return hmac.Equal(sign, expected)
+}
+
+// 密钥的最短长度
+const minSecretLength = 16
+
+// 曾经作为默认值写在代码中的密钥，已经公开，不能使用
+var publicSecrets = map[string]bool{
+	"imooc-rpc-secret":       true,
+	"imooc-validate-replica": true,
+	"imooc-payment-secret":   true,
+	"imooc-seckill-secret":   true,
+}
+
+// 从环境变量读取签名密钥，未设置、过短或使用已公开的默认值时返回错误，调用方应拒绝启动
+func SecretFromEnv(name string) (string, error) {
+	secret := os.Getenv(name)
+	if secret == "" {
+		return "", fmt.Errorf("未配置密钥，请设置环境变量%s", name)
+	}
+	if publicSecrets[secret] {
+		return "", fmt.Errorf("环境变量%s使用了公开的默认密钥，请更换", name)
+	}
+	if len(secret) < minSecretLength {
+		return "", fmt.Errorf("环境变量%s的密钥不能少于%d个字符", name, minSecretLength)
+	}
+	return secret, nil
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSecretFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"", false},
+		{"imooc-rpc-secret", false},
+		{"imooc-payment-secret", false},
+		{"short-secret", false},
+		{"0123456789abcdef", true},
+	}
+	defer os.Unsetenv("TEST_SECRET")
+	for _, test := range tests {
+		os.Setenv("TEST_SECRET", test.value)
+		secret, err := SecretFromEnv("TEST_SECRET")
+		if (err == nil) != test.ok {
+			t.Errorf("%q: 得到错误%v", test.value, err)
+		}
+		if test.ok && secret != test.value {
+			t.Errorf("%q: 得到%q", test.value, secret)
+		}
+	}
+}
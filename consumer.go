@@ -1,28 +1,49 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"imoc-product/common"
 	"imoc-product/rabbitmq"
 	"imoc-product/repositories"
 	"imoc-product/services"
+	"time"
+)
+
+var (
+	getOneHost = flag.String("getOne", "127.0.0.1:8084", "数量控制服务单机节点或协调节点的地址，关闭订单时退回库存")
+	payTimeout = flag.Duration("payTimeout", 15*time.Minute, "订单未支付的过期时间")
 )
 
 func main() {
+	flag.Parse()
 	db, err := common.NewMysqlConn()
 	if err != nil {
 		fmt.Println(err)
 	}
 	// 创建product数据库操作实例
 	product := repositories.NewProductManager("product", db)
-	// 创建product service
-	productService := services.NewProductService(product)
 	// 创建order数据库实例
 	order := repositories.NewOrderManagerRepository("order_table", db)
-	// 创建order service
-	orderService := services.NewOrderService(order)
+	// 用户限购，按秒杀活动配置的数量
+	campaignService := services.NewCampaignService(repositories.NewCampaignManager("campaign", db))
+	purchaseService := services.NewPurchaseService(repositories.NewPurchaseManager("purchase_ledger", db), campaignService)
+	// 订单插入、限购校验和库存扣减共用同一个事务
+	seckillService := services.NewSeckillService(repositories.NewUnitOfWork(db), order, product, purchaseService,
+		services.NewGetOneClient(*getOneHost))
+	// 超时未支付的订单自动关闭并退回库存
+	expirer := services.NewOrderExpirer(seckillService, *payTimeout, 30*time.Second)
+	defer expirer.Close()
 
-	rabbitmqConsumeSimple := rabbitmq.NewRabbitMQSimple("imoocProduct")
-	rabbitmqConsumeSimple.ConsumeSimple(orderService, productService)
+	rabbitmqConsumeSimple := rabbitmq.NewRabbitMQSimpleDurable("imoocProduct")
+	// 失败消息最多重试5次，延迟从1秒开始翻倍，超过次数进入死信队列
+	rabbitmqConsumeSimple.Retry = &rabbitmq.RetryPolicy{MaxRetries: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+	// 处理完成后广播订单结果，前端据此通知买家
+	rabbitmqConsumeSimple.Results = rabbitmq.NewRabbitMQOrderResult()
+	// 连接断开后自动重连并恢复消费
+	err = rabbitmqConsumeSimple.ConsumeSimple(seckillService)
+	if err != nil {
+		fmt.Println(err)
+	}
 
 }
@@ -0,0 +1,20 @@
+package datamodels
+
+import "time"
+
+// 黑名单
+type Blacklist struct {
+	ID     int64  `json:"ID" sql:"ID" imooc:"ID"`
+	UserID int64  `json:"UserID" sql:"userID" imooc:"UserID"`
+	Reason string `json:"Reason" sql:"reason" imooc:"Reason"`
+	// 添加人，管理员或自动检测
+	Operator string `json:"Operator" sql:"operator" imooc:"Operator"`
+	// 过期时间，零值表示永久有效
+	ExpireTime time.Time `json:"ExpireTime" sql:"expireTime" imooc:"-"`
+	CreateTime time.Time `json:"CreateTime" sql:"createTime" imooc:"-"`
+}
+
+// 黑名单在t时刻是否有效
+func (b *Blacklist) Active(t time.Time) bool {
+	return b.ExpireTime.IsZero() || t.Before(b.ExpireTime)
+}
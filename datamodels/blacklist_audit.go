@@ -0,0 +1,24 @@
+package datamodels
+
+import "time"
+
+// 黑名单操作类型
+const (
+	AuditActionAdd    = "add"
+	AuditActionRemove = "remove"
+	// 封禁IP，只在检测到的验证节点上生效
+	AuditActionBanIP = "banIP"
+)
+
+// 黑名单审计记录，记录每次手动和自动的拉黑、移出
+type BlacklistAudit struct {
+	ID     int64  `json:"ID" sql:"ID" imooc:"ID"`
+	UserID int64  `json:"UserID" sql:"userID" imooc:"UserID"`
+	IP     string `json:"IP" sql:"ip" imooc:"IP"`
+	Action string `json:"Action" sql:"action" imooc:"Action"`
+	// 原因，自动检测时为触发的规则和次数
+	Detail string `json:"Detail" sql:"detail" imooc:"Detail"`
+	// 操作人，管理员或自动检测
+	Operator   string    `json:"Operator" sql:"operator" imooc:"Operator"`
+	CreateTime time.Time `json:"CreateTime" sql:"createTime" imooc:"-"`
+}
@@ -0,0 +1,21 @@
+package datamodels
+
+import "time"
+
+// 秒杀活动
+type Campaign struct {
+	ID           int64   `json:"id" sql:"ID" imooc:"ID"`
+	ProductID    int64   `json:"ProductID" sql:"productID" imooc:"ProductID"`
+	SeckillPrice float64 `json:"SeckillPrice" sql:"seckillPrice" imooc:"SeckillPrice"`
+	// 活动库存
+	Stock     int64     `json:"Stock" sql:"stock" imooc:"Stock"`
+	StartTime time.Time `json:"StartTime" sql:"startTime" imooc:"-"`
+	EndTime   time.Time `json:"EndTime" sql:"endTime" imooc:"-"`
+	// 每个用户的限购数量，0为不限购
+	UserLimit int64 `json:"UserLimit" sql:"userLimit" imooc:"UserLimit"`
+}
+
+// 活动在t时刻是否进行中
+func (c *Campaign) Active(t time.Time) bool {
+	return !t.Before(c.StartTime) && t.Before(c.EndTime)
+}
@@ -4,9 +4,13 @@ package datamodels
 type Message struct {
 	ProductID int64
 	UserID    int64
+	// 请求ID，在入口处生成，消费端据此保证同一请求只创建一个订单
+	RequestID string
+	// 下单时所在的秒杀活动，限购按活动计算，0表示由消费端按当前时间确定
+	CampaignID int64 `json:",omitempty"`
 }
 
 // 创建结构体
-func NewMessage(userId int64, productId int64) *Message {
-	return &Message{ProductID: productId, UserID: userId}
+func NewMessage(userId int64, productId int64, requestId string) *Message {
+	return &Message{ProductID: productId, UserID: userId, RequestID: requestId}
 }
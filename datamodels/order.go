@@ -1,14 +1,80 @@
 package datamodels
 
 type Order struct {
-	ID          int64 `json:"id" sql:"ID" imooc:"id"`
-	UserId      int64 `json:"UserId" sql:"userID" imooc:"UserId"`
-	ProductId   int64 `json:"ProductId" sql:"productID" imooc:"ProductId"`
-	OrderStatus int64 `json:"OrderStatus" sql:"orderStatus" imooc:"OrderStatus"`
+	ID          int64  `json:"id" sql:"ID" imooc:"id"`
+	UserId      int64  `json:"UserId" sql:"userID" imooc:"UserId"`
+	ProductId   int64  `json:"ProductId" sql:"productID" imooc:"ProductId"`
+	OrderStatus int64  `json:"OrderStatus" sql:"orderStatus" imooc:"OrderStatus"`
+	RequestID   string `json:"RequestID" sql:"requestID" imooc:"RequestID"`
+	// 下单时所在的秒杀活动，0表示没有活动，关闭订单时按该活动退回限购数量
+	CampaignID int64 `json:"CampaignID" sql:"campaignID" imooc:"CampaignID"`
+	// 支付网关的交易号，支付成功后写入
+	TradeNo string `json:"TradeNo" sql:"tradeNo" imooc:"TradeNo"`
 }
 
+// 订单状态
 const (
+	// 已创建，等待扣减库存
 	OrderWait = iota
+	// 下单成功，没有支付环节之前创建的订单，不再流转
 	OrderSuccess
+	// 库存不足，下单失败
 	OrderFailed
+	// 已支付
+	OrderPaid
+	// 买家取消
+	OrderCancelled
+	// 超时未支付
+	OrderExpired
+	// 已退款
+	OrderRefunded
+	// 超过限购数量，下单失败
+	OrderLimited
+	// 库存已扣减，等待支付
+	OrderAwaitingPayment
+	// 待退款，如订单关闭后才收到支付
+	OrderRefunding
 )
+
+// 订单状态允许的流转
+// 已创建 -> 待支付/失败/超过限购，待支付 -> 已支付/取消/过期，
+// 已支付 -> 待退款/已退款，取消/过期后收到支付 -> 待退款，待退款 -> 已退款
+var orderTransitions = map[int64][]int64{
+	OrderWait:            {OrderAwaitingPayment, OrderFailed, OrderLimited},
+	OrderAwaitingPayment: {OrderPaid, OrderCancelled, OrderExpired},
+	OrderPaid:            {OrderRefunding, OrderRefunded},
+	OrderCancelled:       {OrderRefunding},
+	OrderExpired:         {OrderRefunding},
+	OrderRefunding:       {OrderRefunded},
+}
+
+// 订单能否从from状态流转到to状态
+func CanTransitOrder(from int64, to int64) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+var orderStatusNames = map[int64]string{
+	OrderWait:            "已创建",
+	OrderSuccess:         "下单成功",
+	OrderFailed:          "下单失败",
+	OrderPaid:            "已支付",
+	OrderCancelled:       "已取消",
+	OrderExpired:         "已过期",
+	OrderRefunded:        "已退款",
+	OrderLimited:         "超过限购",
+	OrderAwaitingPayment: "待支付",
+	OrderRefunding:       "待退款",
+}
+
+// 订单状态名称
+func OrderStatusName(status int64) string {
+	if name, ok := orderStatusNames[status]; ok {
+		return name
+	}
+	return "未知状态"
+}
@@ -0,0 +1,56 @@
+package datamodels
+
+// 订单处理结果，消费端处理完消息后广播给前端
+type OrderResult struct {
+	RequestID string
+	UserID    int64
+	ProductID int64
+	OrderID   int64
+	Status    string
+	// 结果产生的时间，unix毫秒
+	Time int64
+}
+
+// 订单处理状态
+const (
+	// 已进入队列，等待处理
+	ResultQueued = "queued"
+	// 下单成功
+	ResultSuccess = "success"
+	// 库存不足
+	ResultSoldOut = "soldout"
+	// 超过限购数量
+	ResultLimited = "limited"
+	// 处理失败
+	ResultFailed = "failed"
+)
+
+// 是否为最终状态
+func (r *OrderResult) Done() bool {
+	return r.Status != ResultQueued
+}
+
+// 根据订单状态生成处理结果
+func NewOrderResult(message *Message, order *Order) *OrderResult {
+	result := &OrderResult{
+		RequestID: message.RequestID,
+		UserID:    message.UserID,
+		ProductID: message.ProductID,
+		Status:    ResultQueued,
+	}
+	if order == nil {
+		return result
+	}
+	result.OrderID = order.ID
+	switch order.OrderStatus {
+	case OrderWait:
+	case OrderFailed:
+		result.Status = ResultSoldOut
+	case OrderLimited:
+		result.Status = ResultLimited
+	default:
+		// 下单成功之后的状态都表示抢购成功
+		result.Status = ResultSuccess
+	}
+	return result
+}
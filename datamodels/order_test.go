@@ -0,0 +1,59 @@
+package datamodels
+
+import "testing"
+
+func TestCanTransitOrder(t *testing.T) {
+	tests := []struct {
+		from int64
+		to   int64
+		ok   bool
+	}{
+		// 下单
+		{OrderWait, OrderAwaitingPayment, true},
+		{OrderWait, OrderFailed, true},
+		{OrderWait, OrderLimited, true},
+		{OrderWait, OrderPaid, false},
+		{OrderWait, OrderSuccess, false},
+		// 支付和关闭
+		{OrderAwaitingPayment, OrderPaid, true},
+		{OrderAwaitingPayment, OrderCancelled, true},
+		{OrderAwaitingPayment, OrderExpired, true},
+		{OrderAwaitingPayment, OrderRefunding, false},
+		{OrderAwaitingPayment, OrderRefunded, false},
+		{OrderAwaitingPayment, OrderWait, false},
+		// 退款
+		{OrderPaid, OrderRefunding, true},
+		{OrderPaid, OrderRefunded, true},
+		{OrderPaid, OrderCancelled, false},
+		{OrderPaid, OrderExpired, false},
+		{OrderCancelled, OrderRefunding, true},
+		{OrderExpired, OrderRefunding, true},
+		{OrderRefunding, OrderRefunded, true},
+		// 关闭的订单不能再支付，失败的订单不能再流转
+		{OrderCancelled, OrderPaid, false},
+		{OrderExpired, OrderPaid, false},
+		{OrderExpired, OrderAwaitingPayment, false},
+		{OrderCancelled, OrderRefunded, false},
+		{OrderFailed, OrderAwaitingPayment, false},
+		{OrderLimited, OrderAwaitingPayment, false},
+		{OrderRefunded, OrderRefunding, false},
+		{OrderRefunding, OrderPaid, false},
+		// 没有支付环节的旧订单不再流转
+		{OrderSuccess, OrderPaid, false},
+		{OrderSuccess, OrderExpired, false},
+	}
+	for _, test := range tests {
+		if got := CanTransitOrder(test.from, test.to); got != test.ok {
+			t.Errorf("%s -> %s: 得到%v，应为%v", OrderStatusName(test.from), OrderStatusName(test.to), got, test.ok)
+		}
+	}
+}
+
+// 每个状态都有名称
+func TestOrderStatusName(t *testing.T) {
+	for status := int64(OrderWait); status <= OrderRefunding; status++ {
+		if OrderStatusName(status) == "未知状态" {
+			t.Errorf("状态%d没有名称", status)
+		}
+	}
+}
@@ -13,6 +13,12 @@ import (
 	"log"
 )
 
+// 支付网关地址，本地使用 payGateway.go 模拟
+var paymentGateway = "127.0.0.1:8086"
+
+// 支付网关回调前端的地址
+var paymentNotifyURL = "http://127.0.0.1:8082/payment/notify"
+
 func main() {
 	// 1.创建iris实例
 	app := iris.New()
@@ -32,6 +38,14 @@ func main() {
 		ctx.ViewLayout("")
 		ctx.View("shared/error.html")
 	})
+	var err error
+	// 支付回调和秒杀令牌的密钥，未配置时拒绝启动
+	if services.PaymentSecret, err = common.SecretFromEnv("PAYMENT_SECRET"); err != nil {
+		log.Fatal(err)
+	}
+	if services.SeckillSecret, err = common.SecretFromEnv("SECKILL_SECRET"); err != nil {
+		log.Fatal(err)
+	}
 	db, err := common.NewMysqlConn()
 	if err != nil {
 		log.Println(err)
@@ -45,20 +59,43 @@ func main() {
 	userPro.Register(userService, ctx)
 	userPro.Handle(new(controllers.UserController))
 
-	rabbitmq := rabbitmq.NewRabbitMQSimple("imoocProduct")
+	rabbitmqOrder, err := rabbitmq.NewRabbitMQSimpleReliable("imoocProduct", "./outbox/fronted")
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// 注册product控制器
 	productRepo := repositories.NewProductManager("product", db)
 	productService := services.NewProductService(productRepo)
 	orderRepo := repositories.NewOrderManagerRepository("order_table", db)
 	orderService := services.NewOrderService(orderRepo)
+	// 接收消费端广播的订单处理结果
+	orderResultService := services.NewOrderResultService(orderRepo)
+	go func() {
+		err := rabbitmq.NewRabbitMQOrderResult().ReceiveOrderResult(orderResultService.Publish)
+		if err != nil {
+			log.Println(err)
+		}
+	}()
 	productParty := app.Party("/product")
 	product := mvc.New(productParty)
 	// 使用中间件
 	productParty.Use(middlerware.AuthConProduct)
-	product.Register(productService, orderService, ctx, rabbitmq)
+	// 支付
+	paymentService := services.NewPaymentService(services.NewMockGateway(paymentGateway, services.PaymentSecret), orderRepo,
+		services.PaymentSecret, paymentNotifyURL)
+	// 秒杀活动和令牌
+	campaignService := services.NewCampaignService(repositories.NewCampaignManager("campaign", db))
+	seckillTokenService := services.NewSeckillTokenService(campaignService, services.SeckillSecret)
+	product.Register(productService, orderService, orderResultService, paymentService, campaignService, seckillTokenService,
+		ctx, rabbitmqOrder)
 	product.Handle(new(controllers.ProductController))
 
+	// 支付网关回调
+	payment := mvc.New(app.Party("/payment"))
+	payment.Register(paymentService)
+	payment.Handle(new(controllers.PaymentController))
+
 	app.Run(
 		iris.Addr("0.0.0.0:8082"),
 		iris.WithoutServerError(iris.ErrServerClosed),
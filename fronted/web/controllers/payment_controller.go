@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"github.com/kataras/iris/v12"
+	"imoc-product/services"
+	"net/http"
+)
+
+// 支付网关回调，不需要登录
+type PaymentController struct {
+	Ctx            iris.Context
+	PaymentService services.IPaymentService
+}
+
+// 支付结果通知 /payment/notify，返回success后网关停止重试
+func (p *PaymentController) PostNotify() string {
+	if err := p.Ctx.Request().ParseForm(); err != nil {
+		p.Ctx.StatusCode(http.StatusBadRequest)
+		return "fail"
+	}
+	err := p.PaymentService.HandleCallback(p.Ctx.Request().PostForm)
+	switch err {
+	case nil:
+		return "success"
+	case services.ErrPaymentSign, services.ErrPaymentExpired:
+		p.Ctx.Application().Logger().Warn("支付回调校验失败：", err)
+		p.Ctx.StatusCode(http.StatusUnauthorized)
+	default:
+		p.Ctx.Application().Logger().Debug(err)
+		p.Ctx.StatusCode(http.StatusInternalServerError)
+	}
+	return "fail"
+}
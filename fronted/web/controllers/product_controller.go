@@ -5,21 +5,30 @@ import (
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/mvc"
 	"github.com/kataras/iris/v12/sessions"
+	"imoc-product/common"
 	"imoc-product/datamodels"
 	"imoc-product/rabbitmq"
 	"imoc-product/services"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"text/template"
+	"time"
 )
 
 type ProductController struct {
 	Ctx            iris.Context
 	ProductService services.IProductService
 	OrderService   services.IOrderService
-	RabbitMQ       *rabbitmq.RabbitMQ
-	Session        *sessions.Session
+	// 订单处理结果
+	OrderResultService services.IOrderResultService
+	PaymentService     services.IPaymentService
+	// 活动开始后发放秒杀令牌
+	CampaignService     services.ICampaignService
+	SeckillTokenService services.ISeckillTokenService
+	RabbitMQ            *rabbitmq.RabbitMQ
+	Session             *sessions.Session
 }
 
 var (
@@ -88,31 +97,51 @@ func (p *ProductController) GetDetail() mvc.View {
 	}
 }
 
-func (p *ProductController) GetOrder() []byte {
+func (p *ProductController) GetOrder() {
+	w := p.Ctx.ResponseWriter()
 	productString := p.Ctx.URLParam("productID")
 	userString := p.Ctx.GetCookie("uid")
 	productID, err := strconv.ParseInt(productString, 10, 64)
 	if err != nil {
 		p.Ctx.Application().Logger().Debug(err)
+		common.WriteResponse(w, common.CodeInvalidRequest, "商品ID格式错误", nil)
+		return
 	}
 	userID, err := strconv.ParseInt(userString, 10, 64)
 	if err != nil {
 		p.Ctx.Application().Logger().Debug(err)
+		common.WriteResponse(w, common.CodeUnauthenticated, "", nil)
+		return
 	}
 
-	// 创建消息体
-	message := datamodels.NewMessage(userID, productID)
+	// 校验秒杀令牌，活动开始前无法下单
+	if err := p.SeckillTokenService.VerifyToken(p.Ctx.URLParam("token"), userID, productID); err != nil {
+		p.Ctx.Application().Logger().Debug(err)
+		common.WriteResponse(w, common.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+
+	// 创建消息体，客户端重试时携带同一个requestID，保证只生成一个订单
+	message := datamodels.NewMessage(userID, productID, common.RequestIDFrom(p.Ctx.URLParam("requestID")))
 	// 类型转换
 	byteMessage, err := json.Marshal(message)
 	if err != nil {
 		p.Ctx.Application().Logger().Debug(err)
+		common.WriteResponse(w, common.CodeInternal, "", nil)
+		return
 	}
 
 	err = p.RabbitMQ.PublishSimple(string(byteMessage))
 	if err != nil {
 		p.Ctx.Application().Logger().Debug(err)
+		common.WriteResponse(w, common.CodeUnavailable, "", nil)
+		return
 	}
-	return []byte("true")
+	// 通过requestID查询订单处理结果
+	p.OrderResultService.Publish(datamodels.NewOrderResult(message, nil))
+	p.Ctx.Header("X-Request-ID", message.RequestID)
+	common.WriteOK(w, iris.Map{"requestID": message.RequestID})
+	return
 
 	/*
 		product, err := p.ProductService.GetProductByID(int64(productID))
@@ -149,3 +178,130 @@ func (p *ProductController) GetOrder() []byte {
 	*/
 
 }
+
+// 推送订单结果的最长等待时间
+var resultStreamTimeout = 2 * time.Minute
+
+// 查询当前用户的订单结果，不属于当前用户的请求返回nil
+func (p *ProductController) userResult(requestID string) (*datamodels.OrderResult, error) {
+	result, err := p.OrderResultService.GetResult(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if result.UserID != 0 && strconv.FormatInt(result.UserID, 10) != p.Ctx.GetCookie("uid") {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// 轮询订单处理结果 /product/result?requestID=
+func (p *ProductController) GetResult() {
+	result, err := p.userResult(p.Ctx.URLParam("requestID"))
+	if err != nil {
+		p.Ctx.Application().Logger().Debug(err)
+		p.Ctx.StatusCode(http.StatusServiceUnavailable)
+		return
+	}
+	if result == nil {
+		p.Ctx.StatusCode(http.StatusNotFound)
+		return
+	}
+	p.Ctx.JSON(result)
+}
+
+// 通过server-sent events推送订单处理结果 /product/result/stream?requestID=
+// 得到最终结果或超时后关闭
+func (p *ProductController) GetResultStream() {
+	requestID := p.Ctx.URLParam("requestID")
+	// 先订阅再查询，避免漏掉查询期间产生的结果
+	results, cancel := p.OrderResultService.Subscribe(requestID)
+	defer cancel()
+	result, err := p.userResult(requestID)
+	if err != nil {
+		p.Ctx.Application().Logger().Debug(err)
+		p.Ctx.StatusCode(http.StatusServiceUnavailable)
+		return
+	}
+	if result == nil {
+		p.Ctx.StatusCode(http.StatusNotFound)
+		return
+	}
+
+	p.Ctx.ContentType("text/event-stream")
+	p.Ctx.Header("Cache-Control", "no-cache")
+	p.Ctx.Header("Connection", "keep-alive")
+	timeout := time.After(resultStreamTimeout)
+	for {
+		data, err := json.Marshal(result)
+		if err != nil {
+			p.Ctx.Application().Logger().Debug(err)
+			return
+		}
+		p.Ctx.Writef("event: result\ndata: %s\n\n", data)
+		p.Ctx.ResponseWriter().Flush()
+		if result.Done() {
+			return
+		}
+		select {
+		case result = <-results:
+		case <-timeout:
+			return
+		case <-p.Ctx.Request().Context().Done():
+			return
+		}
+	}
+}
+
+// 支付订单 /product/pay?orderID=，跳转到支付网关
+func (p *ProductController) GetPay() {
+	orderID, err := p.Ctx.URLParamInt64("orderID")
+	if err != nil {
+		p.Ctx.StatusCode(http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.ParseInt(p.Ctx.GetCookie("uid"), 10, 64)
+	if err != nil {
+		p.Ctx.StatusCode(http.StatusUnauthorized)
+		return
+	}
+	payURL, err := p.PaymentService.Pay(orderID, userID)
+	if err != nil {
+		p.Ctx.Application().Logger().Debug(err)
+		p.Ctx.Values().Set("message", err.Error())
+		p.Ctx.StatusCode(http.StatusBadRequest)
+		return
+	}
+	p.Ctx.Redirect(payURL, http.StatusFound)
+}
+
+// 服务器时间 /product/time，客户端据此校准倒计时
+func (p *ProductController) GetTime() {
+	p.Ctx.JSON(iris.Map{"Now": time.Now().UnixNano() / int64(time.Millisecond)})
+}
+
+// 获取秒杀令牌 /product/token?productID=，活动开始后才发放
+func (p *ProductController) GetToken() {
+	productID, err := p.Ctx.URLParamInt64("productID")
+	if err != nil {
+		p.Ctx.StatusCode(http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.ParseInt(p.Ctx.GetCookie("uid"), 10, 64)
+	if err != nil {
+		p.Ctx.StatusCode(http.StatusUnauthorized)
+		return
+	}
+	token, err := p.SeckillTokenService.IssueToken(userID, productID)
+	if err != nil {
+		now := time.Now()
+		data := iris.Map{"Now": now.UnixNano() / int64(time.Millisecond), "Error": err.Error()}
+		// 活动未开始时返回开始时间，客户端倒计时结束后再获取
+		if campaign, _ := p.CampaignService.CheckWindow(productID, now); campaign != nil && err == services.ErrCampaignNotStarted {
+			data["StartTime"] = campaign.StartTime.UnixNano() / int64(time.Millisecond)
+		}
+		p.Ctx.StatusCode(http.StatusForbidden)
+		p.Ctx.JSON(data)
+		return
+	}
+	p.Ctx.JSON(token)
+}
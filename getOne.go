@@ -1,44 +1,430 @@
 package main
 
+// 数量控制服务
+// 单机运行：go run getOne.go
+// 集群运行：
+//   go run getOne.go -role=coordinator -addr=:8090 -data=./getOneData/coordinator
+//   go run getOne.go -role=node -addr=:8084 -node=127.0.0.1:8084 -coordinator=127.0.0.1:8090 -data=./getOneData/8084
+//   go run getOne.go -role=node -addr=:8085 -node=127.0.0.1:8085 -coordinator=127.0.0.1:8090 -data=./getOneData/8085
+// 集群模式下库存在协调节点上加载和修改，售卖节点只负责售卖
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"imoc-product/common"
+	"imoc-product/repositories"
+	"imoc-product/rpc"
+	"imoc-product/services"
+	"imoc-product/stock"
 	"log"
 	"net/http"
-	"sync"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 )
 
-var sum int64 = 0
+// 运行角色
+// standalone: 单机，独立保存全部库存
+// coordinator: 集群协调节点，负责加载库存并向售卖节点分配配额
+// node: 集群售卖节点
+var role = flag.String("role", "standalone", "运行角色：standalone、coordinator、node")
 
-// 预存商品数量
-var productNum int64 = 10000
+var addr = flag.String("addr", ":8084", "监听地址")
 
-// 互斥锁
-var mutex sync.Mutex
+// 计数器后端
+// file: 分片计数器，租用的令牌批量写入预写日志，重启不会超卖
+// memory: 分片计数器，不写日志，重启后数据丢失，仅单机可用
+var counterBackend = flag.String("backend", "file", "计数器后端：file、memory")
+
+// 库存日志目录，每个商品一个日志文件，重启后从日志恢复已售数量
+var walDir = flag.String("data", "./getOneData", "库存日志目录")
+
+// 协调节点地址
+var coordinatorAddr = flag.String("coordinator", "127.0.0.1:8090", "协调节点地址，仅售卖节点使用")
+
+// 售卖节点名称，需在集群内唯一，重启后保持不变
+var nodeName = flag.String("node", "127.0.0.1:8084", "售卖节点名称")
+
+// 预留有效时间，超时未确认自动释放
+var reserveTTL = 30 * time.Second
+
+// 每个售卖节点每个商品最多持有的未上报配额，两次上报之间最多售出其中的四分之一
+var quotaChunk int64 = 100
+
+// 租约时长和认定节点失效前的宽限时间
+var leaseTTL = 3 * time.Second
+
+var leaseGrace = 2 * time.Second
+
+// 售卖节点心跳间隔
+var heartbeatInterval = 500 * time.Millisecond
+
+// 按商品划分的库存池
+var pools *stock.Pools
+
+// 库存预留
+var reservations *stock.Reservations
+
+// 集群协调
+var coordinator *stock.Coordinator
+
+// 商品服务，用于加载商品库存
+var productService services.IProductService
+
+// 秒杀活动，活动时间外不售卖
+var campaignService services.ICampaignService
+
+// 商品是否处于活动时间内，没有活动的商品不售卖
+func inCampaign(productID int64) bool {
+	_, err := campaignService.CheckWindow(productID, time.Now())
+	if err != nil {
+		log.Println("err:", err)
+		return false
+	}
+	return true
+}
+
+// 获取请求中的商品ID
+func getProductID(req *http.Request) (int64, error) {
+	return strconv.ParseInt(req.URL.Query().Get("productID"), 10, 64)
+}
 
 func GetProduct(w http.ResponseWriter, req *http.Request) {
-	if GetOneProduct() {
-		w.Write([]byte("true"))
+	productID, err := getProductID(req)
+	if err != nil {
+		common.WriteResponse(w, common.CodeInvalidRequest, "商品ID格式错误", nil)
 		return
 	}
-	w.Write([]byte("false"))
+	reason, _ := stockRPC.GetOne(req.Context(), productID)
+	common.WriteResponse(w, reason.Code(), "", nil)
 	return
 }
 
 // 获取秒杀商品
-func GetOneProduct() bool {
-	// 加锁
-	mutex.Lock()
-	defer mutex.Unlock()
-	// 判断数据是否超限
-	if sum < productNum {
-		sum += 1
-		return true
+func GetOneProduct(productID int64) bool {
+	isOk, err := pools.GetOne(productID)
+	if err != nil {
+		log.Println("err:", err)
+		return false
+	}
+	return isOk
+}
+
+// 预留成功的响应数据
+type ReserveResult struct {
+	ReservationID string `json:"reservationID"`
+}
+
+// 预留商品，成功返回预留ID
+func ReserveProduct(w http.ResponseWriter, req *http.Request) {
+	productID, err := getProductID(req)
+	if err != nil {
+		common.WriteResponse(w, common.CodeInvalidRequest, "商品ID格式错误", nil)
+		return
+	}
+	id, reason, _ := stockRPC.Reserve(req.Context(), productID)
+	if reason != rpc.ReasonOK {
+		common.WriteResponse(w, reason.Code(), "", nil)
+		return
+	}
+	common.WriteOK(w, &ReserveResult{ReservationID: id})
+}
+
+// 确认预留
+func ConfirmProduct(w http.ResponseWriter, req *http.Request) {
+	reason, _ := stockRPC.Confirm(req.Context(), req.URL.Query().Get("id"))
+	common.WriteResponse(w, reason.Code(), "", nil)
+}
+
+// 释放预留
+// 带productID时退回已售出的库存，由订单过期和取消使用
+func ReleaseProduct(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Query().Get("productID") != "" {
+		ReturnProduct(w, req)
+		return
+	}
+	if reservations == nil {
+		common.WriteResponse(w, common.CodeNotFound, "协调节点不处理预留", nil)
+		return
+	}
+	reason, _ := stockRPC.Release(req.Context(), req.URL.Query().Get("id"))
+	common.WriteResponse(w, reason.Code(), "", nil)
+}
+
+// 退回已售出的库存，减少已售数量，商品总数不变
+// 集群模式下由协调节点收回，售卖节点的配额由协调节点统一分配
+func ReturnProduct(w http.ResponseWriter, req *http.Request) {
+	if *role == "node" {
+		common.WriteResponse(w, common.CodeInvalidRequest, "售卖节点不能退回库存，请调用协调节点", nil)
+		return
+	}
+	productID, err := getProductID(req)
+	if err != nil {
+		writeStat(w, nil, err)
+		return
+	}
+	num, err := strconv.ParseInt(req.URL.Query().Get("num"), 10, 64)
+	if err != nil || num <= 0 {
+		common.WriteResponse(w, common.CodeInvalidRequest, "退回数量必须大于0", nil)
+		return
+	}
+	if err := pools.ReleaseN(productID, num); err != nil {
+		writeStat(w, nil, err)
+		return
+	}
+	stat, err := pools.Stat(productID)
+	writeStat(w, stat, err)
+}
+
+// 验证服务通过RPC调用的售卖接口，HTTP接口也由它实现
+type stockService struct{}
+
+var stockRPC rpc.IStockService = stockService{}
+
+func (stockService) GetOne(ctx context.Context, productID int64) (rpc.Reason, error) {
+	if !inCampaign(productID) {
+		return rpc.ReasonNotInCampaign, nil
+	}
+	if !GetOneProduct(productID) {
+		return rpc.ReasonSoldOut, nil
+	}
+	return rpc.ReasonOK, nil
+}
+
+func (stockService) Reserve(ctx context.Context, productID int64) (string, rpc.Reason, error) {
+	if !inCampaign(productID) {
+		return "", rpc.ReasonNotInCampaign, nil
+	}
+	reservation, err := reservations.Reserve(productID)
+	if err != nil {
+		log.Println("err:", err)
+	}
+	if reservation == nil {
+		// 商品未加载也视为售罄
+		if err != nil && err != stock.ErrNotLoaded {
+			return "", rpc.ReasonInternal, nil
+		}
+		return "", rpc.ReasonSoldOut, nil
+	}
+	return reservation.ID, rpc.ReasonOK, nil
+}
+
+func (stockService) Confirm(ctx context.Context, id string) (rpc.Reason, error) {
+	return reservationReason(reservations.Confirm(id)), nil
+}
+
+func (stockService) Release(ctx context.Context, id string) (rpc.Reason, error) {
+	return reservationReason(reservations.Release(id)), nil
+}
+
+func reservationReason(err error) rpc.Reason {
+	switch err {
+	case nil:
+		return rpc.ReasonOK
+	case stock.ErrReservationNotFound:
+		return rpc.ReasonNotFound
+	}
+	log.Println("err:", err)
+	return rpc.ReasonInternal
+}
+
+// 返回库存池状态
+func writeStat(w http.ResponseWriter, stat *stock.Stat, err error) {
+	switch err {
+	case nil:
+		common.WriteOK(w, stat)
+	case stock.ErrNotLoaded:
+		common.WriteResponse(w, common.CodeNotFound, err.Error(), nil)
+	default:
+		common.WriteResponse(w, common.CodeInvalidRequest, err.Error(), nil)
+	}
+}
+
+// 加载商品库存，num为空时使用进行中或未开始活动的库存，没有活动时从数据库读取商品数量
+func LoadProduct(w http.ResponseWriter, req *http.Request) {
+	productID, err := getProductID(req)
+	if err != nil {
+		writeStat(w, nil, err)
+		return
+	}
+	if numString := req.URL.Query().Get("num"); numString != "" {
+		num, err := strconv.ParseInt(numString, 10, 64)
+		if err != nil {
+			writeStat(w, nil, err)
+			return
+		}
+		stat, err := pools.Load(productID, num)
+		writeStat(w, stat, err)
+		return
+	}
+	campaign, err := campaignService.CheckWindow(productID, time.Now())
+	if err == nil || err == services.ErrCampaignNotStarted {
+		stat, err := pools.Load(productID, campaign.Stock)
+		writeStat(w, stat, err)
+		return
+	}
+	product, err := productService.GetProductByID(productID)
+	if err != nil {
+		writeStat(w, nil, err)
+		return
+	}
+	if product.ID == 0 {
+		common.WriteResponse(w, common.CodeNotFound, "商品不存在！", nil)
+		return
+	}
+	stat, err := pools.Load(productID, product.ProductNum)
+	writeStat(w, stat, err)
+}
+
+// 补充库存
+func AddProduct(w http.ResponseWriter, req *http.Request) {
+	productID, err := getProductID(req)
+	if err != nil {
+		writeStat(w, nil, err)
+		return
+	}
+	num, err := strconv.ParseInt(req.URL.Query().Get("num"), 10, 64)
+	if err != nil {
+		writeStat(w, nil, err)
+		return
+	}
+	stat, err := pools.TopUp(productID, num)
+	writeStat(w, stat, err)
+}
+
+// 冻结或解冻库存
+func FreezeProduct(w http.ResponseWriter, req *http.Request) {
+	productID, err := getProductID(req)
+	if err != nil {
+		writeStat(w, nil, err)
+		return
+	}
+	frozen, err := strconv.ParseBool(req.URL.Query().Get("frozen"))
+	if err != nil {
+		writeStat(w, nil, err)
+		return
 	}
-	return false
+	stat, err := pools.Freeze(productID, frozen)
+	writeStat(w, stat, err)
+}
+
+// 查询库存
+func GetStock(w http.ResponseWriter, req *http.Request) {
+	productID, err := getProductID(req)
+	if err != nil {
+		writeStat(w, nil, err)
+		return
+	}
+	stat, err := pools.Stat(productID)
+	writeStat(w, stat, err)
+}
+
+// 处理售卖节点心跳
+func Heartbeat(w http.ResponseWriter, req *http.Request) {
+	heartbeat := &stock.HeartbeatRequest{}
+	if err := json.NewDecoder(req.Body).Decode(heartbeat); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := coordinator.Heartbeat(heartbeat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, resp)
+}
+
+// 查询集群节点
+func GetNodes(w http.ResponseWriter, req *http.Request) {
+	writeJson(w, coordinator.Nodes())
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
 
 func main() {
-	http.HandleFunc("/getOne", GetProduct)
-	err := http.ListenAndServe(":8084", nil)
+	flag.Parse()
+
+	err := os.MkdirAll(*walDir, 0755)
+	if err != nil {
+		log.Fatal("err:", err)
+	}
+	// 重放日志恢复库存数据
+	var backend stock.IBackend
+	if *counterBackend == "memory" && *role == "standalone" {
+		backend = stock.NewMemoryBackend()
+	} else {
+		backend = stock.NewFileBackend(*walDir)
+	}
+
+	db, err := common.NewMysqlConn()
+	if err != nil {
+		log.Fatal("err:", err)
+	}
+	campaignService = services.NewCampaignService(repositories.NewCampaignManager("campaign", db))
+
+	switch *role {
+	case "node":
+		// 售卖节点的库存受协调节点的租约控制
+		node := stock.NewClusterNode(*nodeName, *coordinatorAddr, heartbeatInterval)
+		pools, err = stock.NewPools(node.Backend(backend))
+		if err != nil {
+			log.Fatal("err:", err)
+		}
+		node.Start(pools)
+		defer node.Close()
+	case "standalone", "coordinator":
+		pools, err = stock.NewPools(backend)
+		if err != nil {
+			log.Fatal("err:", err)
+		}
+		product := repositories.NewProductManager("product", db)
+		productService = services.NewProductService(product)
+	default:
+		log.Fatal("未知的运行角色：", *role)
+	}
+	defer pools.Close()
+
+	if *role == "coordinator" {
+		coordinator = stock.NewCoordinator(pools, quotaChunk, leaseTTL, leaseGrace)
+		defer coordinator.Close()
+		http.HandleFunc("/cluster/heartbeat", Heartbeat)
+		http.HandleFunc("/cluster/nodes", GetNodes)
+	} else {
+		// 恢复未完成的预留
+		reservations, err = stock.NewReservations(pools, filepath.Join(*walDir, "reservations.log"), reserveTTL)
+		if err != nil {
+			log.Fatal("err:", err)
+		}
+		defer reservations.Close()
+		http.HandleFunc("/getOne", GetProduct)
+		http.HandleFunc("/reserve", ReserveProduct)
+		http.HandleFunc("/confirm", ConfirmProduct)
+		// 验证服务使用的RPC接口，密钥未配置时拒绝启动
+		if rpc.Secret, err = common.SecretFromEnv("RPC_SECRET"); err != nil {
+			log.Fatal("err:", err)
+		}
+		rpcServer := rpc.NewServer(*nodeName, rpc.Secret)
+		rpc.RegisterStock(rpcServer, stockRPC)
+		http.Handle(rpc.Path, rpcServer)
+	}
+	if *role != "node" {
+		http.HandleFunc("/load", LoadProduct)
+		http.HandleFunc("/add", AddProduct)
+		http.HandleFunc("/freeze", FreezeProduct)
+	}
+	// 释放预留，或退回已售出的库存
+	http.HandleFunc("/release", ReleaseProduct)
+	http.HandleFunc("/stock", GetStock)
+	err = http.ListenAndServe(*addr, nil)
 	if err != nil {
 		log.Fatal("err:", err)
 	}
@@ -0,0 +1,166 @@
+package main
+
+// 本地模拟支付网关
+// 运行：go run payGateway.go -addr=:8086
+// 商户调用 /create 创建支付，买家打开返回的 /pay 地址完成支付，
+// 网关随后向商户的回调地址发送签名的支付通知，直到商户返回success
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"imoc-product/common"
+	"imoc-product/services"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var gatewayAddr = flag.String("addr", ":8086", "监听地址")
+
+// 买家访问支付页面使用的地址
+var publicHost = flag.String("public", "127.0.0.1:8086", "支付页面对外地址")
+
+// 回调重试
+var (
+	notifyRetry    = 8
+	notifyMinDelay = time.Second
+	notifyMaxDelay = time.Minute
+)
+
+type payment struct {
+	TradeNo string
+	OrderID int64
+	Notify  string
+	Status  string
+}
+
+type gateway struct {
+	// 订单ID到支付的映射，同一订单只创建一笔支付
+	orders   map[int64]*payment
+	payments map[string]*payment
+	client   *http.Client
+	sync.Mutex
+}
+
+// 创建支付
+func (g *gateway) create(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := services.VerifyPaymentParams(req.Form, services.PaymentSecret); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	orderID, err := strconv.ParseInt(req.Form.Get("orderID"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	notify := req.Form.Get("notify")
+	if _, err := url.ParseRequestURI(notify); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	g.Lock()
+	p, ok := g.orders[orderID]
+	if !ok {
+		p = &payment{TradeNo: common.NewRequestID(), OrderID: orderID, Notify: notify, Status: "created"}
+		g.orders[orderID] = p
+		g.payments[p.TradeNo] = p
+	}
+	g.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&services.PaymentResponse{
+		TradeNo: p.TradeNo,
+		PayURL:  "http://" + *publicHost + "/pay?tradeNo=" + p.TradeNo,
+	})
+}
+
+// 买家支付
+func (g *gateway) pay(w http.ResponseWriter, req *http.Request) {
+	tradeNo := req.URL.Query().Get("tradeNo")
+	g.Lock()
+	p, ok := g.payments[tradeNo]
+	first := ok && p.Status != services.PaymentPaid
+	if first {
+		p.Status = services.PaymentPaid
+	}
+	g.Unlock()
+	if !ok {
+		http.Error(w, "交易不存在", http.StatusNotFound)
+		return
+	}
+	if first {
+		go g.notify(p)
+	}
+	fmt.Fprintf(w, "订单%d支付成功，交易号：%s", p.OrderID, p.TradeNo)
+}
+
+// 向商户发送支付通知，失败时按退避时间重试
+func (g *gateway) notify(p *payment) {
+	delay := notifyMinDelay
+	for i := 0; i < notifyRetry; i++ {
+		values := url.Values{}
+		values.Set("orderID", strconv.FormatInt(p.OrderID, 10))
+		values.Set("tradeNo", p.TradeNo)
+		values.Set("status", services.PaymentPaid)
+		values.Set("time", strconv.FormatInt(time.Now().Unix(), 10))
+		values.Set("nonce", common.NewRequestID())
+		values.Set(common.SignKey, common.SignParams(values, services.PaymentSecret))
+		err := g.post(p.Notify, values)
+		if err == nil {
+			log.Printf("交易%s通知成功", p.TradeNo)
+			return
+		}
+		log.Printf("交易%s第%d次通知失败：%s", p.TradeNo, i+1, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > notifyMaxDelay {
+			delay = notifyMaxDelay
+		}
+	}
+	log.Printf("交易%s通知失败次数过多，放弃通知", p.TradeNo)
+}
+
+// 商户返回success表示通知已处理
+func (g *gateway) post(notify string, values url.Values) error {
+	response, err := g.client.PostForm(notify, values)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK || string(body) != "success" {
+		return fmt.Errorf("商户返回%d：%s", response.StatusCode, body)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	var err error
+	if services.PaymentSecret, err = common.SecretFromEnv("PAYMENT_SECRET"); err != nil {
+		log.Fatal("Err:", err)
+	}
+	g := &gateway{
+		orders:   make(map[int64]*payment),
+		payments: make(map[string]*payment),
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+	http.HandleFunc("/create", g.create)
+	http.HandleFunc("/pay", g.pay)
+	log.Printf("模拟支付网关启动：%s", *gatewayAddr)
+	err = http.ListenAndServe(*gatewayAddr, nil)
+	if err != nil {
+		log.Fatal("Err:", err)
+	}
+}
@@ -0,0 +1,175 @@
+package rabbitmq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"log"
+	"time"
+)
+
+var (
+	errNotConnected = errors.New("rabbitmq连接不可用，等待重连")
+	errDestroyed    = errors.New("rabbitmq实例已销毁")
+)
+
+// 重连退避时间
+var (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// 建立连接和channel，开启发布确认，并监听关闭事件
+func (r *RabbitMQ) connect() error {
+	conn, err := amqp.Dial(r.Mqurl)
+	if err != nil {
+		return err
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	pending := make(map[uint64]chan bool)
+	if r.reliable {
+		if err := channel.Confirm(false); err != nil {
+			conn.Close()
+			return err
+		}
+		go r.dispatchConfirms(channel.NotifyPublish(make(chan amqp.Confirmation, 100)), pending)
+	}
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	channelClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	r.Lock()
+	r.conn = conn
+	r.channel = channel
+	r.pending = pending
+	// 新channel的确认序号从1开始
+	r.deliveryTag = 0
+	close(r.connected)
+	r.Unlock()
+
+	go r.watch(connClosed, channelClosed)
+	return nil
+}
+
+// 监听连接和channel的关闭事件，断开后重连
+func (r *RabbitMQ) watch(connClosed chan *amqp.Error, channelClosed chan *amqp.Error) {
+	var err *amqp.Error
+	select {
+	case <-r.done:
+		return
+	case err = <-connClosed:
+	case err = <-channelClosed:
+	}
+	log.Printf("rabbitmq连接断开：%v，开始重连", err)
+
+	r.Lock()
+	r.connected = make(chan struct{})
+	conn := r.conn
+	r.Unlock()
+	// channel异常关闭时连接可能仍然可用，统一关闭后重建
+	conn.Close()
+	r.reconnect()
+}
+
+// 按指数退避重连，直到成功或实例被销毁
+func (r *RabbitMQ) reconnect() {
+	delay := reconnectMinDelay
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+		err := r.connect()
+		if err == nil {
+			log.Printf("rabbitmq重连成功")
+			return
+		}
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+		log.Printf("rabbitmq重连失败：%s，%s后重试", err, delay)
+	}
+}
+
+// 当前连接是否可用，调用前需持有锁
+func (r *RabbitMQ) isConnected() bool {
+	select {
+	case <-r.connected:
+		return true
+	default:
+		return false
+	}
+}
+
+// 等待连接可用，实例销毁时返回false
+func (r *RabbitMQ) waitConnected() bool {
+	r.Lock()
+	connected := r.connected
+	r.Unlock()
+	select {
+	case <-r.done:
+		return false
+	case <-connected:
+		return true
+	}
+}
+
+// 当前channel，连接不可用时返回错误
+func (r *RabbitMQ) currentChannel() (*amqp.Channel, error) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.isConnected() {
+		return nil, errNotConnected
+	}
+	return r.channel, nil
+}
+
+// 持续消费队列，连接断开后等待重连，重新声明队列并恢复消费
+// setup在每次连接后执行，负责声明交换机和队列，返回要消费的队列名称
+// 只有实例销毁时才返回
+func (r *RabbitMQ) consume(setup func(channel *amqp.Channel) (string, error), autoAck bool, handle func(d amqp.Delivery)) error {
+	for {
+		if !r.waitConnected() {
+			return errDestroyed
+		}
+		channel, err := r.currentChannel()
+		if err != nil {
+			continue
+		}
+		var messages <-chan amqp.Delivery
+		queueName, err := setup(channel)
+		if err == nil {
+			messages, err = channel.Consume(
+				queueName,
+				// 用来区分多个消费者
+				"",
+				// 是否自动应答
+				autoAck,
+				// 是否具有排他性
+				false,
+				// 如果设置为true，表示不能将同一个connection中发送的消息传递给这个connection中的消费者
+				false,
+				// 队列消费是否阻塞  false为阻塞
+				false,
+				nil,
+			)
+		}
+		if err != nil {
+			log.Printf("开始消费失败：%s", err)
+			select {
+			case <-r.done:
+				return errDestroyed
+			case <-time.After(reconnectMinDelay):
+			}
+			continue
+		}
+		for d := range messages {
+			handle(d)
+		}
+		log.Printf("消费中断，等待重连后恢复")
+	}
+}
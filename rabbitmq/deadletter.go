@@ -0,0 +1,316 @@
+package rabbitmq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/streadway/amqp"
+	"log"
+	"strconv"
+	"time"
+)
+
+// 消费失败的重试策略
+type RetryPolicy struct {
+	// 最大重试次数，超过后进入死信队列
+	MaxRetries int
+	// 首次重试的延迟时间，之后每次翻倍
+	BaseDelay time.Duration
+	// 最大延迟时间
+	MaxDelay time.Duration
+}
+
+var DefaultRetryPolicy = &RetryPolicy{MaxRetries: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+// 第retry次重试的延迟时间
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// 消息头
+const (
+	// 已重试次数
+	headerRetryCount = "x-retry-count"
+	// 进入死信队列的原因
+	headerDeadReason = "x-dead-reason"
+)
+
+// 死信消息扫描的最大数量
+var deadScanLimit = 1000
+
+var errDeadNotFound = errors.New("死信消息不存在！")
+
+// 死信消息
+type DeadMessage struct {
+	ID      string
+	Body    string
+	Reason  string
+	Retries int
+	Time    time.Time
+}
+
+// 死信交换机名称
+func (r *RabbitMQ) deadExchange() string {
+	return r.QueueName + ".dlx"
+}
+
+// 死信队列名称
+func (r *RabbitMQ) deadQueue() string {
+	return r.QueueName + ".dead"
+}
+
+// 延迟重试队列名称，每种延迟时间一个队列，避免不同过期时间的消息互相阻塞
+func (r *RabbitMQ) retryQueue(delay time.Duration) string {
+	return r.QueueName + ".retry." + strconv.FormatInt(int64(delay/time.Millisecond), 10)
+}
+
+// 声明死信交换机和死信队列
+func (r *RabbitMQ) declareDeadLetter(channel *amqp.Channel) error {
+	err := channel.ExchangeDeclare(r.deadExchange(), "direct", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	_, err = channel.QueueDeclare(r.deadQueue(), true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	return channel.QueueBind(r.deadQueue(), r.QueueName, r.deadExchange(), false, nil)
+}
+
+// 读取消息的重试次数
+func retryCount(headers amqp.Table) int {
+	switch v := headers[headerRetryCount].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
+
+// 处理消费失败的消息
+// 可重试的错误按重试策略延迟后重新投递，超过次数或无法处理的消息进入死信队列
+// 转发的消息被broker确认后才确认原消息，转发失败或未确认时原消息重新入队，保证不丢失
+// 返回消息是否已进入死信队列
+func (r *RabbitMQ) handleFailure(d amqp.Delivery, cause error, retryable bool) bool {
+	policy := r.Retry
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+	retries := retryCount(d.Headers)
+	var err error
+	dead := false
+	if retryable && retries < policy.MaxRetries {
+		log.Printf("消息处理失败，第%d次重试：%s", retries+1, cause)
+		err = r.retryLater(d, retries+1, policy.delay(retries+1))
+	} else {
+		log.Printf("消息进入死信队列：%s", cause)
+		err = r.deadLetter(d, retries, cause)
+		dead = true
+	}
+	if err != nil {
+		log.Printf("转发失败消息出错，重新入队：%s", err)
+		d.Nack(false, true)
+		return false
+	}
+	d.Ack(false)
+	return dead
+}
+
+// 打开一个开启发布确认的临时channel，用于转发失败的消息
+// 消费用的channel不开启确认，转发必须等broker确认后才能确认原消息，否则消息可能丢失
+func (r *RabbitMQ) confirmChannel() (*amqp.Channel, chan amqp.Confirmation, error) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.isConnected() {
+		return nil, nil, errNotConnected
+	}
+	channel, err := r.conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return nil, nil, err
+	}
+	return channel, channel.NotifyPublish(make(chan amqp.Confirmation, 1)), nil
+}
+
+// 发送一条消息并等待broker确认
+func publishConfirmed(channel *amqp.Channel, confirms chan amqp.Confirmation, exchange string, key string, msg amqp.Publishing) error {
+	if err := channel.Publish(exchange, key, false, false, msg); err != nil {
+		return err
+	}
+	select {
+	case confirm, ok := <-confirms:
+		if !ok || !confirm.Ack {
+			return errNotConfirmed
+		}
+		return nil
+	case <-time.After(3 * time.Second):
+		return errNotConfirmed
+	}
+}
+
+// 发送到带过期时间的延迟队列，过期后经默认交换机回到原队列
+func (r *RabbitMQ) retryLater(d amqp.Delivery, retries int, delay time.Duration) error {
+	channel, confirms, err := r.confirmChannel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	queueName := r.retryQueue(delay)
+	_, err = channel.QueueDeclare(queueName, true, false, false, false, amqp.Table{
+		"x-message-ttl":             int64(delay / time.Millisecond),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": r.QueueName,
+	})
+	if err != nil {
+		return err
+	}
+	return publishConfirmed(channel, confirms, "", queueName, amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Headers:      amqp.Table{headerRetryCount: int32(retries)},
+		Body:         d.Body,
+	})
+}
+
+// 发送到死信交换机
+func (r *RabbitMQ) deadLetter(d amqp.Delivery, retries int, cause error) error {
+	channel, confirms, err := r.confirmChannel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	if err := r.declareDeadLetter(channel); err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	return publishConfirmed(channel, confirms, r.deadExchange(), r.QueueName, amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    hex.EncodeToString(b),
+		Timestamp:    time.Now(),
+		Headers: amqp.Table{
+			headerRetryCount: int32(retries),
+			headerDeadReason: cause.Error(),
+		},
+		Body: d.Body,
+	})
+}
+
+// 打开一个临时channel读取死信队列，关闭时未确认的消息自动回到队列
+func (r *RabbitMQ) deadChannel() (*amqp.Channel, error) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.isConnected() {
+		return nil, errNotConnected
+	}
+	channel, err := r.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.declareDeadLetter(channel); err != nil {
+		channel.Close()
+		return nil, err
+	}
+	return channel, nil
+}
+
+func toDeadMessage(d amqp.Delivery) *DeadMessage {
+	reason, _ := d.Headers[headerDeadReason].(string)
+	return &DeadMessage{
+		ID:      d.MessageId,
+		Body:    string(d.Body),
+		Reason:  reason,
+		Retries: retryCount(d.Headers),
+		Time:    d.Timestamp,
+	}
+}
+
+// 查看死信队列中的消息，不会移除消息
+func (r *RabbitMQ) ListDead(limit int) ([]*DeadMessage, error) {
+	channel, err := r.deadChannel()
+	if err != nil {
+		return nil, err
+	}
+	// 关闭channel后读取的消息全部回到队列
+	defer channel.Close()
+	var messages []*DeadMessage
+	for len(messages) < limit {
+		d, ok, err := channel.Get(r.deadQueue(), false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		messages = append(messages, toDeadMessage(d))
+	}
+	return messages, nil
+}
+
+// 从死信队列中取出指定消息，replay为true时重新投递到原队列，否则直接丢弃
+func (r *RabbitMQ) takeDead(id string, replay bool) error {
+	channel, err := r.deadChannel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	for i := 0; i < deadScanLimit; i++ {
+		d, ok, err := channel.Get(r.deadQueue(), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if d.MessageId != id {
+			continue
+		}
+		if replay {
+			if err := r.replay(channel, d); err != nil {
+				return err
+			}
+		}
+		return d.Ack(false)
+	}
+	return errDeadNotFound
+}
+
+// 重新投递到原队列并等待确认，重试次数清零
+func (r *RabbitMQ) replay(channel *amqp.Channel, d amqp.Delivery) error {
+	if err := channel.Confirm(false); err != nil {
+		return err
+	}
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	if _, err := r.declareSimple(channel); err != nil {
+		return err
+	}
+	return publishConfirmed(channel, confirms, "", r.QueueName, amqp.Publishing{
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Body:         d.Body,
+	})
+}
+
+// 重新投递死信消息
+func (r *RabbitMQ) ReplayDead(id string) error {
+	return r.takeDead(id, true)
+}
+
+// 丢弃死信消息
+func (r *RabbitMQ) DropDead(id string) error {
+	return r.takeDead(id, false)
+}
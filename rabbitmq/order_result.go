@@ -0,0 +1,53 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/streadway/amqp"
+	"imoc-product/datamodels"
+	"log"
+	"time"
+)
+
+// 订单处理结果广播使用的交换机
+const OrderResultExchange = "imoocOrderResult"
+
+// 创建订单结果广播的RabbitMQ实例
+func NewRabbitMQOrderResult() *RabbitMQ {
+	return NewRabbitMQPubSub(OrderResultExchange)
+}
+
+// 广播订单处理结果，结果只用于通知，发送失败不影响订单
+func (r *RabbitMQ) PublishOrderResult(result *datamodels.OrderResult) error {
+	if result.Time == 0 {
+		result.Time = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return r.PublishPub(string(data))
+}
+
+// 接收订单处理结果，只有实例销毁时才返回
+func (r *RabbitMQ) ReceiveOrderResult(handle func(result *datamodels.OrderResult)) error {
+	fmt.Println("开始接收订单处理结果")
+	return r.consume(r.declareBinding("fanout"), true, func(d amqp.Delivery) {
+		result := &datamodels.OrderResult{}
+		if err := json.Unmarshal(d.Body, result); err != nil {
+			log.Printf("订单结果格式错误：%s", err)
+			return
+		}
+		handle(result)
+	})
+}
+
+// 通知订单处理结果，未配置结果广播时忽略
+func (r *RabbitMQ) notifyResult(result *datamodels.OrderResult) {
+	if r.Results == nil || result.RequestID == "" {
+		return
+	}
+	if err := r.Results.PublishOrderResult(result); err != nil {
+		log.Printf("发送订单结果失败：%s", err)
+	}
+}
@@ -0,0 +1,83 @@
+package rabbitmq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 本地发件箱
+// 未得到broker确认的消息落盘保存，后台定时重新投递，确认后删除
+// 重新投递可能产生重复消息，消费端需要保证幂等
+type Outbox struct {
+	dir string
+}
+
+const outboxSuffix = ".msg"
+
+func NewOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Outbox{dir: dir}, nil
+}
+
+// 保存消息，文件名按时间排序保证重试顺序
+func (o *Outbox) Save(message string) error {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + hex.EncodeToString(b) + outboxSuffix
+	tmpPath := filepath.Join(o.dir, name+".tmp")
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(message); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	// 写完整后再重命名，避免重试时读到半条消息
+	return os.Rename(tmpPath, filepath.Join(o.dir, name))
+}
+
+// 待重试的消息文件，按保存时间排序
+func (o *Outbox) List() ([]string, error) {
+	files, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), outboxSuffix) {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// 读取消息
+func (o *Outbox) Read(name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(o.dir, name))
+	return string(data), err
+}
+
+// 删除已确认的消息
+func (o *Outbox) Remove(name string) error {
+	return os.Remove(filepath.Join(o.dir, name))
+}
@@ -2,12 +2,15 @@ package rabbitmq
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/streadway/amqp"
 	"imoc-product/datamodels"
+	"imoc-product/repositories"
 	"imoc-product/services"
 	"log"
 	"sync"
+	"time"
 )
 
 // url格式  amqp://账号:密码@rabbitmq服务器地址:端口号/vhost
@@ -24,41 +27,70 @@ type RabbitMQ struct {
 	key string
 	// 连接信息
 	Mqurl string
+	// 简单模式下队列和消息是否持久化
+	Durable bool
+	// 等待broker确认的超时时间
+	ConfirmTimeout time.Duration
+	// 是否开启发布确认
+	reliable bool
+	// 已发布消息的序号，与broker确认中的DeliveryTag对应
+	deliveryTag uint64
+	// 等待确认的消息，按序号通知发布方，每个channel一份
+	pending map[uint64]chan bool
+	// 本地发件箱，保存未确认的消息
+	outbox *Outbox
+	// 消费失败的重试策略，为nil时使用默认策略
+	Retry *RetryPolicy
+	// 订单处理结果的广播实例，为nil时不通知
+	Results *RabbitMQ
+	// 连接可用时关闭，断开后替换为新的通道
+	connected chan struct{}
+	done      chan struct{}
 	sync.Mutex
 }
 
+var errNotConfirmed = errors.New("消息未被broker确认")
+
+// 发件箱重试间隔
+var outboxRetryInterval = 5 * time.Second
+
 // 创建RabbitMQ结构体事例
+// 连接失败不会退出进程，而是在后台按退避时间重连，连接恢复前的操作返回错误
 func NewRabbitMQ(queueName string, exchange string, key string) *RabbitMQ {
-	rabbitmq := &RabbitMQ{
+	rabbitmq := newRabbitMQ(queueName, exchange, key)
+	rabbitmq.start()
+	return rabbitmq
+}
+
+func newRabbitMQ(queueName string, exchange string, key string) *RabbitMQ {
+	return &RabbitMQ{
 		QueueName: queueName,
 		Exchange:  exchange,
 		key:       key,
 		Mqurl:     MQURL,
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
 	}
-	var err error
-	// 创建rabbitmq连接
-	rabbitmq.conn, err = retryConn(rabbitmq.Mqurl)
-	rabbitmq.failOnErr(err, "创建链接错误")
-	rabbitmq.channel, err = rabbitmq.conn.Channel()
-	rabbitmq.failOnErr(err, "获取Channels失败")
-	return rabbitmq
 }
 
-func retryConn(mqUrl string) (*amqp.Connection, error) {
-	return amqp.Dial(mqUrl)
+// 创建rabbitmq连接，失败时后台重连
+func (r *RabbitMQ) start() {
+	if err := r.connect(); err != nil {
+		log.Printf("创建链接错误：%s，后台重连", err)
+		go r.reconnect()
+	}
 }
 
 // 断开channel和connection
 func (r *RabbitMQ) Destory() {
-	r.channel.Close()
-	r.conn.Close()
-}
-
-// 错误处理函数
-func (r *RabbitMQ) failOnErr(err error, message string) {
-	if err != nil {
-		log.Fatalf("%s:%s", message, err)
-		panic(fmt.Sprintf("%s,%s", message, err))
+	close(r.done)
+	r.Lock()
+	defer r.Unlock()
+	if r.channel != nil {
+		r.channel.Close()
+	}
+	if r.conn != nil {
+		r.conn.Close()
 	}
 }
 
@@ -67,16 +99,40 @@ func NewRabbitMQSimple(queueName string) *RabbitMQ {
 	return NewRabbitMQ(queueName, "", "")
 }
 
-// 简单模式Step2: 简单模式下生产代码
-func (r *RabbitMQ) PublishSimple(message string) error {
-	r.Lock()
-	defer r.Unlock()
-	// 1.申请队列，如果队列不存在会自动创建，如果存在则跳过创建
+// 简单模式下创建持久化队列的实例，消费端与可靠模式的生产端配合使用
+// 注意：已存在的同名非持久化队列需要先删除，否则声明队列会失败
+func NewRabbitMQSimpleDurable(queueName string) *RabbitMQ {
+	rabbitmq := newRabbitMQ(queueName, "", "")
+	rabbitmq.Durable = true
+	rabbitmq.start()
+	return rabbitmq
+}
+
+// 简单模式下创建可靠投递的实例
+// 持久化队列和消息，开启发布确认，超时或被拒绝的消息写入本地发件箱定时重试
+func NewRabbitMQSimpleReliable(queueName string, outboxDir string) (*RabbitMQ, error) {
+	outbox, err := NewOutbox(outboxDir)
+	if err != nil {
+		return nil, err
+	}
+	rabbitmq := newRabbitMQ(queueName, "", "")
+	rabbitmq.Durable = true
+	rabbitmq.reliable = true
+	rabbitmq.ConfirmTimeout = 3 * time.Second
+	rabbitmq.outbox = outbox
+	rabbitmq.start()
+	go rabbitmq.retryOutbox()
+	return rabbitmq, nil
+}
+
+// 声明简单模式的队列
+func (r *RabbitMQ) declareSimple(channel *amqp.Channel) (string, error) {
+	// 申请队列，如果队列不存在会自动创建，如果存在则跳过创建
 	// 保证队列存在，消息能发送到队列中
-	_, err := r.channel.QueueDeclare(
+	_, err := channel.QueueDeclare(
 		r.QueueName,
 		// 控制消息是否持久化
-		false,
+		r.Durable,
 		// 是否自动删除
 		false,
 		// 是否具有排他性
@@ -85,11 +141,47 @@ func (r *RabbitMQ) PublishSimple(message string) error {
 		false,
 		nil,
 	)
-	if err != nil {
+	return r.QueueName, err
+}
+
+// 简单模式Step2: 简单模式下生产代码
+// 可靠模式下消息未被确认时写入发件箱，写入成功即返回nil，由后台继续投递
+func (r *RabbitMQ) PublishSimple(message string) error {
+	err := r.publishSimple(message)
+	if err == nil || r.outbox == nil {
+		return err
+	}
+	log.Printf("消息投递失败，写入发件箱：%s", err)
+	return r.outbox.Save(message)
+}
+
+// 发送消息，开启发布确认时等待broker确认
+// 只在发送时持有锁，等待确认时不阻塞其他发布
+func (r *RabbitMQ) publishSimple(message string) error {
+	tag, confirm, pending, err := r.sendSimple(message)
+	if err != nil || confirm == nil {
 		return err
 	}
+	return r.waitConfirm(tag, confirm, pending)
+}
+
+// 发送消息，开启发布确认时返回该消息的序号和确认通知
+func (r *RabbitMQ) sendSimple(message string) (uint64, chan bool, map[uint64]chan bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.isConnected() {
+		return 0, nil, nil, errNotConnected
+	}
+	// 1.申请队列
+	if _, err := r.declareSimple(r.channel); err != nil {
+		return 0, nil, nil, err
+	}
+	deliveryMode := amqp.Transient
+	if r.Durable {
+		deliveryMode = amqp.Persistent
+	}
 	// 2.发送消息到队列中
-	r.channel.Publish(
+	err := r.channel.Publish(
 		r.Exchange,
 		r.QueueName,
 		// 如果为true，会根据exchange类型和routkey规则，如果无法找到符合条件的队列那么会把发送的消息返回给发送者
@@ -97,83 +189,154 @@ func (r *RabbitMQ) PublishSimple(message string) error {
 		// 如果为true，当exchange发送消息到队列后发现队列上没有绑定消费者，则会吧消息发还给发送者。
 		false,
 		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(message),
+			ContentType:  "text/plain",
+			DeliveryMode: deliveryMode,
+			Body:         []byte(message),
 		},
 	)
-	return nil
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if !r.reliable {
+		return 0, nil, nil, nil
+	}
+	r.deliveryTag++
+	confirm := make(chan bool, 1)
+	r.pending[r.deliveryTag] = confirm
+	return r.deliveryTag, confirm, r.pending, nil
 }
 
-// 简单模式Step3: 简单模式消息代码
-func (r *RabbitMQ) ConsumeSimple(orderService services.IOrderService, productService services.IProductService) {
-	// 1.申请队列，如果队列不存在会自动创建，如果存在则跳过创建
-	// 保证队列存在，消息能发送到队列中
-	_, err := r.channel.QueueDeclare(
-		r.QueueName,
-		// 控制消息是否持久化
-		false,
-		// 是否自动删除
-		false,
-		// 是否具有排他性
-		false,
-		// 是否阻塞
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to declare an queue")
+// 等待指定序号的确认，超时后不再等待该序号
+func (r *RabbitMQ) waitConfirm(tag uint64, confirm chan bool, pending map[uint64]chan bool) error {
+	select {
+	case ack := <-confirm:
+		if !ack {
+			return errNotConfirmed
+		}
+		return nil
+	case <-time.After(r.ConfirmTimeout):
+		r.Lock()
+		delete(pending, tag)
+		r.Unlock()
+		return errNotConfirmed
+	}
+}
 
-	// 消费者流控
-	r.channel.Qos(
-		1,     // 当前消费者一次能接受的最大消息数量
-		0,     // 服务器传递的最大容量（以八位字节为单位）
-		false, // 如果设置为true 对channel可用。false为对当前队列
-	)
+// 把broker的确认按序号分发给等待的发布方
+// channel关闭后确认通道关闭，未确认的消息全部视为失败
+func (r *RabbitMQ) dispatchConfirms(confirms chan amqp.Confirmation, pending map[uint64]chan bool) {
+	for confirm := range confirms {
+		r.Lock()
+		if ch, ok := pending[confirm.DeliveryTag]; ok {
+			delete(pending, confirm.DeliveryTag)
+			ch <- confirm.Ack
+		}
+		r.Unlock()
+	}
+	r.Lock()
+	for tag, ch := range pending {
+		delete(pending, tag)
+		ch <- false
+	}
+	r.Unlock()
+}
 
-	// 2.接收消息
-	msgs, err := r.channel.Consume(
-		r.QueueName,
-		// 用来区分多个消费者
-		"",
-		// 是否自动应应答
-		false,
-		// 是否具有排他性
-		false,
-		// 如果设置为true，表示不能将同一个connection中发送的消息传递给这个connection中的消费者
-		false,
-		// 队列消费是否阻塞  false为阻塞
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to consume messages")
-
-	forever := make(chan bool)
-	// 3.启用协程处理消息
-	go func() {
-		for d := range msgs {
-			// 实现我们要处理的逻辑函数
-			message := &datamodels.Message{}
-			err = json.Unmarshal([]byte(d.Body), message)
-			if err != nil {
-				fmt.Println(err)
-			}
-			// 插入订单
-			fmt.Println(message)
-			_, err = orderService.InsertOrderByMessage(message)
-			if err != nil {
-				fmt.Println(err)
-			}
-			// 扣除商品数量
-			err = productService.SubNumberOne(message.ProductID)
-			if err != nil {
-				fmt.Println(err)
-			}
+// 定时重新投递发件箱中的消息
+func (r *RabbitMQ) retryOutbox() {
+	ticker := time.NewTicker(outboxRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.flushOutbox()
+		}
+	}
+}
+
+// 按保存顺序投递发件箱中的消息，遇到失败时等待下次重试
+func (r *RabbitMQ) flushOutbox() {
+	names, err := r.outbox.List()
+	if err != nil {
+		log.Printf("读取发件箱失败：%s", err)
+		return
+	}
+	for _, name := range names {
+		message, err := r.outbox.Read(name)
+		if err != nil {
+			log.Printf("读取发件箱消息失败：%s", err)
+			continue
+		}
+		err = r.publishSimple(message)
+		if err != nil {
+			log.Printf("发件箱消息重试失败：%s", err)
+			return
+		}
+		if err := r.outbox.Remove(name); err != nil {
+			log.Printf("删除发件箱消息失败：%s", err)
+		}
+	}
+}
 
-			// 如果为true表示确认所有未确认的消息. 为false表示确认当前消息
-			d.Ack(false)
+// 简单模式Step3: 简单模式消息代码
+// 处理失败的消息按重试策略延迟重试，无法处理的消息进入死信队列
+// 连接断开后自动恢复消费，只有实例销毁时才返回
+func (r *RabbitMQ) ConsumeSimple(seckillService services.ISeckillService) error {
+	setup := func(channel *amqp.Channel) (string, error) {
+		// 1.申请队列
+		queueName, err := r.declareSimple(channel)
+		if err != nil {
+			return "", err
 		}
-	}()
+		if err := r.declareDeadLetter(channel); err != nil {
+			return "", err
+		}
+		// 消费者流控
+		err = channel.Qos(
+			1,     // 当前消费者一次能接受的最大消息数量
+			0,     // 服务器传递的最大容量（以八位字节为单位）
+			false, // 如果设置为true 对channel可用。false为对当前队列
+		)
+		return queueName, err
+	}
+
 	log.Printf("[*] Waiting for messages, To exit pres CTRL+C")
-	<-forever
+	// 2.接收消息并处理
+	return r.consume(setup, false, func(d amqp.Delivery) {
+		// 实现我们要处理的逻辑函数
+		message := &datamodels.Message{}
+		err := json.Unmarshal([]byte(d.Body), message)
+		if err != nil {
+			// 消息格式错误，重试也无法处理
+			r.handleFailure(d, err, false)
+			return
+		}
+		// 插入订单并扣除商品数量，两者在同一事务中完成，重复投递的消息不会重复处理
+		fmt.Println(message)
+		order, err := seckillService.PlaceOrderByMessage(message)
+		if err == repositories.ErrRequestIDConflict {
+			// 请求ID属于其他订单，重试也无法处理
+			// 处理结果按请求ID通知，不能发送失败结果覆盖其他订单的结果
+			r.handleFailure(d, err, false)
+			return
+		}
+		if err != nil {
+			if r.handleFailure(d, err, true) {
+				result := datamodels.NewOrderResult(message, nil)
+				result.Status = datamodels.ResultFailed
+				r.notifyResult(result)
+			}
+			return
+		}
+		if order.OrderStatus == datamodels.OrderFailed {
+			log.Printf("order %d failed: product %d sold out", order.ID, order.ProductId)
+		}
+		r.notifyResult(datamodels.NewOrderResult(message, order))
+
+		// 如果为true表示确认所有未确认的消息. 为false表示确认当前消息
+		d.Ack(false)
+	})
 }
 
 // 订阅模式创建RabbitMQ实例
@@ -182,13 +345,12 @@ func NewRabbitMQPubSub(exchangeName string) *RabbitMQ {
 	return NewRabbitMQ("", exchangeName, "")
 }
 
-// 订阅模式生产
-func (r *RabbitMQ) PublishPub(message string) {
-	// 1.尝试创建交换机
-	err := r.channel.ExchangeDeclare(
+// 尝试创建交换机
+func (r *RabbitMQ) declareExchange(channel *amqp.Channel, kind string) error {
+	return channel.ExchangeDeclare(
 		r.Exchange,
-		// 交换机类型  fanout: 广播类型
-		"fanout",
+		// 交换机类型  fanout: 广播类型 direct: 路由 topic: 话题
+		kind,
 		// 是否持久化
 		true,
 		// 是否自动删除
@@ -199,12 +361,23 @@ func (r *RabbitMQ) PublishPub(message string) {
 		false,
 		nil,
 	)
-	r.failOnErr(err, "Failed to declare an exchange")
+}
 
+// 发送消息到交换机
+func (r *RabbitMQ) publishExchange(kind string, key string, message string) error {
+	r.Lock()
+	defer r.Unlock()
+	if !r.isConnected() {
+		return errNotConnected
+	}
+	// 1.尝试创建交换机
+	if err := r.declareExchange(r.channel, kind); err != nil {
+		return fmt.Errorf("Failed to declare an exchange: %w", err)
+	}
 	// 2.发送消息
-	r.channel.Publish(
+	err := r.channel.Publish(
 		r.Exchange,
-		"",
+		key,
 		false,
 		false,
 		amqp.Publishing{
@@ -212,72 +385,60 @@ func (r *RabbitMQ) PublishPub(message string) {
 			Body:        []byte(message),
 		},
 	)
-
+	if err != nil {
+		return fmt.Errorf("Failed to publish message: %w", err)
+	}
+	return nil
 }
 
-// 订阅模式消费
-func (r *RabbitMQ) ReceivePub() {
-	// 1.尝试创建交换机
-	err := r.channel.ExchangeDeclare(
-		r.Exchange,
-		// 交换机类型  fanout: 广播类型
-		"fanout",
-		// 是否持久化
-		true,
-		// 是否自动删除
-		false,
-		// 设置为true表示这个exchange不可以被client用来推送消息，仅用来进行exchange和exchange之间的绑定
-		false,
-		// 阻塞
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to declare an exchange")
-
-	// 2.尝试创建队列。主意：队列名称不写
-	q, err := r.channel.QueueDeclare(
-		"", //随机生产队列名称
-		false,
-		false,
-		true,
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to declare an queue")
-
-	// 绑定队列到exchange中
-	err = r.channel.QueueBind(
-		q.Name,
-		"", // 在pub/sub模式下，这里的key要为空
-		r.Exchange,
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to bind exchange")
-
-	// 消费消息
-	messages, err := r.channel.Consume(
-		q.Name,
-		"",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to consume messages")
+// 声明交换机和随机名称的排他队列并绑定，用于订阅、路由、话题模式的消费
+func (r *RabbitMQ) declareBinding(kind string) func(channel *amqp.Channel) (string, error) {
+	return func(channel *amqp.Channel) (string, error) {
+		// 1.尝试创建交换机
+		if err := r.declareExchange(channel, kind); err != nil {
+			return "", fmt.Errorf("Failed to declare an exchange: %w", err)
+		}
+		// 2.尝试创建队列。主意：队列名称不写
+		q, err := channel.QueueDeclare(
+			"", //随机生产队列名称
+			false,
+			false,
+			true,
+			false,
+			nil,
+		)
+		if err != nil {
+			return "", fmt.Errorf("Failed to declare an queue: %w", err)
+		}
+		// 绑定队列到exchange中
+		err = channel.QueueBind(
+			q.Name,
+			r.key, // 在pub/sub模式下，这里的key要为空
+			r.Exchange,
+			false,
+			nil,
+		)
+		if err != nil {
+			return "", fmt.Errorf("Failed to bind exchange: %w", err)
+		}
+		return q.Name, nil
+	}
+}
 
-	forever := make(chan bool)
+// 打印收到的消息
+func logDelivery(d amqp.Delivery) {
+	log.Printf("Received a message: %s", d.Body)
+}
 
-	go func() {
-		for d := range messages {
-			log.Printf("Received a message: %s", d.Body)
-		}
-	}()
+// 订阅模式生产
+func (r *RabbitMQ) PublishPub(message string) error {
+	return r.publishExchange("fanout", "", message)
+}
 
+// 订阅模式消费
+func (r *RabbitMQ) ReceivePub() error {
 	fmt.Println("退出请按 CTRL+C")
-	<-forever
-
+	return r.consume(r.declareBinding("fanout"), true, logDelivery)
 }
 
 // 路由模式创建RabbitMQ实例
@@ -286,100 +447,14 @@ func NewRabbitMQRouting(exchange string, routingKey string) *RabbitMQ {
 }
 
 // 路由模式发送消息
-func (r *RabbitMQ) PublishRouting(message string) {
-	// 1.尝试创建交换机
-	err := r.channel.ExchangeDeclare(
-		r.Exchange,
-		// 交换机类型
-		"direct",
-		// 是否持久化
-		true,
-		// 是否自动删除
-		false,
-		// 设置为true表示这个exchange不可以被client用来推送消息，仅用来进行exchange和exchange之间的绑定
-		false,
-		// 阻塞
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to declare an exchange")
-
-	// 2.发送消息
-	r.channel.Publish(
-		r.Exchange,
-		r.key,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(message),
-		},
-	)
-
+func (r *RabbitMQ) PublishRouting(message string) error {
+	return r.publishExchange("direct", r.key, message)
 }
 
 // 路由模式接收消息
-func (r *RabbitMQ) ReceivedRouting() {
-	// 1.尝试创建交换机
-	err := r.channel.ExchangeDeclare(
-		r.Exchange,
-		// 交换机类型  fanout: 广播类型
-		"direct",
-		// 是否持久化
-		true,
-		// 是否自动删除
-		false,
-		// 设置为true表示这个exchange不可以被client用来推送消息，仅用来进行exchange和exchange之间的绑定
-		false,
-		// 阻塞
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to declare an exchange")
-
-	// 2.尝试创建队列。主意：队列名称不写
-	q, err := r.channel.QueueDeclare(
-		"", //随机生产队列名称
-		false,
-		false,
-		true,
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to declare an queue")
-
-	// 绑定队列到exchange中
-	err = r.channel.QueueBind(
-		q.Name,
-		r.key, // 在pub/sub模式下，这里的key要为空
-		r.Exchange,
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to bind exchange")
-
-	// 消费消息
-	messages, err := r.channel.Consume(
-		q.Name,
-		"",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to consume messages")
-
-	forever := make(chan bool)
-
-	go func() {
-		for d := range messages {
-			log.Printf("Received a message: %s", d.Body)
-		}
-	}()
-
+func (r *RabbitMQ) ReceivedRouting() error {
 	fmt.Println("退出请按 CTRL+C")
-	<-forever
+	return r.consume(r.declareBinding("direct"), true, logDelivery)
 }
 
 // 话题模式创建RabbitMQ实例
@@ -388,102 +463,15 @@ func NewRabbitMQTopic(exchange string, routingKey string) *RabbitMQ {
 }
 
 // 话题模式发送消息
-func (r *RabbitMQ) PublishTopic(message string) {
-	// 1.尝试创建交换机
-	err := r.channel.ExchangeDeclare(
-		r.Exchange,
-		// 交换机类型
-		"topic",
-		// 是否持久化
-		true,
-		// 是否自动删除
-		false,
-		// 设置为true表示这个exchange不可以被client用来推送消息，仅用来进行exchange和exchange之间的绑定
-		false,
-		// 阻塞
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to declare an exchange")
-
-	// 2.发送消息
-	err = r.channel.Publish(
-		r.Exchange,
-		r.key,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(message),
-		},
-	)
-	r.failOnErr(err, "Failed to publish message")
-
+func (r *RabbitMQ) PublishTopic(message string) error {
+	return r.publishExchange("topic", r.key, message)
 }
 
 // 话题模式接收消息
 // 要注意key，规则
 // 其中"*"用于匹配一个单词，"#"用于匹配多个单词（可以使零个）
 // 匹配 imooc.* 表示匹配 imooc.hello，但是 imooc.hello.one 需要用 imooc.# 才能匹配到
-func (r *RabbitMQ) ReceivedTopic() {
-	// 1.尝试创建交换机
-	err := r.channel.ExchangeDeclare(
-		r.Exchange,
-		// 交换机类型  fanout: 广播类型
-		"topic",
-		// 是否持久化
-		true,
-		// 是否自动删除
-		false,
-		// 设置为true表示这个exchange不可以被client用来推送消息，仅用来进行exchange和exchange之间的绑定
-		false,
-		// 阻塞
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to declare an exchange")
-
-	// 2.尝试创建队列。主意：队列名称不写
-	q, err := r.channel.QueueDeclare(
-		"", //随机生产队列名称
-		false,
-		false,
-		true,
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to declare an queue")
-
-	// 绑定队列到exchange中
-	err = r.channel.QueueBind(
-		q.Name,
-		r.key, // 在pub/sub模式下，这里的key要为空
-		r.Exchange,
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to bind exchange")
-
-	// 消费消息
-	messages, err := r.channel.Consume(
-		q.Name,
-		"",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	r.failOnErr(err, "Failed to consume messages")
-
-	forever := make(chan bool)
-
-	go func() {
-		for d := range messages {
-			log.Printf("Received a message: %s", d.Body)
-		}
-	}()
-
+func (r *RabbitMQ) ReceivedTopic() error {
 	fmt.Println("退出请按 CTRL+C")
-	<-forever
+	return r.consume(r.declareBinding("topic"), true, logDelivery)
 }
@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"database/sql"
+	"imoc-product/common"
+	"imoc-product/datamodels"
+)
+
+// 黑名单审计表，只追加不修改:
+//
+//	CREATE TABLE blacklist_audit (
+//	  ID int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+//	  userID int NOT NULL DEFAULT 0,
+//	  ip varchar(64) NOT NULL DEFAULT '',
+//	  action varchar(16) NOT NULL,
+//	  detail varchar(255) NOT NULL DEFAULT '',
+//	  operator varchar(64) NOT NULL DEFAULT '',
+//	  createTime datetime NOT NULL,
+//	  KEY idx_user (userID),
+//	  KEY idx_create (createTime)
+//	);
+type IBlacklistAuditRepository interface {
+	Conn() error
+	Insert(*datamodels.BlacklistAudit) (int64, error)
+	// 最近的审计记录，按时间倒序
+	SelectRecent(limit int) ([]*datamodels.BlacklistAudit, error)
+	SelectByUserID(userID int64) ([]*datamodels.BlacklistAudit, error)
+}
+
+type BlacklistAuditManager struct {
+	table     string
+	mysqlConn *sql.DB
+}
+
+func NewBlacklistAuditManager(table string, db *sql.DB) IBlacklistAuditRepository {
+	return &BlacklistAuditManager{table: table, mysqlConn: db}
+}
+
+func (b *BlacklistAuditManager) Conn() error {
+	if b.mysqlConn == nil {
+		mysql, err := common.NewMysqlConn()
+		if err != nil {
+			return err
+		}
+		b.mysqlConn = mysql
+	}
+	if b.table == "" {
+		b.table = "blacklist_audit"
+	}
+	return nil
+}
+
+func (b *BlacklistAuditManager) Insert(audit *datamodels.BlacklistAudit) (int64, error) {
+	if err := b.Conn(); err != nil {
+		return 0, err
+	}
+	sql := "INSERT INTO " + b.table + " (userID, ip, action, detail, operator, createTime) VALUES (?, ?, ?, ?, ?, ?)"
+	result, err := b.mysqlConn.Exec(sql, audit.UserID, audit.IP, audit.Action, audit.Detail,
+		audit.Operator, audit.CreateTime.Format(timeLayout))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (b *BlacklistAuditManager) SelectRecent(limit int) ([]*datamodels.BlacklistAudit, error) {
+	if err := b.Conn(); err != nil {
+		return nil, err
+	}
+	return b.selectAudits("SELECT * FROM "+b.table+" ORDER BY ID DESC LIMIT ?", limit)
+}
+
+func (b *BlacklistAuditManager) SelectByUserID(userID int64) ([]*datamodels.BlacklistAudit, error) {
+	if err := b.Conn(); err != nil {
+		return nil, err
+	}
+	return b.selectAudits("SELECT * FROM "+b.table+" WHERE userID=? ORDER BY ID DESC", userID)
+}
+
+func (b *BlacklistAuditManager) selectAudits(query string, args ...interface{}) (auditArray []*datamodels.BlacklistAudit, err error) {
+	rows, err := b.mysqlConn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := common.GetResultRows(rows)
+	for _, v := range results {
+		audit := &datamodels.BlacklistAudit{}
+		common.DataToStructByTagSql(v, audit)
+		auditArray = append(auditArray, audit)
+	}
+	return
+}
@@ -0,0 +1,132 @@
+package repositories
+
+import (
+	"database/sql"
+	"imoc-product/common"
+	"imoc-product/datamodels"
+	"time"
+)
+
+// 黑名单表，每个用户一条记录，expireTime为NULL表示永久有效:
+//
+//	CREATE TABLE blacklist (
+//	  ID int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+//	  userID int NOT NULL,
+//	  reason varchar(255) NOT NULL DEFAULT '',
+//	  operator varchar(64) NOT NULL DEFAULT '',
+//	  expireTime datetime NULL,
+//	  createTime datetime NOT NULL,
+//	  UNIQUE KEY uk_user (userID),
+//	  KEY idx_expire (expireTime)
+//	);
+type IBlacklistRepository interface {
+	Conn() error
+	// 添加黑名单，用户已在黑名单中时更新原因和过期时间
+	Upsert(*datamodels.Blacklist) error
+	Delete(userID int64) (bool, error)
+	SelectByUserID(userID int64) (*datamodels.Blacklist, error)
+	SelectAll() ([]*datamodels.Blacklist, error)
+	// 查询在t时刻仍然有效的黑名单
+	SelectActive(t time.Time) ([]*datamodels.Blacklist, error)
+}
+
+type BlacklistManager struct {
+	table     string
+	mysqlConn *sql.DB
+}
+
+// 查询的列，永久有效的记录expireTime返回空字符串
+const blacklistColumns = "ID, userID, reason, operator, IFNULL(expireTime, '') AS expireTime, createTime"
+
+func NewBlacklistManager(table string, db *sql.DB) IBlacklistRepository {
+	return &BlacklistManager{table: table, mysqlConn: db}
+}
+
+func (b *BlacklistManager) Conn() error {
+	if b.mysqlConn == nil {
+		mysql, err := common.NewMysqlConn()
+		if err != nil {
+			return err
+		}
+		b.mysqlConn = mysql
+	}
+	if b.table == "" {
+		b.table = "blacklist"
+	}
+	return nil
+}
+
+func (b *BlacklistManager) Upsert(blacklist *datamodels.Blacklist) error {
+	if err := b.Conn(); err != nil {
+		return err
+	}
+	var expireTime interface{}
+	if !blacklist.ExpireTime.IsZero() {
+		expireTime = blacklist.ExpireTime.Format(timeLayout)
+	}
+	sql := "INSERT INTO " + b.table + " (userID, reason, operator, expireTime, createTime) VALUES (?, ?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE reason=VALUES(reason), operator=VALUES(operator), " +
+		"expireTime=VALUES(expireTime), createTime=VALUES(createTime)"
+	_, err := b.mysqlConn.Exec(sql, blacklist.UserID, blacklist.Reason, blacklist.Operator,
+		expireTime, blacklist.CreateTime.Format(timeLayout))
+	return err
+}
+
+func (b *BlacklistManager) Delete(userID int64) (bool, error) {
+	if err := b.Conn(); err != nil {
+		return false, err
+	}
+	result, err := b.mysqlConn.Exec("DELETE FROM "+b.table+" WHERE userID=?", userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (b *BlacklistManager) SelectByUserID(userID int64) (*datamodels.Blacklist, error) {
+	if err := b.Conn(); err != nil {
+		return &datamodels.Blacklist{}, err
+	}
+	row, err := b.mysqlConn.Query("SELECT "+blacklistColumns+" FROM "+b.table+" WHERE userID=?", userID)
+	if err != nil {
+		return &datamodels.Blacklist{}, err
+	}
+	defer row.Close()
+	result := common.GetResultRow(row)
+	if len(result) == 0 {
+		return &datamodels.Blacklist{}, nil
+	}
+	blacklist := &datamodels.Blacklist{}
+	common.DataToStructByTagSql(result, blacklist)
+	return blacklist, nil
+}
+
+func (b *BlacklistManager) SelectAll() ([]*datamodels.Blacklist, error) {
+	if err := b.Conn(); err != nil {
+		return nil, err
+	}
+	return b.selectBlacklists("SELECT " + blacklistColumns + " FROM " + b.table + " ORDER BY createTime DESC")
+}
+
+func (b *BlacklistManager) SelectActive(t time.Time) ([]*datamodels.Blacklist, error) {
+	if err := b.Conn(); err != nil {
+		return nil, err
+	}
+	return b.selectBlacklists("SELECT "+blacklistColumns+" FROM "+b.table+" WHERE expireTime IS NULL OR expireTime>?", t.Format(timeLayout))
+}
+
+func (b *BlacklistManager) selectBlacklists(query string, args ...interface{}) (blacklistArray []*datamodels.Blacklist, err error) {
+	rows, err := b.mysqlConn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := common.GetResultRows(rows)
+	for _, v := range results {
+		blacklist := &datamodels.Blacklist{}
+		common.DataToStructByTagSql(v, blacklist)
+		blacklistArray = append(blacklistArray, blacklist)
+	}
+	return
+}
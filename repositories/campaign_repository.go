@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"database/sql"
+	"imoc-product/common"
+	"imoc-product/datamodels"
+	"strconv"
+	"time"
+)
+
+// 秒杀活动表:
+//
+//	CREATE TABLE campaign (
+//	  ID int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+//	  productID int NOT NULL,
+//	  seckillPrice decimal(10,2) NOT NULL,
+//	  stock int NOT NULL,
+//	  startTime datetime NOT NULL,
+//	  endTime datetime NOT NULL,
+//	  userLimit int NOT NULL DEFAULT 1,
+//	  KEY idx_product_time (productID, endTime)
+//	);
+type ICampaignRepository interface {
+	Conn() error
+	Insert(*datamodels.Campaign) (int64, error)
+	Delete(int64) bool
+	Update(*datamodels.Campaign) error
+	SelectByKey(int64) (*datamodels.Campaign, error)
+	SelectAll() ([]*datamodels.Campaign, error)
+	// 查询在t时刻之后结束的活动，包括进行中和未开始的活动
+	SelectEndAfter(t time.Time) ([]*datamodels.Campaign, error)
+}
+
+type CampaignManager struct {
+	table     string
+	mysqlConn *sql.DB
+}
+
+func NewCampaignManager(table string, db *sql.DB) ICampaignRepository {
+	return &CampaignManager{table: table, mysqlConn: db}
+}
+
+// 数据库时间格式
+const timeLayout = "2006-01-02 15:04:05"
+
+func (c *CampaignManager) Conn() error {
+	if c.mysqlConn == nil {
+		mysql, err := common.NewMysqlConn()
+		if err != nil {
+			return err
+		}
+		c.mysqlConn = mysql
+	}
+	if c.table == "" {
+		c.table = "campaign"
+	}
+	return nil
+}
+
+func (c *CampaignManager) Insert(campaign *datamodels.Campaign) (campaignID int64, err error) {
+	if err = c.Conn(); err != nil {
+		return
+	}
+	sql := "INSERT " + c.table + " SET productID=?, seckillPrice=?, stock=?, startTime=?, endTime=?, userLimit=?"
+	result, err := c.mysqlConn.Exec(sql, campaign.ProductID, campaign.SeckillPrice, campaign.Stock,
+		campaign.StartTime.Format(timeLayout), campaign.EndTime.Format(timeLayout), campaign.UserLimit)
+	if err != nil {
+		return
+	}
+	return result.LastInsertId()
+}
+
+func (c *CampaignManager) Delete(campaignID int64) bool {
+	if err := c.Conn(); err != nil {
+		return false
+	}
+	_, err := c.mysqlConn.Exec("DELETE FROM "+c.table+" WHERE ID=?", campaignID)
+	return err == nil
+}
+
+func (c *CampaignManager) Update(campaign *datamodels.Campaign) error {
+	if err := c.Conn(); err != nil {
+		return err
+	}
+	sql := "UPDATE " + c.table + " SET productID=?, seckillPrice=?, stock=?, startTime=?, endTime=?, userLimit=? " +
+		"WHERE ID=" + strconv.FormatInt(campaign.ID, 10)
+	_, err := c.mysqlConn.Exec(sql, campaign.ProductID, campaign.SeckillPrice, campaign.Stock,
+		campaign.StartTime.Format(timeLayout), campaign.EndTime.Format(timeLayout), campaign.UserLimit)
+	return err
+}
+
+func (c *CampaignManager) SelectByKey(campaignID int64) (campaignResult *datamodels.Campaign, err error) {
+	if err = c.Conn(); err != nil {
+		return &datamodels.Campaign{}, err
+	}
+	row, err := c.mysqlConn.Query("SELECT * FROM "+c.table+" WHERE ID=?", campaignID)
+	if err != nil {
+		return &datamodels.Campaign{}, err
+	}
+	defer row.Close()
+	result := common.GetResultRow(row)
+	if len(result) == 0 {
+		return &datamodels.Campaign{}, nil
+	}
+	campaignResult = &datamodels.Campaign{}
+	common.DataToStructByTagSql(result, campaignResult)
+	return
+}
+
+func (c *CampaignManager) SelectAll() ([]*datamodels.Campaign, error) {
+	if err := c.Conn(); err != nil {
+		return nil, err
+	}
+	return c.selectCampaigns("SELECT * FROM " + c.table)
+}
+
+func (c *CampaignManager) SelectEndAfter(t time.Time) ([]*datamodels.Campaign, error) {
+	if err := c.Conn(); err != nil {
+		return nil, err
+	}
+	return c.selectCampaigns("SELECT * FROM "+c.table+" WHERE endTime>?", t.Format(timeLayout))
+}
+
+func (c *CampaignManager) selectCampaigns(query string, args ...interface{}) (campaignArray []*datamodels.Campaign, err error) {
+	rows, err := c.mysqlConn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := common.GetResultRows(rows)
+	for _, v := range results {
+		campaign := &datamodels.Campaign{}
+		common.DataToStructByTagSql(v, campaign)
+		campaignArray = append(campaignArray, campaign)
+	}
+	return
+}
@@ -2,14 +2,44 @@ package repositories
 
 import (
 	"database/sql"
+	"errors"
 	"imoc-product/common"
 	"imoc-product/datamodels"
 	"strconv"
+	"time"
 )
 
+// order_table通过唯一的requestID保证同一请求只有一个订单:
+//   ALTER TABLE order_table ADD COLUMN requestID varchar(64) NOT NULL DEFAULT '';
+//   UPDATE order_table SET requestID=CONCAT('legacy-', ID) WHERE requestID='';
+//   ALTER TABLE order_table ADD UNIQUE KEY uk_request_id (requestID);
+// 超时未支付的订单按创建时间过期:
+//   ALTER TABLE order_table ADD COLUMN createTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//     ADD KEY idx_status_time (orderStatus, createTime);
+// 支付成功后记录交易号:
+//   ALTER TABLE order_table ADD COLUMN tradeNo varchar(64) NOT NULL DEFAULT '';
+// 待支付使用单独的状态8，升级前秒杀创建的待支付订单状态为1，需要按升级时间迁移:
+//   UPDATE order_table SET orderStatus=8 WHERE orderStatus=1 AND tradeNo='' AND createTime>='<支付上线时间>';
+// 记录下单时的秒杀活动，限购按活动计算:
+//   ALTER TABLE order_table ADD COLUMN campaignID int NOT NULL DEFAULT 0;
+
+// 请求ID由客户端传入，可能被其他用户或其他商品的订单占用
+var ErrRequestIDConflict = errors.New("请求ID已被其他订单使用！")
+
 type IOrderRepository interface {
 	Conn() error
 	Insert(*datamodels.Order) (int64, error)
+	SelectByRequestID(string) (*datamodels.Order, error)
+	// 在事务中幂等插入，返回订单ID以及是否为新插入的订单
+	// 已有订单的用户或商品不同时返回ErrRequestIDConflict
+	InsertTx(*sql.Tx, *datamodels.Order) (int64, bool, error)
+	// 订单状态为from时才更新为to，返回是否更新成功
+	UpdateStatusFrom(orderID int64, from int64, to int64) (bool, error)
+	UpdateStatusFromTx(tx *sql.Tx, orderID int64, from int64, to int64) (bool, error)
+	// 订单状态为from时才更新为to并记录交易号，返回是否更新成功
+	UpdatePaymentFrom(orderID int64, from int64, to int64, tradeNo string) (bool, error)
+	// 查询在before之前创建且状态为status的订单
+	SelectCreatedBefore(status int64, before time.Time, limit int) ([]*datamodels.Order, error)
 	Delete(int64) bool
 	Update(*datamodels.Order) error
 	SelectByKey(int64) (*datamodels.Order, error)
@@ -45,12 +75,15 @@ func (o *OrderMangerRepository) Insert(order *datamodels.Order) (orderID int64,
 		return
 	}
 
-	sql := "INSERT " + o.table + " set userID=?, productID=?, orderStatus=?"
+	if order.RequestID == "" {
+		order.RequestID = common.NewRequestID()
+	}
+	sql := "INSERT " + o.table + " set userID=?, productID=?, orderStatus=?, requestID=?, campaignID=?"
 	stmt, err := o.mysqlConn.Prepare(sql)
 	if err != nil {
 		return
 	}
-	result, err := stmt.Exec(order.UserId, order.ProductId, order.OrderStatus)
+	result, err := stmt.Exec(order.UserId, order.ProductId, order.OrderStatus, order.RequestID, order.CampaignID)
 	if err != nil {
 		return
 	}
@@ -58,6 +91,119 @@ func (o *OrderMangerRepository) Insert(order *datamodels.Order) (orderID int64,
 	return result.LastInsertId()
 }
 
+func (o *OrderMangerRepository) InsertTx(tx *sql.Tx, order *datamodels.Order) (orderID int64, created bool, err error) {
+	if err = o.Conn(); err != nil {
+		return
+	}
+	if order.RequestID == "" {
+		order.RequestID = common.NewRequestID()
+	}
+
+	sql := "INSERT IGNORE " + o.table + " set userID=?, productID=?, orderStatus=?, requestID=?, campaignID=?"
+	result, err := tx.Exec(sql, order.UserId, order.ProductId, order.OrderStatus, order.RequestID, order.CampaignID)
+	if err != nil {
+		return
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected > 0 {
+		orderID, err = result.LastInsertId()
+		return orderID, true, err
+	}
+	// 重复请求，在同一事务中读取已有订单，只有同一用户同一商品的订单才视为重复
+	var userID, productID int64
+	row := tx.QueryRow("SELECT ID, orderStatus, userID, productID, campaignID FROM "+o.table+" WHERE requestID=?", order.RequestID)
+	if err = row.Scan(&orderID, &order.OrderStatus, &userID, &productID, &order.CampaignID); err != nil {
+		return
+	}
+	if userID != order.UserId || productID != order.ProductId {
+		return 0, false, ErrRequestIDConflict
+	}
+	return orderID, false, nil
+}
+
+func (o *OrderMangerRepository) UpdateStatusFrom(orderID int64, from int64, to int64) (bool, error) {
+	if err := o.Conn(); err != nil {
+		return false, err
+	}
+	result, err := o.mysqlConn.Exec("UPDATE "+o.table+" set orderStatus=? where ID=? and orderStatus=?", to, orderID, from)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (o *OrderMangerRepository) UpdateStatusFromTx(tx *sql.Tx, orderID int64, from int64, to int64) (bool, error) {
+	if err := o.Conn(); err != nil {
+		return false, err
+	}
+	result, err := tx.Exec("UPDATE "+o.table+" set orderStatus=? where ID=? and orderStatus=?", to, orderID, from)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (o *OrderMangerRepository) UpdatePaymentFrom(orderID int64, from int64, to int64, tradeNo string) (bool, error) {
+	if err := o.Conn(); err != nil {
+		return false, err
+	}
+	result, err := o.mysqlConn.Exec("UPDATE "+o.table+" set orderStatus=?, tradeNo=? where ID=? and orderStatus=?",
+		to, tradeNo, orderID, from)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (o *OrderMangerRepository) SelectCreatedBefore(status int64, before time.Time, limit int) (orderArray []*datamodels.Order, err error) {
+	if err = o.Conn(); err != nil {
+		return nil, err
+	}
+
+	sql := "SELECT * FROM " + o.table + " WHERE orderStatus=? AND createTime<? ORDER BY ID LIMIT ?"
+	rows, err := o.mysqlConn.Query(sql, status, before.Format("2006-01-02 15:04:05"), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := common.GetResultRows(rows)
+	for _, v := range result {
+		order := &datamodels.Order{}
+		common.DataToStructByTagSql(v, order)
+		orderArray = append(orderArray, order)
+	}
+	return
+}
+
+func (o *OrderMangerRepository) SelectByRequestID(requestID string) (orderResult *datamodels.Order, err error) {
+	if err = o.Conn(); err != nil {
+		return &datamodels.Order{}, err
+	}
+
+	sql := "SELECT * FROM " + o.table + " WHERE requestID=?"
+	row, err := o.mysqlConn.Query(sql, requestID)
+	if err != nil {
+		return &datamodels.Order{}, err
+	}
+	defer row.Close()
+
+	result := common.GetResultRow(row)
+	if len(result) == 0 {
+		return &datamodels.Order{}, nil
+	}
+
+	orderResult = &datamodels.Order{}
+	common.DataToStructByTagSql(result, orderResult)
+	return
+}
+
 func (o *OrderMangerRepository) Delete(productID int64) bool {
 	if err := o.Conn(); err != nil {
 		return false
@@ -18,7 +18,10 @@ type IProduct interface {
 	Update(*datamodels.Product) error
 	SelectByKey(int64) (*datamodels.Product, error)
 	SelectAll() ([]*datamodels.Product, error)
-	SubProductNum(productID int64) error
+	// 在事务中扣减一件库存，库存不足时返回false，不会扣成负数
+	SubProductNumTx(tx *sql.Tx, productID int64) (bool, error)
+	// 在事务中退回库存
+	AddProductNumTx(tx *sql.Tx, productID int64, num int64) error
 }
 
 type ProductManager struct {
@@ -26,16 +29,26 @@ type ProductManager struct {
 	mysqlConn *sql.DB
 }
 
-func (p *ProductManager) SubProductNum(productID int64) error {
+func (p *ProductManager) SubProductNumTx(tx *sql.Tx, productID int64) (bool, error) {
 	if err := p.Conn(); err != nil {
-		return err
+		return false, err
 	}
-	sql := "update " + p.table + " set " + " productNum=productNum-1 where ID = " + strconv.FormatInt(productID, 10)
-	stmt, err := p.mysqlConn.Prepare(sql)
+	result, err := tx.Exec("update "+p.table+" set productNum=productNum-1 where ID=? and productNum>0", productID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
 	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (p *ProductManager) AddProductNumTx(tx *sql.Tx, productID int64, num int64) error {
+	if err := p.Conn(); err != nil {
 		return err
 	}
-	_, err = stmt.Exec()
+	_, err := tx.Exec("update "+p.table+" set productNum=productNum+? where ID=?", num, productID)
 	return err
 }
 
@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"database/sql"
+	"imoc-product/common"
+)
+
+// 用户在每场秒杀活动中对每个商品的购买数量，campaignID为0表示没有活动时的购买:
+//
+//	CREATE TABLE purchase_ledger (
+//	  userID int NOT NULL,
+//	  productID int NOT NULL,
+//	  campaignID int NOT NULL DEFAULT 0,
+//	  num int NOT NULL DEFAULT 0,
+//	  PRIMARY KEY (userID, productID, campaignID)
+//	);
+//
+// 已有的表:
+//
+//	ALTER TABLE purchase_ledger ADD COLUMN campaignID int NOT NULL DEFAULT 0 AFTER productID,
+//	  DROP PRIMARY KEY, ADD PRIMARY KEY (userID, productID, campaignID);
+type IPurchaseRepository interface {
+	Conn() error
+	// 用户在活动中已购买的数量
+	SelectNum(userID int64, productID int64, campaignID int64) (int64, error)
+	// 在事务中增加一件购买数量，已达到limit时不增加并返回false，limit<=0表示不限购
+	AddNumTx(tx *sql.Tx, userID int64, productID int64, campaignID int64, limit int64) (bool, error)
+	// 在事务中减少一件购买数量
+	SubNumTx(tx *sql.Tx, userID int64, productID int64, campaignID int64) error
+}
+
+type PurchaseManager struct {
+	table     string
+	mysqlConn *sql.DB
+}
+
+func NewPurchaseManager(table string, db *sql.DB) IPurchaseRepository {
+	return &PurchaseManager{table: table, mysqlConn: db}
+}
+
+func (p *PurchaseManager) Conn() error {
+	if p.mysqlConn == nil {
+		mysql, err := common.NewMysqlConn()
+		if err != nil {
+			return err
+		}
+		p.mysqlConn = mysql
+	}
+	if p.table == "" {
+		p.table = "purchase_ledger"
+	}
+	return nil
+}
+
+func (p *PurchaseManager) SelectNum(userID int64, productID int64, campaignID int64) (num int64, err error) {
+	if err = p.Conn(); err != nil {
+		return
+	}
+	err = p.mysqlConn.QueryRow("SELECT num FROM "+p.table+" WHERE userID=? AND productID=? AND campaignID=?",
+		userID, productID, campaignID).Scan(&num)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return
+}
+
+func (p *PurchaseManager) AddNumTx(tx *sql.Tx, userID int64, productID int64, campaignID int64, limit int64) (bool, error) {
+	if err := p.Conn(); err != nil {
+		return false, err
+	}
+	if limit <= 0 {
+		_, err := tx.Exec("INSERT INTO "+p.table+" (userID, productID, campaignID, num) VALUES (?, ?, ?, 1) ON DUPLICATE KEY UPDATE num=num+1",
+			userID, productID, campaignID)
+		return err == nil, err
+	}
+	// 插入影响1行，更新影响2行，达到限购数量时不修改，影响0行
+	result, err := tx.Exec("INSERT INTO "+p.table+" (userID, productID, campaignID, num) VALUES (?, ?, ?, 1) ON DUPLICATE KEY UPDATE num=IF(num<?, num+1, num)",
+		userID, productID, campaignID, limit)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (p *PurchaseManager) SubNumTx(tx *sql.Tx, userID int64, productID int64, campaignID int64) error {
+	if err := p.Conn(); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE "+p.table+" SET num=num-1 WHERE userID=? AND productID=? AND campaignID=? AND num>0",
+		userID, productID, campaignID)
+	return err
+}
@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"database/sql"
+	"imoc-product/common"
+)
+
+// 工作单元，把多个仓库的操作放到同一个数据库事务中执行
+type IUnitOfWork interface {
+	Conn() error
+	// fn返回错误时回滚，否则提交
+	Do(fn func(tx *sql.Tx) error) error
+}
+
+type UnitOfWork struct {
+	mysqlConn *sql.DB
+}
+
+func NewUnitOfWork(db *sql.DB) IUnitOfWork {
+	return &UnitOfWork{mysqlConn: db}
+}
+
+func (u *UnitOfWork) Conn() error {
+	if u.mysqlConn == nil {
+		mysql, err := common.NewMysqlConn()
+		if err != nil {
+			return err
+		}
+		u.mysqlConn = mysql
+	}
+	return nil
+}
+
+func (u *UnitOfWork) Do(fn func(tx *sql.Tx) error) error {
+	if err := u.Conn(); err != nil {
+		return err
+	}
+	tx, err := u.mysqlConn.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
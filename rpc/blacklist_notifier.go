@@ -0,0 +1,47 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"imoc-product/datamodels"
+	"time"
+)
+
+// 通过RPC把黑名单变化发送给验证集群，依次尝试节点直到成功
+// 任意验证节点都可以接收，由接收的节点按hash环转发给用户所属的节点
+type BlacklistNotifier struct {
+	client  *ValidatorClient
+	hosts   []string
+	timeout time.Duration
+}
+
+func NewBlacklistNotifier(pool *Pool, hosts []string, timeout time.Duration) *BlacklistNotifier {
+	return &BlacklistNotifier{client: NewValidatorClient(pool), hosts: hosts, timeout: timeout}
+}
+
+func (n *BlacklistNotifier) NotifyBlacklist(blacklist *datamodels.Blacklist, removed bool) error {
+	request := NewBlacklistRequest(blacklist, removed)
+	err := errors.New("没有可用的验证节点")
+	for _, host := range n.hosts {
+		ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+		var reason Reason
+		reason, err = n.client.SetBlacklist(ctx, host, request)
+		cancel()
+		if err == nil && reason != ReasonOK {
+			err = errors.New("验证节点返回：" + reason.String())
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// 黑名单变化的请求
+func NewBlacklistRequest(blacklist *datamodels.Blacklist, removed bool) *BlacklistRequest {
+	request := &BlacklistRequest{UID: blacklist.UserID, Reason: blacklist.Reason, Remove: removed}
+	if !blacklist.ExpireTime.IsZero() {
+		request.Expire = blacklist.ExpireTime.UnixNano()
+	}
+	return request
+}
@@ -0,0 +1,220 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrClosed = errors.New("RPC连接已关闭")
+
+// RPC客户端，一个连接上同时发送多个请求，按请求ID匹配响应
+type Client struct {
+	conn    net.Conn
+	nextID  uint64
+	pending map[uint64]chan *frame
+	// 连接断开的原因，断开后所有调用直接返回该错误
+	err       error
+	writeLock sync.Mutex
+	sync.Mutex
+}
+
+// 连接节点并握手
+func Dial(ctx context.Context, addr string, node string, secret string) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte("CONNECT " + Path + " HTTP/1.0\n\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if response.Status != connected {
+		conn.Close()
+		return nil, errors.New("RPC连接失败：" + response.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &Client{conn: conn, pending: make(map[uint64]chan *frame)}
+	go c.readLoop(reader)
+	hello := &HelloRequest{Version: Version, Node: node, Time: time.Now().Unix()}
+	signHello(hello, secret)
+	if err := c.Call(ctx, MethodHello, hello, &HelloResponse{}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// 调用远程方法，ctx的截止时间会传递给服务端
+func (c *Client) Call(ctx context.Context, method Method, request Message, response Message) error {
+	ch := make(chan *frame, 1)
+	c.Lock()
+	if c.err != nil {
+		c.Unlock()
+		return c.err
+	}
+	id := atomic.AddUint64(&c.nextID, 1)
+	c.pending[id] = ch
+	c.Unlock()
+	defer func() {
+		c.Lock()
+		delete(c.pending, id)
+		c.Unlock()
+	}()
+
+	e := &Encoder{}
+	request.Marshal(e)
+	f := &frame{version: Version, kind: kindRequest, method: method, id: id, body: e.Bytes()}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+		// 向上取整，不足1毫秒时不能变成0，0表示没有超时
+		f.timeout = uint32((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+	if err := c.write(ctx, f); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case result, ok := <-ch:
+		if !ok {
+			return c.Err()
+		}
+		if result.kind == kindError {
+			return &RemoteError{Message: string(result.body)}
+		}
+		d := NewDecoder(result.body)
+		response.Unmarshal(d)
+		return d.Err()
+	}
+}
+
+func (c *Client) write(ctx context.Context, f *frame) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	if err := writeFrame(c.conn, f); err != nil {
+		// 写入一半的帧会破坏后续的数据，直接断开连接
+		c.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) readLoop(reader *bufio.Reader) {
+	for {
+		f, err := readFrame(reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.Lock()
+		ch, ok := c.pending[f.id]
+		delete(c.pending, f.id)
+		c.Unlock()
+		if ok {
+			ch <- f
+		}
+	}
+}
+
+// 断开连接，所有等待中的调用返回错误
+func (c *Client) fail(err error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.conn.Close()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// 连接断开的原因，连接正常时返回nil
+func (c *Client) Err() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.err
+}
+
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return nil
+}
+
+// 按节点复用的客户端连接池，连接断开后下次调用时重新连接
+type Pool struct {
+	node    string
+	secret  string
+	clients map[string]*Client
+	sync.Mutex
+}
+
+func NewPool(node string, secret string) *Pool {
+	return &Pool{node: node, secret: secret, clients: make(map[string]*Client)}
+}
+
+func (p *Pool) client(ctx context.Context, addr string) (*Client, error) {
+	p.Lock()
+	c, ok := p.clients[addr]
+	p.Unlock()
+	if ok && c.Err() == nil {
+		return c, nil
+	}
+	c, err := Dial(ctx, addr, p.node, p.secret)
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	// 并发建立的多余连接直接关闭
+	if exist, ok := p.clients[addr]; ok && exist.Err() == nil {
+		c.Close()
+		return exist, nil
+	}
+	p.clients[addr] = c
+	return c, nil
+}
+
+// 调用节点上的方法
+func (p *Pool) Call(ctx context.Context, addr string, method Method, request Message, response Message) error {
+	c, err := p.client(ctx, addr)
+	if err != nil {
+		return err
+	}
+	return c.Call(ctx, method, request, response)
+}
+
+// 关闭所有连接
+func (p *Pool) Close() {
+	p.Lock()
+	defer p.Unlock()
+	for addr, c := range p.clients {
+		c.Close()
+		delete(p.clients, addr)
+	}
+}
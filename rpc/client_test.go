@@ -0,0 +1,260 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-rpc-secret-0123456789"
+
+func newTestServer(t *testing.T) (*Server, string) {
+	server := NewServer("server", testSecret)
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return server, ts.Listener.Addr().String()
+}
+
+func dialTest(t *testing.T, addr string) *Client {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := Dial(ctx, addr, "client", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestHandshake(t *testing.T) {
+	_, addr := newTestServer(t)
+	dialTest(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if c, err := Dial(ctx, addr, "client", "wrong-secret-0123456789"); err == nil {
+		c.Close()
+		t.Fatal("密钥错误时握手成功")
+	}
+}
+
+func TestVerifyHello(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name   string
+		time   int64
+		secret string
+		want   bool
+	}{
+		{"有效", now, testSecret, true},
+		{"密钥错误", now, "wrong-secret-0123456789", false},
+		{"过期", now - int64(2*helloMaxAge/time.Second), testSecret, false},
+		{"时间超前", now + int64(2*helloMaxAge/time.Second), testSecret, false},
+	}
+	for _, test := range tests {
+		hello := &HelloRequest{Version: Version, Node: "client", Time: test.time}
+		signHello(hello, test.secret)
+		if got := verifyHello(hello, testSecret); got != test.want {
+			t.Errorf("%s: 得到%v，应为%v", test.name, got, test.want)
+		}
+	}
+	// 签名后修改节点名
+	hello := &HelloRequest{Version: Version, Node: "client", Time: now}
+	signHello(hello, testSecret)
+	hello.Node = "other"
+	if verifyHello(hello, testSecret) {
+		t.Error("篡改后的握手请求通过校验")
+	}
+}
+
+// 同一连接上的并发请求按ID匹配响应，先完成的请求先返回
+func TestCallMultiplexed(t *testing.T) {
+	server, addr := newTestServer(t)
+	release := make(chan struct{})
+	server.Handle(MethodReserve, func(ctx context.Context, d *Decoder) (Message, error) {
+		request := &ProductRequest{}
+		request.Unmarshal(d)
+		if request.ProductID == 0 {
+			<-release
+		}
+		return &ReserveResponse{Reason: ReasonOK, ReservationID: strconv.FormatInt(request.ProductID, 10)}, nil
+	})
+	c := dialTest(t, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 第一个请求阻塞，不影响其余请求返回
+	blocked := make(chan error, 1)
+	go func() {
+		response := &ReserveResponse{}
+		err := c.Call(ctx, MethodReserve, &ProductRequest{ProductID: 0}, response)
+		if err == nil && response.ReservationID != "0" {
+			err = errors.New("响应不匹配：" + response.ReservationID)
+		}
+		blocked <- err
+	}()
+
+	var wg sync.WaitGroup
+	for i := int64(1); i <= 50; i++ {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			response := &ReserveResponse{}
+			if err := c.Call(ctx, MethodReserve, &ProductRequest{ProductID: id}, response); err != nil {
+				t.Error(err)
+				return
+			}
+			if response.ReservationID != strconv.FormatInt(id, 10) {
+				t.Errorf("请求%d得到响应%s", id, response.ReservationID)
+			}
+		}(i)
+	}
+	wg.Wait()
+	select {
+	case err := <-blocked:
+		t.Fatalf("阻塞的请求提前返回：%v", err)
+	default:
+	}
+	close(release)
+	if err := <-blocked; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallRemoteError(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.Handle(MethodCheckRight, func(ctx context.Context, d *Decoder) (Message, error) {
+		return nil, errors.New("处理失败")
+	})
+	c := dialTest(t, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	tests := []struct {
+		method Method
+		want   string
+	}{
+		{MethodCheckRight, "处理失败"},
+		{MethodRelease, ErrUnknownMethod.Error()},
+	}
+	for _, test := range tests {
+		err := c.Call(ctx, test.method, &CheckRightRequest{UID: 1}, &ReasonResponse{})
+		remote, ok := err.(*RemoteError)
+		if !ok || remote.Message != test.want {
+			t.Errorf("方法%d得到%v，应为%s", test.method, err, test.want)
+		}
+	}
+	// 远程错误不影响连接
+	if c.Err() != nil {
+		t.Errorf("远程错误后连接断开：%v", c.Err())
+	}
+}
+
+func TestDispatchNewerVersion(t *testing.T) {
+	server := NewServer("server", testSecret)
+	server.Handle(MethodCheckRight, func(ctx context.Context, d *Decoder) (Message, error) {
+		(&CheckRightRequest{}).Unmarshal(d)
+		return &ReasonResponse{}, nil
+	})
+	result := server.dispatch(context.Background(), &frame{version: Version + 1, kind: kindRequest, method: MethodCheckRight, id: 7})
+	if result.kind != kindError || result.id != 7 || string(result.body) != ErrUnsupportedVersion.Error() {
+		t.Errorf("得到%+v", result)
+	}
+	// 请求体格式错误
+	result = server.dispatch(context.Background(), &frame{version: Version, kind: kindRequest, method: MethodCheckRight, id: 8, body: []byte{0x80}})
+	if result.kind != kindError || string(result.body) != ErrMalformed.Error() {
+		t.Errorf("得到%+v", result)
+	}
+}
+
+// 客户端的截止时间传递给服务端的处理函数
+func TestCallDeadline(t *testing.T) {
+	server, addr := newTestServer(t)
+	deadlines := make(chan time.Duration, 1)
+	server.Handle(MethodCheckRight, func(ctx context.Context, d *Decoder) (Message, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadlines <- 0
+			return &ReasonResponse{}, nil
+		}
+		deadlines <- time.Until(deadline)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	c := dialTest(t, addr)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := c.Call(ctx, MethodCheckRight, &CheckRightRequest{UID: 1}, &ReasonResponse{}); err != context.DeadlineExceeded {
+		t.Errorf("得到%v，应为超时", err)
+	}
+	if remain := <-deadlines; remain <= 0 || remain > 201*time.Millisecond {
+		t.Errorf("服务端剩余时间%v", remain)
+	}
+	// 已超时的调用不发送请求
+	<-ctx.Done()
+	if err := c.Call(ctx, MethodCheckRight, &CheckRightRequest{UID: 1}, &ReasonResponse{}); err != context.DeadlineExceeded {
+		t.Errorf("得到%v，应为超时", err)
+	}
+	if c.Err() != nil {
+		t.Errorf("超时后连接断开：%v", c.Err())
+	}
+}
+
+// 关闭客户端后等待中的调用返回，服务端正在处理的请求被取消
+func TestCloseCancelsCalls(t *testing.T) {
+	server, addr := newTestServer(t)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	server.Handle(MethodCheckRight, func(ctx context.Context, d *Decoder) (Message, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+	c := dialTest(t, addr)
+	result := make(chan error, 1)
+	go func() {
+		result <- c.Call(context.Background(), MethodCheckRight, &CheckRightRequest{UID: 1}, &ReasonResponse{})
+	}()
+	<-started
+	c.Close()
+	select {
+	case err := <-result:
+		if err != ErrClosed {
+			t.Errorf("得到%v，应为ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("关闭后调用没有返回")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("连接断开后服务端请求没有取消")
+	}
+	if err := c.Call(context.Background(), MethodCheckRight, &CheckRightRequest{UID: 1}, &ReasonResponse{}); err != ErrClosed {
+		t.Errorf("关闭后调用得到%v", err)
+	}
+}
+
+func TestPoolRedial(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.Handle(MethodCheckRight, func(ctx context.Context, d *Decoder) (Message, error) {
+		return &ReasonResponse{Reason: ReasonOK}, nil
+	})
+	pool := NewPool("client", testSecret)
+	defer pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := pool.Call(ctx, addr, MethodCheckRight, &CheckRightRequest{UID: 1}, &ReasonResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	// 连接断开后重新连接
+	pool.clients[addr].Close()
+	if err := pool.Call(ctx, addr, MethodCheckRight, &CheckRightRequest{UID: 1}, &ReasonResponse{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package rpc
+
+// RPC消息，字段顺序即编码顺序，新增字段只能追加在末尾
+type Message interface {
+	Marshal(e *Encoder)
+	Unmarshal(d *Decoder)
+}
+
+// 握手请求，客户端建立连接后首先发送，使用共享密钥签名
+type HelloRequest struct {
+	Version uint64
+	Node    string
+	Time    int64
+	Sign    string
+}
+
+func (m *HelloRequest) Marshal(e *Encoder) {
+	e.Uint(m.Version)
+	e.String(m.Node)
+	e.Int(m.Time)
+	e.String(m.Sign)
+}
+
+func (m *HelloRequest) Unmarshal(d *Decoder) {
+	m.Version = d.Uint()
+	m.Node = d.String()
+	m.Time = d.Int()
+	m.Sign = d.String()
+}
+
+// 握手响应，返回服务端支持的协议版本
+type HelloResponse struct {
+	Version uint64
+	Node    string
+}
+
+func (m *HelloResponse) Marshal(e *Encoder) {
+	e.Uint(m.Version)
+	e.String(m.Node)
+}
+
+func (m *HelloResponse) Unmarshal(d *Decoder) {
+	m.Version = d.Uint()
+	m.Node = d.String()
+}
+
+// 用户访问权限请求
+type CheckRightRequest struct {
+	UID int64
+}
+
+func (m *CheckRightRequest) Marshal(e *Encoder) {
+	e.Int(m.UID)
+}
+
+func (m *CheckRightRequest) Unmarshal(d *Decoder) {
+	m.UID = d.Int()
+}
+
+// 商品请求，用于获取和预留商品
+type ProductRequest struct {
+	ProductID int64
+}
+
+func (m *ProductRequest) Marshal(e *Encoder) {
+	e.Int(m.ProductID)
+}
+
+func (m *ProductRequest) Unmarshal(d *Decoder) {
+	m.ProductID = d.Int()
+}
+
+// 预留请求，用于确认和释放预留
+type ReservationRequest struct {
+	ID string
+}
+
+func (m *ReservationRequest) Marshal(e *Encoder) {
+	e.String(m.ID)
+}
+
+func (m *ReservationRequest) Unmarshal(d *Decoder) {
+	m.ID = d.String()
+}
+
+// 只返回结果原因的响应
+type ReasonResponse struct {
+	Reason Reason
+}
+
+func (m *ReasonResponse) Marshal(e *Encoder) {
+	e.Uint(uint64(m.Reason))
+}
+
+func (m *ReasonResponse) Unmarshal(d *Decoder) {
+	m.Reason = Reason(d.Uint())
+}
+
+// 预留响应，成功时返回预留ID
+type ReserveResponse struct {
+	Reason        Reason
+	ReservationID string
+}
+
+func (m *ReserveResponse) Marshal(e *Encoder) {
+	e.Uint(uint64(m.Reason))
+	e.String(m.ReservationID)
+}
+
+func (m *ReserveResponse) Unmarshal(d *Decoder) {
+	m.Reason = Reason(d.Uint())
+	m.ReservationID = d.String()
+}
+
+// 黑名单变化
+type BlacklistRequest struct {
+	UID    int64
+	Reason string
+	// 过期时间，unix纳秒，0为永久有效
+	Expire int64
+	// 为true时移出黑名单
+	Remove bool
+}
+
+func (m *BlacklistRequest) Marshal(e *Encoder) {
+	e.Int(m.UID)
+	e.String(m.Reason)
+	e.Int(m.Expire)
+	e.Bool(m.Remove)
+}
+
+func (m *BlacklistRequest) Unmarshal(d *Decoder) {
+	m.UID = d.Int()
+	m.Reason = d.String()
+	m.Expire = d.Int()
+	m.Remove = d.Bool()
+}
@@ -0,0 +1,283 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"imoc-product/common"
+	"io"
+)
+
+// 节点间RPC协议
+// 验证节点之间、验证节点与数量控制服务之间使用长连接通信，一个连接上同时处理多个请求。
+// 客户端在原有的HTTP端口上通过CONNECT建立连接并握手，之后双方收发帧：
+//
+//	| 长度 uint32 | 版本 uint8 | 类型 uint8 | 方法 uint8 | 请求ID uint64 | 超时毫秒 uint32 | 消息体 |
+//
+// 长度不包括长度字段本身，整数均为大端序。响应使用请求的ID，客户端按ID匹配响应，因此响应可以乱序返回。
+// 消息体使用紧凑的二进制编码，字段依次写入；新版本只在消息末尾追加字段，
+// 旧版本读取时忽略多余的字段，新版本读取旧消息时缺少的字段为零值。
+
+// 协议版本
+const Version = 1
+
+// 建立RPC连接的HTTP路径
+const Path = "/rpc"
+
+// CONNECT成功的响应状态
+const connected = "200 Connected to imooc RPC"
+
+// 单个帧的最大长度
+const maxFrameSize = 1 << 20
+
+// 帧头长度，不包括长度字段
+const headerSize = 15
+
+var ErrFrameTooLarge = errors.New("RPC帧过大")
+
+var ErrMalformed = errors.New("RPC消息格式错误")
+
+// 帧类型
+type frameKind uint8
+
+const (
+	kindRequest frameKind = iota
+	kindResponse
+	// 服务端处理失败，消息体为错误信息
+	kindError
+)
+
+// 调用的方法
+type Method uint8
+
+const (
+	// 建立连接后的握手
+	MethodHello Method = iota + 1
+	// 验证节点检查用户访问权限
+	MethodCheckRight
+	// 数量控制服务直接获取一件商品
+	MethodGetOne
+	// 数量控制服务预留一件商品
+	MethodReserve
+	// 确认预留
+	MethodConfirm
+	// 释放预留
+	MethodRelease
+	// 修改验证节点上的黑名单，新增的方法只能追加在末尾
+	MethodSetBlacklist
+)
+
+// 调用结果的原因，随响应返回给调用方
+type Reason uint8
+
+const (
+	ReasonOK Reason = iota
+	// 访问过于频繁
+	ReasonRateLimited
+	// 用户在黑名单中
+	ReasonBlacklisted
+	// 商品已售罄
+	ReasonSoldOut
+	// 不在秒杀活动时间内
+	ReasonNotInCampaign
+	// 预留不存在或已过期
+	ReasonNotFound
+	// 请求参数错误
+	ReasonInvalid
+	// 服务端内部错误
+	ReasonInternal
+	// 节点不可用，新增的原因只能追加在末尾
+	ReasonUnavailable
+)
+
+var reasonNames = map[Reason]string{
+	ReasonOK:            "ok",
+	ReasonRateLimited:   "rate-limited",
+	ReasonBlacklisted:   "blacklisted",
+	ReasonSoldOut:       "sold-out",
+	ReasonNotInCampaign: "not-in-campaign",
+	ReasonNotFound:      "not-found",
+	ReasonInvalid:       "invalid",
+	ReasonInternal:      "internal",
+	ReasonUnavailable:   "unavailable",
+}
+
+var reasonCodes = map[Reason]common.Code{
+	ReasonOK:            common.CodeOK,
+	ReasonRateLimited:   common.CodeRateLimited,
+	ReasonBlacklisted:   common.CodeBlacklisted,
+	ReasonSoldOut:       common.CodeSoldOut,
+	ReasonNotInCampaign: common.CodeNotInCampaign,
+	ReasonNotFound:      common.CodeNotFound,
+	ReasonInvalid:       common.CodeInvalidRequest,
+	ReasonInternal:      common.CodeInternal,
+	ReasonUnavailable:   common.CodeUnavailable,
+}
+
+func (r Reason) String() string {
+	if name, ok := reasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("reason(%d)", uint8(r))
+}
+
+// 原因对应的接口错误码
+func (r Reason) Code() common.Code {
+	if code, ok := reasonCodes[r]; ok {
+		return code
+	}
+	return common.CodeInternal
+}
+
+// 服务端返回的错误
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "rpc: " + e.Message
+}
+
+type frame struct {
+	version uint8
+	kind    frameKind
+	method  Method
+	id      uint64
+	// 请求的剩余超时时间，0为不限制
+	timeout uint32
+	body    []byte
+}
+
+func writeFrame(w io.Writer, f *frame) error {
+	if headerSize+len(f.body) > maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	buf := make([]byte, 4+headerSize+len(f.body))
+	binary.BigEndian.PutUint32(buf[0:], uint32(headerSize+len(f.body)))
+	buf[4] = f.version
+	buf[5] = byte(f.kind)
+	buf[6] = byte(f.method)
+	binary.BigEndian.PutUint64(buf[7:], f.id)
+	binary.BigEndian.PutUint32(buf[15:], f.timeout)
+	copy(buf[19:], f.body)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader) (*frame, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(size[:])
+	if length > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	if length < headerSize {
+		return nil, ErrMalformed
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return &frame{
+		version: buf[0],
+		kind:    frameKind(buf[1]),
+		method:  Method(buf[2]),
+		id:      binary.BigEndian.Uint64(buf[3:]),
+		timeout: binary.BigEndian.Uint32(buf[11:]),
+		body:    buf[headerSize:],
+	}, nil
+}
+
+// 消息编码
+type Encoder struct {
+	buf []byte
+}
+
+func (e *Encoder) Uint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *Encoder) Int(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.Uint(1)
+	} else {
+		e.Uint(0)
+	}
+}
+
+func (e *Encoder) String(v string) {
+	e.Uint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+// 消息解码，读到消息末尾后的字段返回零值，消息损坏时记录错误
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+func NewDecoder(buf []byte) *Decoder {
+	return &Decoder{buf: buf}
+}
+
+func (d *Decoder) Uint() uint64 {
+	if d.err != nil || len(d.buf) == 0 {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrMalformed
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *Decoder) Int() int64 {
+	if d.err != nil || len(d.buf) == 0 {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrMalformed
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *Decoder) Bool() bool {
+	return d.Uint() != 0
+}
+
+func (d *Decoder) String() string {
+	length := d.Uint()
+	if d.err != nil {
+		return ""
+	}
+	if uint64(len(d.buf)) < length {
+		d.err = ErrMalformed
+		return ""
+	}
+	v := string(d.buf[:length])
+	d.buf = d.buf[length:]
+	return v
+}
+
+func (d *Decoder) Err() error {
+	return d.err
+}
@@ -0,0 +1,155 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []*frame{
+		{version: Version, kind: kindRequest, method: MethodCheckRight, id: 1, timeout: 500, body: []byte{1, 2, 3}},
+		{version: Version, kind: kindResponse, method: MethodReserve, id: math.MaxUint64, body: nil},
+		{version: Version, kind: kindError, method: MethodRelease, id: 42, body: []byte("失败")},
+	}
+	var buf bytes.Buffer
+	for _, f := range frames {
+		if err := writeFrame(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range frames {
+		got, err := readFrame(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.version != want.version || got.kind != want.kind || got.method != want.method ||
+			got.id != want.id || got.timeout != want.timeout || !bytes.Equal(got.body, want.body) {
+			t.Errorf("得到%+v，应为%+v", got, want)
+		}
+	}
+	if _, err := readFrame(&buf); err != io.EOF {
+		t.Errorf("读完后得到%v，应为EOF", err)
+	}
+}
+
+func TestFrameLimits(t *testing.T) {
+	// 写入过大的帧
+	if err := writeFrame(io.Discard, &frame{body: make([]byte, maxFrameSize)}); err != ErrFrameTooLarge {
+		t.Errorf("写入过大的帧得到%v", err)
+	}
+	// 长度字段超过上限时不分配内存
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], maxFrameSize+1)
+	if _, err := readFrame(bytes.NewReader(size[:])); err != ErrFrameTooLarge {
+		t.Errorf("读取过大的帧得到%v", err)
+	}
+	// 长度不足帧头
+	binary.BigEndian.PutUint32(size[:], headerSize-1)
+	if _, err := readFrame(bytes.NewReader(append(size[:], make([]byte, headerSize-1)...))); err != ErrMalformed {
+		t.Errorf("读取过短的帧得到%v", err)
+	}
+	// 帧不完整
+	var buf bytes.Buffer
+	writeFrame(&buf, &frame{id: 1, body: []byte("hello")})
+	if _, err := readFrame(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("读取不完整的帧得到%v", err)
+	}
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	e := &Encoder{}
+	e.Uint(0)
+	e.Uint(math.MaxUint64)
+	e.Int(-1)
+	e.Int(math.MinInt64)
+	e.Bool(true)
+	e.Bool(false)
+	e.String("")
+	e.String("用户")
+
+	d := NewDecoder(e.Bytes())
+	if v := d.Uint(); v != 0 {
+		t.Errorf("Uint得到%d", v)
+	}
+	if v := d.Uint(); v != math.MaxUint64 {
+		t.Errorf("Uint得到%d", v)
+	}
+	if v := d.Int(); v != -1 {
+		t.Errorf("Int得到%d", v)
+	}
+	if v := d.Int(); v != math.MinInt64 {
+		t.Errorf("Int得到%d", v)
+	}
+	if !d.Bool() || d.Bool() {
+		t.Error("Bool解码错误")
+	}
+	if v := d.String(); v != "" {
+		t.Errorf("String得到%q", v)
+	}
+	if v := d.String(); v != "用户" {
+		t.Errorf("String得到%q", v)
+	}
+	// 读到末尾后的字段为零值，不是错误
+	if d.Uint() != 0 || d.Int() != 0 || d.String() != "" || d.Err() != nil {
+		t.Errorf("读到末尾后得到错误%v", d.Err())
+	}
+}
+
+// 新版本在消息末尾追加字段，旧版本忽略多余的字段，新版本读取旧消息时缺少的字段为零值
+func TestMessageCompatibility(t *testing.T) {
+	e := &Encoder{}
+	(&HelloRequest{Version: 2, Node: "a", Time: 100, Sign: "s"}).Marshal(e)
+	e.String("新增字段")
+	hello := &HelloRequest{}
+	d := NewDecoder(e.Bytes())
+	hello.Unmarshal(d)
+	if d.Err() != nil || hello.Node != "a" || hello.Time != 100 || hello.Sign != "s" {
+		t.Errorf("读取带有新字段的消息得到%+v，错误%v", hello, d.Err())
+	}
+
+	e = &Encoder{}
+	e.Uint(1)
+	e.String("a")
+	hello = &HelloRequest{}
+	d = NewDecoder(e.Bytes())
+	hello.Unmarshal(d)
+	if d.Err() != nil || hello.Node != "a" || hello.Time != 0 || hello.Sign != "" {
+		t.Errorf("读取缺少字段的消息得到%+v，错误%v", hello, d.Err())
+	}
+}
+
+func TestDecoderMalformed(t *testing.T) {
+	// 字符串长度超过剩余数据
+	e := &Encoder{}
+	e.Uint(10)
+	d := NewDecoder(append(e.Bytes(), "abc"...))
+	if d.String() != "" || d.Err() != ErrMalformed {
+		t.Errorf("得到%v，应为ErrMalformed", d.Err())
+	}
+	// 出错后不再读取
+	if d.Uint() != 0 || d.Err() != ErrMalformed {
+		t.Error("出错后继续读取")
+	}
+	// 不完整的varint
+	d = NewDecoder([]byte{0x80})
+	if d.Uint() != 0 || d.Err() != ErrMalformed {
+		t.Errorf("得到%v，应为ErrMalformed", d.Err())
+	}
+}
+
+func TestReasonCode(t *testing.T) {
+	for reason := ReasonOK; reason <= ReasonUnavailable; reason++ {
+		if _, ok := reasonNames[reason]; !ok {
+			t.Errorf("原因%d没有名称", reason)
+		}
+		if _, ok := reasonCodes[reason]; !ok {
+			t.Errorf("原因%s没有错误码", reason)
+		}
+	}
+	if Reason(200).String() != "reason(200)" {
+		t.Errorf("未知原因得到%s", Reason(200))
+	}
+}
@@ -0,0 +1,196 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"imoc-product/common"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 节点间握手使用的共享密钥，所有节点必须一致，启动时从环境变量RPC_SECRET读取
+var Secret string
+
+// 握手请求的有效期，超过后视为重放
+var helloMaxAge = time.Minute
+
+// 握手超时时间
+var helloTimeout = 5 * time.Second
+
+var ErrUnknownMethod = errors.New("未知的RPC方法")
+
+var ErrUnsupportedVersion = errors.New("不支持的RPC协议版本")
+
+var ErrHandshake = errors.New("RPC握手失败")
+
+// 方法处理函数，从d中读取请求，返回响应消息
+type HandlerFunc func(ctx context.Context, d *Decoder) (Message, error)
+
+// RPC服务端，挂载在HTTP服务的Path路径上
+type Server struct {
+	node     string
+	secret   string
+	handlers map[Method]HandlerFunc
+	sync.RWMutex
+}
+
+func NewServer(node string, secret string) *Server {
+	return &Server{node: node, secret: secret, handlers: make(map[Method]HandlerFunc)}
+}
+
+// 注册方法
+func (s *Server) Handle(method Method, handler HandlerFunc) {
+	s.Lock()
+	defer s.Unlock()
+	s.handlers[method] = handler
+}
+
+// 处理CONNECT请求，接管连接后按RPC协议通信
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("405 must CONNECT\n"))
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "不支持接管连接", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		log.Println("rpc hijack:", err)
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.0 " + connected + "\n\n")); err != nil {
+		conn.Close()
+		return
+	}
+	s.ServeConn(conn, buf.Reader)
+}
+
+// 处理一个连接，每个请求在单独的goroutine中处理，响应按完成顺序写回
+func (s *Server) ServeConn(conn net.Conn, reader *bufio.Reader) {
+	defer conn.Close()
+	var writeLock sync.Mutex
+	write := func(f *frame) {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		if err := writeFrame(conn, f); err != nil {
+			log.Println("rpc write:", err)
+			conn.Close()
+		}
+	}
+	if err := s.handshake(conn, reader, write); err != nil {
+		log.Println("rpc:", conn.RemoteAddr(), err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	// 连接断开时先取消正在处理的请求，再等待它们退出
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	for {
+		f, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+		if f.kind != kindRequest {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			write(s.dispatch(ctx, f))
+		}()
+	}
+}
+
+// 握手，校验客户端签名
+func (s *Server) handshake(conn net.Conn, reader *bufio.Reader, write func(f *frame)) error {
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	f, err := readFrame(reader)
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if f.kind != kindRequest || f.method != MethodHello {
+		return ErrHandshake
+	}
+	hello := &HelloRequest{}
+	d := NewDecoder(f.body)
+	hello.Unmarshal(d)
+	if d.Err() != nil || !verifyHello(hello, s.secret) {
+		write(errorFrame(f, ErrHandshake))
+		return ErrHandshake
+	}
+	e := &Encoder{}
+	(&HelloResponse{Version: Version, Node: s.node}).Marshal(e)
+	write(&frame{version: Version, kind: kindResponse, method: f.method, id: f.id, body: e.Bytes()})
+	return nil
+}
+
+func (s *Server) dispatch(ctx context.Context, f *frame) *frame {
+	if f.version > Version {
+		return errorFrame(f, ErrUnsupportedVersion)
+	}
+	s.RLock()
+	handler, ok := s.handlers[f.method]
+	s.RUnlock()
+	if !ok {
+		return errorFrame(f, ErrUnknownMethod)
+	}
+	// 使用调用方剩余的超时时间
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(f.timeout)*time.Millisecond)
+		defer cancel()
+	}
+	d := NewDecoder(f.body)
+	response, err := handler(ctx, d)
+	if err == nil {
+		err = d.Err()
+	}
+	if err != nil {
+		return errorFrame(f, err)
+	}
+	e := &Encoder{}
+	response.Marshal(e)
+	return &frame{version: Version, kind: kindResponse, method: f.method, id: f.id, body: e.Bytes()}
+}
+
+func errorFrame(f *frame, err error) *frame {
+	return &frame{version: Version, kind: kindError, method: f.method, id: f.id, body: []byte(err.Error())}
+}
+
+func helloParams(hello *HelloRequest) url.Values {
+	return url.Values{
+		"version": {strconv.FormatUint(hello.Version, 10)},
+		"node":    {hello.Node},
+		"time":    {strconv.FormatInt(hello.Time, 10)},
+	}
+}
+
+func signHello(hello *HelloRequest, secret string) {
+	hello.Sign = common.SignParams(helloParams(hello), secret)
+}
+
+func verifyHello(hello *HelloRequest, secret string) bool {
+	age := time.Since(time.Unix(hello.Time, 0))
+	if age > helloMaxAge || age < -helloMaxAge {
+		return false
+	}
+	params := helloParams(hello)
+	params.Set(common.SignKey, hello.Sign)
+	return common.VerifyParams(params, secret)
+}
@@ -0,0 +1,121 @@
+package rpc
+
+import "context"
+
+// 验证节点提供的服务
+type IValidatorService interface {
+	// 检查用户是否可以访问，返回不允许的原因
+	CheckRight(ctx context.Context, uid int64) (Reason, error)
+	// 修改黑名单，由接收的节点按hash环转发给用户所属的节点
+	SetBlacklist(ctx context.Context, request *BlacklistRequest) (Reason, error)
+}
+
+// 注册验证节点的方法
+func RegisterValidator(s *Server, service IValidatorService) {
+	s.Handle(MethodCheckRight, func(ctx context.Context, d *Decoder) (Message, error) {
+		request := &CheckRightRequest{}
+		request.Unmarshal(d)
+		reason, err := service.CheckRight(ctx, request.UID)
+		return &ReasonResponse{Reason: reason}, err
+	})
+	s.Handle(MethodSetBlacklist, func(ctx context.Context, d *Decoder) (Message, error) {
+		request := &BlacklistRequest{}
+		request.Unmarshal(d)
+		reason, err := service.SetBlacklist(ctx, request)
+		return &ReasonResponse{Reason: reason}, err
+	})
+}
+
+// 验证节点客户端
+type ValidatorClient struct {
+	pool *Pool
+}
+
+func NewValidatorClient(pool *Pool) *ValidatorClient {
+	return &ValidatorClient{pool: pool}
+}
+
+func (c *ValidatorClient) CheckRight(ctx context.Context, addr string, uid int64) (Reason, error) {
+	response := &ReasonResponse{}
+	err := c.pool.Call(ctx, addr, MethodCheckRight, &CheckRightRequest{UID: uid}, response)
+	return response.Reason, err
+}
+
+func (c *ValidatorClient) SetBlacklist(ctx context.Context, addr string, request *BlacklistRequest) (Reason, error) {
+	response := &ReasonResponse{}
+	err := c.pool.Call(ctx, addr, MethodSetBlacklist, request, response)
+	return response.Reason, err
+}
+
+// 数量控制服务提供的服务
+type IStockService interface {
+	// 获取一件商品
+	GetOne(ctx context.Context, productID int64) (Reason, error)
+	// 预留一件商品，成功时返回预留ID
+	Reserve(ctx context.Context, productID int64) (string, Reason, error)
+	// 确认预留
+	Confirm(ctx context.Context, id string) (Reason, error)
+	// 释放预留
+	Release(ctx context.Context, id string) (Reason, error)
+}
+
+// 注册数量控制服务的方法
+func RegisterStock(s *Server, service IStockService) {
+	s.Handle(MethodGetOne, func(ctx context.Context, d *Decoder) (Message, error) {
+		request := &ProductRequest{}
+		request.Unmarshal(d)
+		reason, err := service.GetOne(ctx, request.ProductID)
+		return &ReasonResponse{Reason: reason}, err
+	})
+	s.Handle(MethodReserve, func(ctx context.Context, d *Decoder) (Message, error) {
+		request := &ProductRequest{}
+		request.Unmarshal(d)
+		id, reason, err := service.Reserve(ctx, request.ProductID)
+		return &ReserveResponse{Reason: reason, ReservationID: id}, err
+	})
+	s.Handle(MethodConfirm, func(ctx context.Context, d *Decoder) (Message, error) {
+		request := &ReservationRequest{}
+		request.Unmarshal(d)
+		reason, err := service.Confirm(ctx, request.ID)
+		return &ReasonResponse{Reason: reason}, err
+	})
+	s.Handle(MethodRelease, func(ctx context.Context, d *Decoder) (Message, error) {
+		request := &ReservationRequest{}
+		request.Unmarshal(d)
+		reason, err := service.Release(ctx, request.ID)
+		return &ReasonResponse{Reason: reason}, err
+	})
+}
+
+// 数量控制服务客户端
+type StockClient struct {
+	pool *Pool
+}
+
+func NewStockClient(pool *Pool) *StockClient {
+	return &StockClient{pool: pool}
+}
+
+func (c *StockClient) GetOne(ctx context.Context, addr string, productID int64) (Reason, error) {
+	response := &ReasonResponse{}
+	err := c.pool.Call(ctx, addr, MethodGetOne, &ProductRequest{ProductID: productID}, response)
+	return response.Reason, err
+}
+
+func (c *StockClient) Reserve(ctx context.Context, addr string, productID int64) (string, Reason, error) {
+	response := &ReserveResponse{}
+	err := c.pool.Call(ctx, addr, MethodReserve, &ProductRequest{ProductID: productID}, response)
+	return response.ReservationID, response.Reason, err
+}
+
+func (c *StockClient) Confirm(ctx context.Context, addr string, id string) (Reason, error) {
+	response := &ReasonResponse{}
+	err := c.pool.Call(ctx, addr, MethodConfirm, &ReservationRequest{ID: id}, response)
+	return response.Reason, err
+}
+
+func (c *StockClient) Release(ctx context.Context, addr string, id string) (Reason, error) {
+	response := &ReasonResponse{}
+	err := c.pool.Call(ctx, addr, MethodRelease, &ReservationRequest{ID: id}, response)
+	return response.Reason, err
+}
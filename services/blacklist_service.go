@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"imoc-product/datamodels"
+	"imoc-product/repositories"
+	"log"
+	"time"
+)
+
+var ErrBlacklistInvalid = errors.New("黑名单参数错误！")
+
+// 把黑名单变化通知给验证集群，由验证节点按hash环转发给用户所属的节点
+type IBlacklistNotifier interface {
+	NotifyBlacklist(blacklist *datamodels.Blacklist, removed bool) error
+}
+
+// 黑名单以数据库为准，验证节点定期从数据库同步，变化时立即通知验证节点
+type IBlacklistService interface {
+	// 添加黑名单，ttl<=0为永久有效
+	Add(userID int64, reason string, ttl time.Duration, operator string) (*datamodels.Blacklist, error)
+	// 移出黑名单，用户不在黑名单中时返回false
+	Remove(userID int64, operator string) (bool, error)
+	GetAll() ([]*datamodels.Blacklist, error)
+	// 当前有效的黑名单
+	GetActive() ([]*datamodels.Blacklist, error)
+	// 记录IP封禁，IP封禁只在验证节点内存中生效，这里只写审计记录
+	AuditBanIP(ip string, detail string, operator string) error
+	// 最近的审计记录
+	GetAudits(limit int) ([]*datamodels.BlacklistAudit, error)
+	GetAuditsByUserID(userID int64) ([]*datamodels.BlacklistAudit, error)
+}
+
+type BlacklistService struct {
+	blacklistRepository repositories.IBlacklistRepository
+	auditRepository     repositories.IBlacklistAuditRepository
+	// 为nil时只写数据库，验证节点在下次同步时生效
+	notifier IBlacklistNotifier
+}
+
+func NewBlacklistService(repository repositories.IBlacklistRepository, auditRepository repositories.IBlacklistAuditRepository,
+	notifier IBlacklistNotifier) IBlacklistService {
+	return &BlacklistService{blacklistRepository: repository, auditRepository: auditRepository, notifier: notifier}
+}
+
+func (b *BlacklistService) Add(userID int64, reason string, ttl time.Duration, operator string) (*datamodels.Blacklist, error) {
+	if userID <= 0 {
+		return nil, ErrBlacklistInvalid
+	}
+	now := time.Now()
+	blacklist := &datamodels.Blacklist{
+		UserID:     userID,
+		Reason:     reason,
+		Operator:   operator,
+		CreateTime: now,
+	}
+	if ttl > 0 {
+		// 数据库只保存到秒
+		blacklist.ExpireTime = now.Add(ttl).Truncate(time.Second)
+	}
+	if err := b.blacklistRepository.Upsert(blacklist); err != nil {
+		return nil, err
+	}
+	b.notify(blacklist, false)
+	b.audit(&datamodels.BlacklistAudit{UserID: userID, Action: datamodels.AuditActionAdd, Detail: reason, Operator: operator})
+	return blacklist, nil
+}
+
+func (b *BlacklistService) Remove(userID int64, operator string) (bool, error) {
+	removed, err := b.blacklistRepository.Delete(userID)
+	if err != nil || !removed {
+		return removed, err
+	}
+	b.notify(&datamodels.Blacklist{UserID: userID}, true)
+	b.audit(&datamodels.BlacklistAudit{UserID: userID, Action: datamodels.AuditActionRemove, Operator: operator})
+	return true, nil
+}
+
+func (b *BlacklistService) AuditBanIP(ip string, detail string, operator string) error {
+	if ip == "" {
+		return ErrBlacklistInvalid
+	}
+	audit := &datamodels.BlacklistAudit{IP: ip, Action: datamodels.AuditActionBanIP, Detail: detail,
+		Operator: operator, CreateTime: time.Now()}
+	_, err := b.auditRepository.Insert(audit)
+	return err
+}
+
+// 黑名单已经生效，审计记录写入失败只记录日志
+func (b *BlacklistService) audit(audit *datamodels.BlacklistAudit) {
+	audit.CreateTime = time.Now()
+	if _, err := b.auditRepository.Insert(audit); err != nil {
+		log.Println("写入黑名单审计记录失败：", audit.UserID, audit.Action, audit.Detail, err)
+	}
+}
+
+// 通知失败不影响结果，验证节点定期同步时会修正
+func (b *BlacklistService) notify(blacklist *datamodels.Blacklist, removed bool) {
+	if b.notifier == nil {
+		return
+	}
+	if err := b.notifier.NotifyBlacklist(blacklist, removed); err != nil {
+		log.Println("通知验证节点黑名单变化失败，等待定期同步：", blacklist.UserID, err)
+	}
+}
+
+func (b *BlacklistService) GetAll() ([]*datamodels.Blacklist, error) {
+	return b.blacklistRepository.SelectAll()
+}
+
+func (b *BlacklistService) GetActive() ([]*datamodels.Blacklist, error) {
+	return b.blacklistRepository.SelectActive(time.Now())
+}
+
+func (b *BlacklistService) GetAudits(limit int) ([]*datamodels.BlacklistAudit, error) {
+	return b.auditRepository.SelectRecent(limit)
+}
+
+func (b *BlacklistService) GetAuditsByUserID(userID int64) ([]*datamodels.BlacklistAudit, error) {
+	return b.auditRepository.SelectByUserID(userID)
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"imoc-product/datamodels"
+	"imoc-product/repositories"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCampaignNotFound   = errors.New("商品没有秒杀活动！")
+	ErrCampaignNotStarted = errors.New("秒杀活动还未开始！")
+	ErrCampaignEnded      = errors.New("秒杀活动已结束！")
+	ErrCampaignInvalid    = errors.New("活动时间、库存或限购数量不合法！")
+)
+
+// 活动缓存的刷新间隔，秒杀入口按缓存判断活动时间，避免每次请求都查询数据库
+var campaignRefresh = 5 * time.Second
+
+// 已结束的活动在缓存中保留的时间，消费端处理积压消息时仍能取到活动的限购数量
+var campaignKeep = time.Hour
+
+type ICampaignService interface {
+	GetCampaignByID(int64) (*datamodels.Campaign, error)
+	GetAllCampaign() ([]*datamodels.Campaign, error)
+	DeleteCampaignByID(int64) bool
+	InsertCampaign(*datamodels.Campaign) (int64, error)
+	UpdateCampaign(*datamodels.Campaign) error
+	// 检查商品在t时刻是否处于活动时间内
+	// 返回进行中的活动，不在活动时间内时返回最近的活动和对应的错误
+	// 商品只在秒杀活动中售卖，没有活动的商品返回ErrCampaignNotFound，调用方按不可售卖处理
+	CheckWindow(productID int64, t time.Time) (*datamodels.Campaign, error)
+}
+
+type CampaignService struct {
+	campaignRepository repositories.ICampaignRepository
+	// 商品ID到活动的缓存
+	campaigns map[int64][]*datamodels.Campaign
+	loadedAt  time.Time
+	sync.Mutex
+}
+
+func NewCampaignService(repository repositories.ICampaignRepository) ICampaignService {
+	return &CampaignService{campaignRepository: repository}
+}
+
+func (c *CampaignService) GetCampaignByID(campaignID int64) (*datamodels.Campaign, error) {
+	return c.campaignRepository.SelectByKey(campaignID)
+}
+
+func (c *CampaignService) GetAllCampaign() ([]*datamodels.Campaign, error) {
+	return c.campaignRepository.SelectAll()
+}
+
+func (c *CampaignService) DeleteCampaignByID(campaignID int64) bool {
+	defer c.invalidate()
+	return c.campaignRepository.Delete(campaignID)
+}
+
+func (c *CampaignService) InsertCampaign(campaign *datamodels.Campaign) (int64, error) {
+	if err := validateCampaign(campaign); err != nil {
+		return 0, err
+	}
+	defer c.invalidate()
+	return c.campaignRepository.Insert(campaign)
+}
+
+func (c *CampaignService) UpdateCampaign(campaign *datamodels.Campaign) error {
+	if err := validateCampaign(campaign); err != nil {
+		return err
+	}
+	defer c.invalidate()
+	return c.campaignRepository.Update(campaign)
+}
+
+func validateCampaign(campaign *datamodels.Campaign) error {
+	if campaign.ProductID <= 0 || campaign.Stock < 0 || campaign.UserLimit < 0 || campaign.SeckillPrice < 0 {
+		return ErrCampaignInvalid
+	}
+	if campaign.StartTime.IsZero() || !campaign.EndTime.After(campaign.StartTime) {
+		return ErrCampaignInvalid
+	}
+	return nil
+}
+
+func (c *CampaignService) CheckWindow(productID int64, t time.Time) (*datamodels.Campaign, error) {
+	campaigns, err := c.productCampaigns(productID)
+	if err != nil {
+		return nil, err
+	}
+	var upcoming, ended *datamodels.Campaign
+	for _, campaign := range campaigns {
+		switch {
+		case campaign.Active(t):
+			return campaign, nil
+		case t.Before(campaign.StartTime):
+			if upcoming == nil || campaign.StartTime.Before(upcoming.StartTime) {
+				upcoming = campaign
+			}
+		default:
+			if ended == nil || campaign.EndTime.After(ended.EndTime) {
+				ended = campaign
+			}
+		}
+	}
+	if upcoming != nil {
+		return upcoming, ErrCampaignNotStarted
+	}
+	if ended != nil {
+		return ended, ErrCampaignEnded
+	}
+	return nil, ErrCampaignNotFound
+}
+
+// 从缓存中取商品的活动，缓存过期时重新加载，加载失败时继续使用旧的缓存
+func (c *CampaignService) productCampaigns(productID int64) ([]*datamodels.Campaign, error) {
+	c.Lock()
+	defer c.Unlock()
+	if time.Since(c.loadedAt) > campaignRefresh {
+		campaignArray, err := c.campaignRepository.SelectEndAfter(time.Now().Add(-campaignKeep))
+		if err != nil {
+			if c.campaigns == nil {
+				return nil, err
+			}
+		} else {
+			campaigns := make(map[int64][]*datamodels.Campaign)
+			for _, campaign := range campaignArray {
+				campaigns[campaign.ProductID] = append(campaigns[campaign.ProductID], campaign)
+			}
+			c.campaigns = campaigns
+		}
+		c.loadedAt = time.Now()
+	}
+	return c.campaigns[productID], nil
+}
+
+func (c *CampaignService) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.loadedAt = time.Time{}
+}
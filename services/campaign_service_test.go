@@ -0,0 +1,89 @@
+package services
+
+import (
+	"imoc-product/datamodels"
+	"imoc-product/repositories"
+	"testing"
+	"time"
+)
+
+// 内存中的活动仓库，只实现活动缓存用到的方法
+type fakeCampaignRepository struct {
+	repositories.ICampaignRepository
+	campaigns []*datamodels.Campaign
+}
+
+func (f *fakeCampaignRepository) SelectEndAfter(t time.Time) ([]*datamodels.Campaign, error) {
+	return f.campaigns, nil
+}
+
+func (f *fakeCampaignRepository) SelectByKey(campaignID int64) (*datamodels.Campaign, error) {
+	for _, campaign := range f.campaigns {
+		if campaign.ID == campaignID {
+			return campaign, nil
+		}
+	}
+	return &datamodels.Campaign{}, nil
+}
+
+func TestCheckWindow(t *testing.T) {
+	now := time.Now()
+	service := NewCampaignService(&fakeCampaignRepository{campaigns: []*datamodels.Campaign{
+		{ID: 1, ProductID: 1, StartTime: now.Add(-time.Hour), EndTime: now.Add(time.Hour)},
+		{ID: 2, ProductID: 2, StartTime: now.Add(time.Hour), EndTime: now.Add(2 * time.Hour)},
+		{ID: 3, ProductID: 3, StartTime: now.Add(-2 * time.Hour), EndTime: now.Add(-time.Hour)},
+	}})
+	tests := []struct {
+		productID  int64
+		campaignID int64
+		err        error
+	}{
+		{1, 1, nil},
+		{2, 2, ErrCampaignNotStarted},
+		{3, 3, ErrCampaignEnded},
+		// 没有活动的商品不售卖
+		{4, 0, ErrCampaignNotFound},
+	}
+	for _, test := range tests {
+		campaign, err := service.CheckWindow(test.productID, now)
+		if err != test.err {
+			t.Errorf("商品%d: 得到%v，应为%v", test.productID, err, test.err)
+		}
+		if (campaign == nil && test.campaignID != 0) || (campaign != nil && campaign.ID != test.campaignID) {
+			t.Errorf("商品%d: 得到活动%+v，应为%d", test.productID, campaign, test.campaignID)
+		}
+	}
+}
+
+// 限购数量只来自活动配置，没有活动时不能购买
+func TestPurchaseLimitFromCampaign(t *testing.T) {
+	now := time.Now()
+	campaignService := NewCampaignService(&fakeCampaignRepository{campaigns: []*datamodels.Campaign{
+		{ID: 1, ProductID: 1, StartTime: now.Add(-time.Hour), EndTime: now.Add(time.Hour), UserLimit: 2},
+		{ID: 2, ProductID: 1, StartTime: now.Add(-3 * time.Hour), EndTime: now.Add(-2 * time.Hour), UserLimit: 5},
+	}})
+	service := &PurchaseService{campaignService: campaignService}
+	tests := []struct {
+		campaignID int64
+		limit      int64
+		err        error
+	}{
+		{1, 2, nil},
+		// 已结束的活动从仓库读取
+		{2, 5, nil},
+		{0, 0, ErrCampaignNotFound},
+		{9, 0, ErrCampaignNotFound},
+	}
+	for _, test := range tests {
+		limit, err := service.limitOf(1, test.campaignID)
+		if limit != test.limit || err != test.err {
+			t.Errorf("活动%d: 得到%d %v，应为%d %v", test.campaignID, limit, err, test.limit, test.err)
+		}
+	}
+	if id := service.CurrentCampaign(1); id != 1 {
+		t.Errorf("当前活动为%d", id)
+	}
+	if id := service.CurrentCampaign(2); id != 0 {
+		t.Errorf("没有活动的商品得到活动%d", id)
+	}
+}
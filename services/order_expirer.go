@@ -0,0 +1,55 @@
+package services
+
+import (
+	"log"
+	"time"
+)
+
+// 每次扫描处理的最大订单数
+var expireBatch = 100
+
+// 定时关闭超时未支付的订单
+type OrderExpirer struct {
+	seckillService ISeckillService
+	// 订单创建后允许支付的时间
+	timeout time.Duration
+	// 扫描间隔
+	interval time.Duration
+	done     chan struct{}
+}
+
+func NewOrderExpirer(seckillService ISeckillService, timeout time.Duration, interval time.Duration) *OrderExpirer {
+	e := &OrderExpirer{seckillService: seckillService, timeout: timeout, interval: interval, done: make(chan struct{})}
+	go e.run()
+	return e
+}
+
+func (e *OrderExpirer) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		}
+		// 一批处理满时继续处理下一批
+		for {
+			expired, err := e.seckillService.ExpireOrders(e.timeout, expireBatch)
+			if err != nil {
+				log.Printf("关闭超时订单失败：%s", err)
+				break
+			}
+			if expired > 0 {
+				log.Printf("关闭超时订单%d个", expired)
+			}
+			if expired < expireBatch {
+				break
+			}
+		}
+	}
+}
+
+func (e *OrderExpirer) Close() {
+	close(e.done)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"imoc-product/datamodels"
+	"imoc-product/repositories"
+	"sync"
+	"time"
+)
+
+// 订单处理结果的保存时间
+var orderResultTTL = 10 * time.Minute
+
+// 订单处理结果查询，保存消费端广播的结果并通知等待中的买家
+type IOrderResultService interface {
+	// 查询处理结果，内存中没有时从订单表查询，仍没有则为排队中
+	GetResult(requestID string) (*datamodels.OrderResult, error)
+	// 保存处理结果并通知订阅者
+	Publish(result *datamodels.OrderResult)
+	// 订阅某个请求的处理结果，使用完后调用cancel
+	Subscribe(requestID string) (results <-chan *datamodels.OrderResult, cancel func())
+}
+
+type OrderResultService struct {
+	orderRepository repositories.IOrderRepository
+	results         map[string]*datamodels.OrderResult
+	subscribers     map[string]map[chan *datamodels.OrderResult]struct{}
+	sync.Mutex
+}
+
+func NewOrderResultService(orderRepository repositories.IOrderRepository) IOrderResultService {
+	o := &OrderResultService{
+		orderRepository: orderRepository,
+		results:         make(map[string]*datamodels.OrderResult),
+		subscribers:     make(map[string]map[chan *datamodels.OrderResult]struct{}),
+	}
+	go o.expire()
+	return o
+}
+
+func (o *OrderResultService) GetResult(requestID string) (*datamodels.OrderResult, error) {
+	o.Lock()
+	result, ok := o.results[requestID]
+	o.Unlock()
+	if ok && result.Done() {
+		return result, nil
+	}
+	// 结果广播可能丢失，以订单表为准
+	order, err := o.orderRepository.SelectByRequestID(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if order.ID == 0 {
+		if ok {
+			return result, nil
+		}
+		return &datamodels.OrderResult{RequestID: requestID, Status: datamodels.ResultQueued}, nil
+	}
+	message := datamodels.NewMessage(order.UserId, order.ProductId, requestID)
+	return datamodels.NewOrderResult(message, order), nil
+}
+
+func (o *OrderResultService) Publish(result *datamodels.OrderResult) {
+	if result.Time == 0 {
+		result.Time = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	o.Lock()
+	defer o.Unlock()
+	// 排队状态不能覆盖最终结果
+	if exist, ok := o.results[result.RequestID]; ok && exist.Done() && !result.Done() {
+		return
+	}
+	o.results[result.RequestID] = result
+	for ch := range o.subscribers[result.RequestID] {
+		// 订阅者来不及接收时丢弃，订阅者可以重新查询
+		select {
+		case ch <- result:
+		default:
+		}
+	}
+}
+
+func (o *OrderResultService) Subscribe(requestID string) (<-chan *datamodels.OrderResult, func()) {
+	ch := make(chan *datamodels.OrderResult, 4)
+	o.Lock()
+	if o.subscribers[requestID] == nil {
+		o.subscribers[requestID] = make(map[chan *datamodels.OrderResult]struct{})
+	}
+	o.subscribers[requestID][ch] = struct{}{}
+	o.Unlock()
+	cancel := func() {
+		o.Lock()
+		defer o.Unlock()
+		delete(o.subscribers[requestID], ch)
+		if len(o.subscribers[requestID]) == 0 {
+			delete(o.subscribers, requestID)
+		}
+	}
+	return ch, cancel
+}
+
+// 定期清理过期的结果
+func (o *OrderResultService) expire() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		deadline := time.Now().Add(-orderResultTTL).UnixNano() / int64(time.Millisecond)
+		o.Lock()
+		for requestID, result := range o.results {
+			if result.Time < deadline {
+				delete(o.results, requestID)
+			}
+		}
+		o.Unlock()
+	}
+}
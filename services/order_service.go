@@ -1,6 +1,8 @@
 package services
 
 import (
+	"database/sql"
+	"errors"
 	"imoc-product/datamodels"
 	"imoc-product/repositories"
 )
@@ -12,9 +14,16 @@ type IOrderService interface {
 	InsertOrder(*datamodels.Order) (int64, error)
 	GetAllOrder() ([]*datamodels.Order, error)
 	GetAllOrderInfo() (map[int]map[string]string, error)
-	InsertOrderByMessage(message *datamodels.Message) (int64, error)
+	// 按状态机变更订单状态
+	TransitOrder(orderID int64, status int64) error
+	// 在事务中按状态机变更订单状态，order为已读取的订单，成功后更新order的状态
+	TransitOrderTx(tx *sql.Tx, order *datamodels.Order, status int64) error
+	// 按状态机变更订单状态并记录支付网关的交易号
+	TransitOrderWithTrade(order *datamodels.Order, status int64, tradeNo string) error
 }
 
+var ErrOrderTransition = errors.New("订单当前状态不允许此操作！")
+
 func NewOrderService(repository repositories.IOrderRepository) IOrderService {
 	return &OrderService{OrderRepository: repository}
 }
@@ -23,15 +32,6 @@ type OrderService struct {
 	OrderRepository repositories.IOrderRepository
 }
 
-func (o *OrderService) InsertOrderByMessage(message *datamodels.Message) (int64, error) {
-	order := &datamodels.Order{
-		UserId:      message.UserID,
-		ProductId:   message.ProductID,
-		OrderStatus: datamodels.OrderSuccess,
-	}
-	return o.OrderRepository.Insert(order)
-}
-
 func (o *OrderService) GetOrderByID(orderID int64) (order *datamodels.Order, err error) {
 	return o.OrderRepository.SelectByKey(orderID)
 }
@@ -41,9 +41,61 @@ func (o *OrderService) DeleteOrderByID(orderID int64) bool {
 }
 
 func (o *OrderService) UpdateOrder(order *datamodels.Order) error {
+	exist, err := o.OrderRepository.SelectByKey(order.ID)
+	if err != nil {
+		return err
+	}
+	if exist.ID != 0 && exist.OrderStatus != order.OrderStatus && !datamodels.CanTransitOrder(exist.OrderStatus, order.OrderStatus) {
+		return ErrOrderTransition
+	}
 	return o.OrderRepository.Update(order)
 }
 
+func (o *OrderService) TransitOrder(orderID int64, status int64) error {
+	order, err := o.OrderRepository.SelectByKey(orderID)
+	if err != nil {
+		return err
+	}
+	if order.ID == 0 {
+		return ErrOrderTransition
+	}
+	return o.transit(order, status, func() (bool, error) {
+		return o.OrderRepository.UpdateStatusFrom(order.ID, order.OrderStatus, status)
+	})
+}
+
+func (o *OrderService) TransitOrderTx(tx *sql.Tx, order *datamodels.Order, status int64) error {
+	return o.transit(order, status, func() (bool, error) {
+		return o.OrderRepository.UpdateStatusFromTx(tx, order.ID, order.OrderStatus, status)
+	})
+}
+
+func (o *OrderService) TransitOrderWithTrade(order *datamodels.Order, status int64, tradeNo string) error {
+	err := o.transit(order, status, func() (bool, error) {
+		return o.OrderRepository.UpdatePaymentFrom(order.ID, order.OrderStatus, status, tradeNo)
+	})
+	if err == nil {
+		order.TradeNo = tradeNo
+	}
+	return err
+}
+
+// 校验状态流转后执行更新，只有状态未被并发修改时才更新成功
+func (o *OrderService) transit(order *datamodels.Order, status int64, update func() (bool, error)) error {
+	if !datamodels.CanTransitOrder(order.OrderStatus, status) {
+		return ErrOrderTransition
+	}
+	ok, err := update()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrOrderTransition
+	}
+	order.OrderStatus = status
+	return nil
+}
+
 func (o *OrderService) InsertOrder(order *datamodels.Order) (int64, error) {
 	return o.OrderRepository.Insert(order)
 }
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"imoc-product/common"
+	"imoc-product/datamodels"
+	"imoc-product/repositories"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 内存中的订单仓库，只实现状态流转用到的方法
+type fakeOrderRepository struct {
+	repositories.IOrderRepository
+	orders map[int64]*datamodels.Order
+}
+
+func newFakeOrderRepository(orders ...*datamodels.Order) *fakeOrderRepository {
+	f := &fakeOrderRepository{orders: make(map[int64]*datamodels.Order)}
+	for _, order := range orders {
+		f.orders[order.ID] = order
+	}
+	return f
+}
+
+func (f *fakeOrderRepository) SelectByKey(orderID int64) (*datamodels.Order, error) {
+	order, ok := f.orders[orderID]
+	if !ok {
+		return &datamodels.Order{}, nil
+	}
+	copied := *order
+	return &copied, nil
+}
+
+func (f *fakeOrderRepository) UpdateStatusFrom(orderID int64, from int64, to int64) (bool, error) {
+	order, ok := f.orders[orderID]
+	if !ok || order.OrderStatus != from {
+		return false, nil
+	}
+	order.OrderStatus = to
+	return true, nil
+}
+
+func (f *fakeOrderRepository) UpdatePaymentFrom(orderID int64, from int64, to int64, tradeNo string) (bool, error) {
+	ok, err := f.UpdateStatusFrom(orderID, from, to)
+	if ok {
+		f.orders[orderID].TradeNo = tradeNo
+	}
+	return ok, err
+}
+
+func (f *fakeOrderRepository) status(orderID int64) int64 {
+	return f.orders[orderID].OrderStatus
+}
+
+func TestTransitOrder(t *testing.T) {
+	repository := newFakeOrderRepository(&datamodels.Order{ID: 1, OrderStatus: datamodels.OrderAwaitingPayment})
+	service := NewOrderService(repository)
+
+	// 待支付 -> 过期
+	if err := service.TransitOrder(1, datamodels.OrderExpired); err != nil {
+		t.Fatal(err)
+	}
+	if repository.status(1) != datamodels.OrderExpired {
+		t.Fatalf("状态为%s，应为已过期", datamodels.OrderStatusName(repository.status(1)))
+	}
+	// 过期后不能支付或取消
+	for _, status := range []int64{datamodels.OrderPaid, datamodels.OrderCancelled, datamodels.OrderAwaitingPayment} {
+		if err := service.TransitOrder(1, status); err != ErrOrderTransition {
+			t.Errorf("已过期 -> %s: 得到%v，应为ErrOrderTransition", datamodels.OrderStatusName(status), err)
+		}
+	}
+	if repository.status(1) != datamodels.OrderExpired {
+		t.Fatalf("非法流转修改了状态：%s", datamodels.OrderStatusName(repository.status(1)))
+	}
+	// 不存在的订单
+	if err := service.TransitOrder(2, datamodels.OrderPaid); err != ErrOrderTransition {
+		t.Errorf("不存在的订单得到%v，应为ErrOrderTransition", err)
+	}
+}
+
+// 读取订单后状态被并发修改，更新失败
+func TestTransitOrderConcurrentChange(t *testing.T) {
+	repository := newFakeOrderRepository(&datamodels.Order{ID: 1, OrderStatus: datamodels.OrderAwaitingPayment})
+	service := NewOrderService(repository)
+	order, _ := repository.SelectByKey(1)
+	if err := service.TransitOrder(1, datamodels.OrderCancelled); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.TransitOrderWithTrade(order, datamodels.OrderPaid, "T1"); err != ErrOrderTransition {
+		t.Fatalf("得到%v，应为ErrOrderTransition", err)
+	}
+	if order.OrderStatus != datamodels.OrderAwaitingPayment || order.TradeNo != "" {
+		t.Fatal("更新失败时修改了订单")
+	}
+}
+
+func paymentCallback(orderID int64, tradeNo string) url.Values {
+	values := url.Values{}
+	values.Set("orderID", strconv.FormatInt(orderID, 10))
+	values.Set("tradeNo", tradeNo)
+	values.Set("status", PaymentPaid)
+	values.Set("time", strconv.FormatInt(time.Now().Unix(), 10))
+	values.Set(common.SignKey, common.SignParams(values, "secret"))
+	return values
+}
+
+func TestPaymentCallback(t *testing.T) {
+	repository := newFakeOrderRepository(
+		&datamodels.Order{ID: 1, OrderStatus: datamodels.OrderAwaitingPayment},
+		&datamodels.Order{ID: 2, OrderStatus: datamodels.OrderExpired},
+		&datamodels.Order{ID: 3, OrderStatus: datamodels.OrderCancelled},
+		&datamodels.Order{ID: 4, OrderStatus: datamodels.OrderFailed},
+	)
+	service := NewPaymentService(nil, repository, "secret", "")
+	tests := []struct {
+		orderID int64
+		tradeNo string
+		status  int64
+	}{
+		{1, "T1", datamodels.OrderPaid},
+		// 重复回调
+		{1, "T1", datamodels.OrderPaid},
+		// 已被其他交易支付，状态不变
+		{1, "T2", datamodels.OrderPaid},
+		// 关闭后收到支付，转为待退款
+		{2, "T3", datamodels.OrderRefunding},
+		{3, "T4", datamodels.OrderRefunding},
+		// 下单失败的订单不能支付
+		{4, "T5", datamodels.OrderFailed},
+	}
+	for _, test := range tests {
+		if err := service.HandleCallback(paymentCallback(test.orderID, test.tradeNo)); err != nil {
+			t.Fatalf("订单%d交易%s: %v", test.orderID, test.tradeNo, err)
+		}
+		if status := repository.status(test.orderID); status != test.status {
+			t.Errorf("订单%d交易%s: 状态为%s，应为%s", test.orderID, test.tradeNo,
+				datamodels.OrderStatusName(status), datamodels.OrderStatusName(test.status))
+		}
+	}
+	if repository.orders[1].TradeNo != "T1" || repository.orders[2].TradeNo != "T3" {
+		t.Error("交易号没有记录")
+	}
+	// 签名错误
+	values := paymentCallback(1, "T9")
+	values.Set("tradeNo", "T8")
+	if err := service.HandleCallback(values); err != ErrPaymentSign {
+		t.Errorf("得到%v，应为ErrPaymentSign", err)
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"imoc-product/common"
+	"imoc-product/datamodels"
+	"imoc-product/repositories"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 商户与支付网关之间的签名密钥，启动时从环境变量PAYMENT_SECRET读取
+var PaymentSecret string
+
+// 支付回调的有效期，超过后视为重放
+var paymentCallbackMaxAge = 10 * time.Minute
+
+// 支付状态
+const PaymentPaid = "paid"
+
+var (
+	ErrPaymentSign    = errors.New("支付签名错误！")
+	ErrPaymentExpired = errors.New("支付回调已过期！")
+	ErrPaymentOrder   = errors.New("订单不存在！")
+)
+
+// 支付网关
+type IPaymentGateway interface {
+	// 创建支付，返回买家的支付地址，同一订单重复创建返回同一笔支付
+	CreatePayment(orderID int64, notifyURL string) (string, error)
+}
+
+// 创建支付的返回结果
+type PaymentResponse struct {
+	TradeNo string
+	PayURL  string
+}
+
+// 本地模拟支付网关的客户端
+type MockGateway struct {
+	Host   string
+	secret string
+	client *http.Client
+}
+
+func NewMockGateway(host string, secret string) IPaymentGateway {
+	return &MockGateway{Host: host, secret: secret, client: &http.Client{Timeout: 3 * time.Second}}
+}
+
+func (m *MockGateway) CreatePayment(orderID int64, notifyURL string) (string, error) {
+	values := url.Values{}
+	values.Set("orderID", strconv.FormatInt(orderID, 10))
+	values.Set("notify", notifyURL)
+	values.Set("time", strconv.FormatInt(time.Now().Unix(), 10))
+	values.Set("nonce", common.NewRequestID())
+	values.Set(common.SignKey, common.SignParams(values, m.secret))
+
+	response, err := m.client.PostForm("http://"+m.Host+"/create", values)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", errors.New("创建支付失败：" + string(body))
+	}
+	payment := &PaymentResponse{}
+	if err = json.Unmarshal(body, payment); err != nil {
+		return "", err
+	}
+	return payment.PayURL, nil
+}
+
+// 校验签名和时间戳，网关和商户共用
+func VerifyPaymentParams(values url.Values, secret string) error {
+	if !common.VerifyParams(values, secret) {
+		return ErrPaymentSign
+	}
+	timestamp, err := strconv.ParseInt(values.Get("time"), 10, 64)
+	if err != nil {
+		return ErrPaymentSign
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > paymentCallbackMaxAge || age < -paymentCallbackMaxAge {
+		return ErrPaymentExpired
+	}
+	return nil
+}
+
+type IPaymentService interface {
+	// 为用户的待支付订单创建支付，返回支付地址
+	Pay(orderID int64, userID int64) (string, error)
+	// 处理网关的支付回调，重复回调返回成功
+	HandleCallback(values url.Values) error
+}
+
+type PaymentService struct {
+	gateway         IPaymentGateway
+	orderRepository repositories.IOrderRepository
+	orderService    IOrderService
+	secret          string
+	// 网关回调的地址
+	notifyURL string
+}
+
+func NewPaymentService(gateway IPaymentGateway, orderRepository repositories.IOrderRepository, secret string, notifyURL string) IPaymentService {
+	return &PaymentService{gateway: gateway, orderRepository: orderRepository, orderService: NewOrderService(orderRepository),
+		secret: secret, notifyURL: notifyURL}
+}
+
+func (p *PaymentService) Pay(orderID int64, userID int64) (string, error) {
+	order, err := p.orderRepository.SelectByKey(orderID)
+	if err != nil {
+		return "", err
+	}
+	if order.ID == 0 || order.UserId != userID {
+		return "", ErrPaymentOrder
+	}
+	if order.OrderStatus != datamodels.OrderAwaitingPayment {
+		return "", ErrOrderTransition
+	}
+	return p.gateway.CreatePayment(orderID, p.notifyURL)
+}
+
+func (p *PaymentService) HandleCallback(values url.Values) error {
+	if err := VerifyPaymentParams(values, p.secret); err != nil {
+		return err
+	}
+	if values.Get("status") != PaymentPaid {
+		// 只处理支付成功的通知
+		return nil
+	}
+	orderID, err := strconv.ParseInt(values.Get("orderID"), 10, 64)
+	if err != nil {
+		return ErrPaymentOrder
+	}
+	tradeNo := values.Get("tradeNo")
+	if tradeNo == "" {
+		return ErrPaymentSign
+	}
+
+	order, err := p.orderRepository.SelectByKey(orderID)
+	if err != nil {
+		return err
+	}
+	if order.ID == 0 {
+		return ErrPaymentOrder
+	}
+	if order.TradeNo == tradeNo {
+		// 重复回调
+		return nil
+	}
+	// 状态被并发修改时返回ErrOrderTransition，网关重新通知时按最新状态处理
+	switch order.OrderStatus {
+	case datamodels.OrderAwaitingPayment:
+		return p.orderService.TransitOrderWithTrade(order, datamodels.OrderPaid, tradeNo)
+	case datamodels.OrderCancelled, datamodels.OrderExpired:
+		// 订单关闭后才收到支付，记录交易号等待退款
+		log.Printf("订单%d状态为%s，交易%s转为待退款", orderID, datamodels.OrderStatusName(order.OrderStatus), tradeNo)
+		return p.orderService.TransitOrderWithTrade(order, datamodels.OrderRefunding, tradeNo)
+	}
+	// 订单已被其他交易支付，订单只能记录一个交易号，款项需要人工退回，回调仍然确认避免网关重复通知
+	log.Printf("订单%d状态为%s，交易%s需要退款", orderID, datamodels.OrderStatusName(order.OrderStatus), tradeNo)
+	return nil
+}
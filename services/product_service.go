@@ -11,17 +11,12 @@ type IProductService interface {
 	DeleteProductById(int64) bool
 	InsertProduct(product *datamodels.Product) (int64, error)
 	UpdateProduct(product *datamodels.Product) error
-	SubNumberOne(productID int64) error
 }
 
 type ProductService struct {
 	productRepository repositories.IProduct
 }
 
-func (p *ProductService) SubNumberOne(productID int64) error {
-	return p.productRepository.SubProductNum(productID)
-}
-
 func (p *ProductService) GetProductByID(productID int64) (*datamodels.Product, error) {
 	return p.productRepository.SelectByKey(productID)
 }
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"database/sql"
+	"imoc-product/repositories"
+	"time"
+)
+
+// 用户限购，按秒杀活动分别计算，Check阶段预先检查，下单事务中再次校验
+type IPurchaseService interface {
+	// 用户在该活动中是否还能购买该商品，限购数量为0表示不限购
+	CanPurchase(userID int64, productID int64, campaignID int64) (bool, error)
+	// 在下单事务中记录一次购买，超过限购数量返回false
+	RecordTx(tx *sql.Tx, userID int64, productID int64, campaignID int64) (bool, error)
+	// 订单关闭时在事务中退回购买数量
+	RevertTx(tx *sql.Tx, userID int64, productID int64, campaignID int64) error
+	// 商品当前所在活动的ID，没有活动时返回0，用于没有携带活动ID的下单消息
+	CurrentCampaign(productID int64) int64
+}
+
+type PurchaseService struct {
+	purchaseRepository repositories.IPurchaseRepository
+	// 限购数量只来自秒杀活动的配置，验证服务和消费端读取同一份活动记录
+	campaignService ICampaignService
+}
+
+func NewPurchaseService(repository repositories.IPurchaseRepository, campaignService ICampaignService) IPurchaseService {
+	return &PurchaseService{purchaseRepository: repository, campaignService: campaignService}
+}
+
+func (p *PurchaseService) CurrentCampaign(productID int64) int64 {
+	// 活动刚结束时仍然按该活动限购
+	campaign, _ := p.campaignService.CheckWindow(productID, time.Now())
+	if campaign == nil {
+		return 0
+	}
+	return campaign.ID
+}
+
+// 活动的限购数量，商品只在秒杀活动中售卖，没有活动时返回ErrCampaignNotFound
+func (p *PurchaseService) limitOf(productID int64, campaignID int64) (int64, error) {
+	if campaignID == 0 {
+		return 0, ErrCampaignNotFound
+	}
+	// 优先使用活动缓存
+	campaign, _ := p.campaignService.CheckWindow(productID, time.Now())
+	if campaign == nil || campaign.ID != campaignID {
+		var err error
+		campaign, err = p.campaignService.GetCampaignByID(campaignID)
+		if err != nil {
+			return 0, err
+		}
+		if campaign.ID == 0 {
+			return 0, ErrCampaignNotFound
+		}
+	}
+	return campaign.UserLimit, nil
+}
+
+func (p *PurchaseService) CanPurchase(userID int64, productID int64, campaignID int64) (bool, error) {
+	limit, err := p.limitOf(productID, campaignID)
+	if err != nil {
+		return false, err
+	}
+	if limit <= 0 {
+		return true, nil
+	}
+	num, err := p.purchaseRepository.SelectNum(userID, productID, campaignID)
+	if err != nil {
+		return false, err
+	}
+	return num < limit, nil
+}
+
+func (p *PurchaseService) RecordTx(tx *sql.Tx, userID int64, productID int64, campaignID int64) (bool, error) {
+	limit, err := p.limitOf(productID, campaignID)
+	if err != nil {
+		return false, err
+	}
+	return p.purchaseRepository.AddNumTx(tx, userID, productID, campaignID, limit)
+}
+
+func (p *PurchaseService) RevertTx(tx *sql.Tx, userID int64, productID int64, campaignID int64) error {
+	return p.purchaseRepository.SubNumTx(tx, userID, productID, campaignID)
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"database/sql"
+	"imoc-product/datamodels"
+	"imoc-product/repositories"
+	"log"
+	"time"
+)
+
+// 秒杀下单，订单插入和库存扣减在同一个事务中完成
+type ISeckillService interface {
+	// 库存不足时订单状态为OrderFailed，超过限购时为OrderLimited，不返回错误
+	PlaceOrderByMessage(message *datamodels.Message) (*datamodels.Order, error)
+	// 取消待支付的订单并退回库存
+	CancelOrder(orderID int64) error
+	// 将创建超过timeout仍未支付的订单置为过期并退回库存，返回处理的订单数
+	ExpireOrders(timeout time.Duration, limit int) (int, error)
+}
+
+// 数量控制服务的库存退回
+type IStockReturner interface {
+	ReturnStock(productID int64, num int64) error
+}
+
+// 退回数量控制服务库存的重试次数
+var returnStockRetry = 3
+
+type SeckillService struct {
+	unitOfWork      repositories.IUnitOfWork
+	orderRepository repositories.IOrderRepository
+	// 订单状态都通过状态机变更
+	orderService      IOrderService
+	productRepository repositories.IProduct
+	// 为nil时不限购
+	purchaseService IPurchaseService
+	// 为nil时只退回数据库库存
+	stockReturner IStockReturner
+}
+
+func NewSeckillService(unitOfWork repositories.IUnitOfWork, orderRepository repositories.IOrderRepository, productRepository repositories.IProduct,
+	purchaseService IPurchaseService, stockReturner IStockReturner) ISeckillService {
+	return &SeckillService{
+		unitOfWork:        unitOfWork,
+		orderRepository:   orderRepository,
+		orderService:      NewOrderService(orderRepository),
+		productRepository: productRepository,
+		purchaseService:   purchaseService,
+		stockReturner:     stockReturner,
+	}
+}
+
+func (s *SeckillService) PlaceOrderByMessage(message *datamodels.Message) (*datamodels.Order, error) {
+	order := &datamodels.Order{
+		UserId:      message.UserID,
+		ProductId:   message.ProductID,
+		OrderStatus: datamodels.OrderWait,
+		RequestID:   message.RequestID,
+		CampaignID:  message.CampaignID,
+	}
+	if order.CampaignID == 0 && s.purchaseService != nil {
+		order.CampaignID = s.purchaseService.CurrentCampaign(message.ProductID)
+	}
+	err := s.unitOfWork.Do(func(tx *sql.Tx) error {
+		orderID, created, err := s.orderRepository.InsertTx(tx, order)
+		if err != nil {
+			return err
+		}
+		order.ID = orderID
+		if !created {
+			// 重复投递的消息，订单已经处理过
+			return nil
+		}
+		if s.purchaseService != nil {
+			ok, err := s.purchaseService.RecordTx(tx, message.UserID, message.ProductID, order.CampaignID)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// 超过限购数量
+				return s.orderService.TransitOrderTx(tx, order, datamodels.OrderLimited)
+			}
+		}
+		ok, err := s.productRepository.SubProductNumTx(tx, message.ProductID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// 库存已经卖完，订单标记为失败，退回购买记录
+			if s.purchaseService != nil {
+				if err = s.purchaseService.RevertTx(tx, message.UserID, message.ProductID, order.CampaignID); err != nil {
+					return err
+				}
+			}
+			return s.orderService.TransitOrderTx(tx, order, datamodels.OrderFailed)
+		}
+		// 扣减成功，等待支付
+		return s.orderService.TransitOrderTx(tx, order, datamodels.OrderAwaitingPayment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if order.OrderStatus == datamodels.OrderLimited {
+		// 数量控制服务已经售出这一件，退回给其他用户
+		s.returnStock(order)
+	}
+	return order, nil
+}
+
+func (s *SeckillService) CancelOrder(orderID int64) error {
+	order, err := s.orderRepository.SelectByKey(orderID)
+	if err != nil {
+		return err
+	}
+	if order.ID == 0 {
+		return ErrOrderTransition
+	}
+	return s.releaseOrder(order, datamodels.OrderCancelled)
+}
+
+func (s *SeckillService) ExpireOrders(timeout time.Duration, limit int) (int, error) {
+	orders, err := s.orderRepository.SelectCreatedBefore(datamodels.OrderAwaitingPayment, time.Now().Add(-timeout), limit)
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, order := range orders {
+		err := s.releaseOrder(order, datamodels.OrderExpired)
+		if err == ErrOrderTransition {
+			// 已被支付或取消
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// 关闭待支付的订单，订单状态和数据库库存在同一事务中更新，之后退回数量控制服务的库存
+func (s *SeckillService) releaseOrder(order *datamodels.Order, status int64) error {
+	if order.OrderStatus != datamodels.OrderAwaitingPayment {
+		return ErrOrderTransition
+	}
+	err := s.unitOfWork.Do(func(tx *sql.Tx) error {
+		if err := s.orderService.TransitOrderTx(tx, order, status); err != nil {
+			return err
+		}
+		if s.purchaseService != nil {
+			if err := s.purchaseService.RevertTx(tx, order.UserId, order.ProductId, order.CampaignID); err != nil {
+				return err
+			}
+		}
+		return s.productRepository.AddProductNumTx(tx, order.ProductId, 1)
+	})
+	if err != nil {
+		// 事务回滚，订单仍为待支付
+		order.OrderStatus = datamodels.OrderAwaitingPayment
+		return err
+	}
+	s.returnStock(order)
+	return nil
+}
+
+// 退回数量控制服务的库存
+func (s *SeckillService) returnStock(order *datamodels.Order) {
+	if s.stockReturner == nil {
+		return
+	}
+	var err error
+	for i := 0; i < returnStockRetry; i++ {
+		if err = s.stockReturner.ReturnStock(order.ProductId, 1); err == nil {
+			return
+		}
+	}
+	// 数据库库存已退回，数量控制服务少卖一件，不会超卖
+	log.Printf("订单%d退回数量控制服务库存失败：%s", order.ID, err)
+}
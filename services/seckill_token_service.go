@@ -0,0 +1,63 @@
+package services
+
+import (
+	"imoc-product/common"
+	"strconv"
+	"time"
+)
+
+// 前端与验证服务之间的秒杀令牌密钥，启动时从环境变量SECKILL_SECRET读取
+var SeckillSecret string
+
+// 秒杀令牌的有效时间
+var seckillTokenTTL = 30 * time.Second
+
+// 秒杀令牌
+type SeckillToken struct {
+	Token string
+	// 过期时间，unix毫秒
+	Expire int64
+	// 携带令牌的下单地址
+	Path string
+}
+
+// 秒杀令牌，活动开始后才发放，防止脚本在开始前提前请求
+type ISeckillTokenService interface {
+	// 为用户发放令牌，活动未开始时返回活动和对应的错误
+	IssueToken(userID int64, productID int64) (*SeckillToken, error)
+	VerifyToken(token string, userID int64, productID int64) error
+}
+
+type SeckillTokenService struct {
+	campaignService ICampaignService
+	secret          string
+}
+
+func NewSeckillTokenService(campaignService ICampaignService, secret string) ISeckillTokenService {
+	return &SeckillTokenService{campaignService: campaignService, secret: secret}
+}
+
+func (s *SeckillTokenService) IssueToken(userID int64, productID int64) (*SeckillToken, error) {
+	now := time.Now()
+	campaign, err := s.campaignService.CheckWindow(productID, now)
+	if err != nil {
+		return nil, err
+	}
+	// 令牌不超过活动结束时间
+	expire := now.Add(seckillTokenTTL)
+	if campaign.EndTime.Before(expire) {
+		expire = campaign.EndTime
+	}
+	uid := strconv.FormatInt(userID, 10)
+	productString := strconv.FormatInt(productID, 10)
+	token := common.NewSeckillToken(s.secret, uid, productString, expire)
+	return &SeckillToken{
+		Token:  token,
+		Expire: expire.Unix() * 1000,
+		Path:   "/check?productID=" + productString + "&token=" + token,
+	}, nil
+}
+
+func (s *SeckillTokenService) VerifyToken(token string, userID int64, productID int64) error {
+	return common.VerifySeckillToken(s.secret, token, strconv.FormatInt(userID, 10), strconv.FormatInt(productID, 10), time.Now())
+}
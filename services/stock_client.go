@@ -0,0 +1,37 @@
+package services
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 数量控制服务(getOne)的客户端，host为单机节点或集群协调节点的地址
+type GetOneClient struct {
+	Host   string
+	client *http.Client
+}
+
+func NewGetOneClient(host string) IStockReturner {
+	return &GetOneClient{Host: host, client: &http.Client{Timeout: 3 * time.Second}}
+}
+
+// 退回已售出的库存，减少已售数量，不改变商品总数
+func (g *GetOneClient) ReturnStock(productID int64, num int64) error {
+	url := "http://" + g.Host + "/release?productID=" + strconv.FormatInt(productID, 10) + "&num=" + strconv.FormatInt(num, 10)
+	response, err := g.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return errors.New("退回库存失败：" + string(body))
+	}
+	return nil
+}
@@ -0,0 +1,421 @@
+package stock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 数量控制集群
+// 协调节点保存每个商品的总库存，售卖节点通过心跳向协调节点租借库存配额，在本地配额内独立售卖。
+// 协调节点保证每个节点未上报的配额(已授予-已上报售出)不超过chunk，所有节点的配额之和不超过总库存。
+//
+// 租约：节点只在最近一次成功心跳的发送时间+租约时长内售卖，
+// 协调节点在最后一次收到心跳的时间+租约时长+宽限时间后才认定节点失效，
+// 因此节点失效时已经停止售卖。
+// 额度：节点累计售出数量不超过最近一次上报的售出数量+allowance，本地计数器的总数即为该上限，
+// 因此节点失效时协调节点只需保留allowance，其余未上报配额立即收回重新分配给其它节点；
+// 节点带着本地日志重新加入时上报真实售出数量，剩余的额度也收回。
+
+// 心跳请求，节点上报各商品的本地配额和售出数量
+type HeartbeatRequest struct {
+	Node    string          `json:"Node"`
+	Granted map[int64]int64 `json:"Granted"`
+	Sold    map[int64]int64 `json:"Sold"`
+}
+
+// 商品配额
+type Quota struct {
+	Granted int64 `json:"Granted"`
+	Frozen  bool  `json:"Frozen"`
+}
+
+// 心跳响应，返回节点最新的配额和租约时长
+type HeartbeatResponse struct {
+	Products map[int64]*Quota `json:"Products"`
+	// 租约时长，单位毫秒
+	Lease int64 `json:"Lease"`
+	// 每个商品最多持有的未上报配额
+	Chunk int64 `json:"Chunk"`
+	// 两次上报之间最多售出的数量
+	Allowance int64 `json:"Allowance"`
+}
+
+// 节点状态
+type NodeStat struct {
+	Node     string          `json:"Node"`
+	Granted  map[int64]int64 `json:"Granted"`
+	Sold     map[int64]int64 `json:"Sold"`
+	LastSeen time.Time       `json:"LastSeen"`
+	Revoked  bool            `json:"Revoked"`
+}
+
+// 节点租约
+type nodeLease struct {
+	granted  map[int64]int64
+	sold     map[int64]int64
+	lastSeen time.Time
+	// 租约已失效，等待节点重新加入后对账
+	revoked bool
+}
+
+// 协调节点
+// pools中计数器的已售数量表示已授予各节点的配额总和
+type Coordinator struct {
+	pools *Pools
+	// 每个节点每个商品最多持有的未上报配额
+	chunk int64
+	// 节点在最近一次上报之后最多售出的数量，节点失效时只有这部分配额无法确定是否售出
+	allowance int64
+	lease     time.Duration
+	// 认定节点失效前的额外等待时间
+	grace time.Duration
+	nodes map[string]*nodeLease
+	done  chan struct{}
+	sync.Mutex
+}
+
+func NewCoordinator(pools *Pools, chunk int64, lease time.Duration, grace time.Duration) *Coordinator {
+	allowance := chunk / 4
+	if allowance < 1 {
+		allowance = 1
+	}
+	c := &Coordinator{
+		pools:     pools,
+		chunk:     chunk,
+		allowance: allowance,
+		lease:     lease,
+		grace:     grace,
+		nodes:     make(map[string]*nodeLease),
+		done:      make(chan struct{}),
+	}
+	go c.expireLoop()
+	return c
+}
+
+// 处理节点心跳，续约并补充配额
+func (c *Coordinator) Heartbeat(req *HeartbeatRequest) (*HeartbeatResponse, error) {
+	if req.Node == "" {
+		return nil, errors.New("节点名称不能为空！")
+	}
+	c.Lock()
+	defer c.Unlock()
+	lease, ok := c.nodes[req.Node]
+	if !ok {
+		// 协调节点重启后不认识的节点，以节点上报的配额为准，这部分配额已计入日志中的已售数量
+		lease = &nodeLease{granted: make(map[int64]int64), sold: make(map[int64]int64)}
+		for productID, granted := range req.Granted {
+			lease.granted[productID] = granted
+		}
+		c.nodes[req.Node] = lease
+	}
+	if lease.revoked {
+		// 节点失效期间没有售卖，上报的售出数量即为最终数量，收回剩余配额
+		for productID, granted := range lease.granted {
+			sold := req.Sold[productID]
+			if granted > sold {
+				if err := c.pools.ReleaseN(productID, granted-sold); err != nil {
+					return nil, err
+				}
+				lease.granted[productID] = sold
+			}
+		}
+		lease.revoked = false
+		log.Println("节点重新加入，配额已对账：", req.Node)
+	}
+	lease.lastSeen = time.Now()
+
+	resp := &HeartbeatResponse{Products: make(map[int64]*Quota), Lease: int64(c.lease / time.Millisecond), Chunk: c.chunk, Allowance: c.allowance}
+	for _, productID := range c.pools.ProductIDs() {
+		lease.sold[productID] = req.Sold[productID]
+		stat, err := c.pools.Stat(productID)
+		if err != nil {
+			return nil, err
+		}
+		// 未上报配额不足chunk时补充
+		if want := c.chunk - (lease.granted[productID] - lease.sold[productID]); want > 0 && !stat.Frozen {
+			got, err := c.pools.GetN(productID, want)
+			if err != nil {
+				return nil, err
+			}
+			lease.granted[productID] += got
+		}
+		resp.Products[productID] = &Quota{Granted: lease.granted[productID], Frozen: stat.Frozen}
+	}
+	return resp, nil
+}
+
+// 全部节点状态
+func (c *Coordinator) Nodes() []*NodeStat {
+	c.Lock()
+	defer c.Unlock()
+	nodes := make([]*NodeStat, 0, len(c.nodes))
+	for name, lease := range c.nodes {
+		stat := &NodeStat{
+			Node:     name,
+			Granted:  make(map[int64]int64),
+			Sold:     make(map[int64]int64),
+			LastSeen: lease.lastSeen,
+			Revoked:  lease.revoked,
+		}
+		for k, v := range lease.granted {
+			stat.Granted[k] = v
+		}
+		for k, v := range lease.sold {
+			stat.Sold[k] = v
+		}
+		nodes = append(nodes, stat)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	return nodes
+}
+
+// 定时检查失效节点
+func (c *Coordinator) expireLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.expire(now)
+		}
+	}
+}
+
+// 撤销失效节点的租约，收回超出售出上限的配额
+// 节点累计售出不超过最近一次上报的售出数量+allowance，超出的配额一定没有售出
+func (c *Coordinator) expire(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	for name, lease := range c.nodes {
+		if lease.revoked || !lease.lastSeen.Add(c.lease+c.grace).Before(now) {
+			continue
+		}
+		lease.revoked = true
+		for productID, granted := range lease.granted {
+			limit := lease.sold[productID] + c.allowance
+			if granted <= limit {
+				continue
+			}
+			if err := c.pools.ReleaseN(productID, granted-limit); err != nil {
+				log.Println("收回失效节点的配额失败：", name, productID, err)
+				continue
+			}
+			lease.granted[productID] = limit
+		}
+		log.Println("节点失效，租约已撤销，配额已收回：", name)
+	}
+}
+
+func (c *Coordinator) Close() {
+	close(c.done)
+}
+
+// 售卖节点
+// 本地使用文件计数器保存配额和售出数量，计数器总数即为已授予的配额
+type ClusterNode struct {
+	name        string
+	coordinator string
+	pools       *Pools
+	interval    time.Duration
+	client      *http.Client
+	// 租约到期时间，unix纳秒
+	leaseUntil int64
+	// 协调节点下发的两次上报之间的售出额度
+	allowance int64
+	// 协调节点授予的配额，本地计数器总数是售出上限，可能小于配额
+	granted map[int64]int64
+	refill  chan struct{}
+	done    chan struct{}
+}
+
+// 创建售卖节点，coordinator为协调节点地址
+func NewClusterNode(name string, coordinator string, interval time.Duration) *ClusterNode {
+	return &ClusterNode{
+		name:        name,
+		coordinator: coordinator,
+		interval:    interval,
+		client:      &http.Client{Timeout: interval},
+		granted:     make(map[int64]int64),
+		refill:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+// 包装计数器后端，使本地计数器受租约控制
+func (n *ClusterNode) Backend(backend IBackend) IBackend {
+	return &clusterBackend{node: n, backend: backend}
+}
+
+// 开始向协调节点发送心跳
+func (n *ClusterNode) Start(pools *Pools) {
+	n.pools = pools
+	go n.heartbeatLoop()
+}
+
+// 租约是否有效
+func (n *ClusterNode) LeaseValid() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&n.leaseUntil)
+}
+
+// 请求立即补充配额
+func (n *ClusterNode) Refill() {
+	select {
+	case n.refill <- struct{}{}:
+	default:
+	}
+}
+
+func (n *ClusterNode) heartbeatLoop() {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		if err := n.heartbeat(); err != nil {
+			log.Println("心跳失败：", err)
+		}
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		case <-n.refill:
+		}
+	}
+}
+
+// 上报本地数据并同步配额
+func (n *ClusterNode) heartbeat() error {
+	req := &HeartbeatRequest{Node: n.name, Granted: make(map[int64]int64), Sold: make(map[int64]int64)}
+	for _, productID := range n.pools.ProductIDs() {
+		stat, err := n.pools.Stat(productID)
+		if err != nil {
+			return err
+		}
+		// 重启后还没有收到心跳响应时以本地上限为准，协调节点只在不认识该节点时使用
+		granted, ok := n.granted[productID]
+		if !ok {
+			granted = stat.Total
+		}
+		req.Granted[productID] = granted
+		req.Sold[productID] = stat.Sum
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	// 租约从发送请求时开始计算，保证早于协调节点认定的失效时间
+	sendTime := time.Now()
+	response, err := n.client.Post("http://"+n.coordinator+"/cluster/heartbeat", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New("协调节点返回异常状态：" + response.Status)
+	}
+	resp := &HeartbeatResponse{}
+	if err := json.NewDecoder(response.Body).Decode(resp); err != nil {
+		return err
+	}
+
+	for productID, quota := range resp.Products {
+		stat, err := n.pools.Load(productID, 0)
+		if err != nil {
+			return err
+		}
+		n.granted[productID] = quota.Granted
+		// 售出上限不超过 本次上报的售出数量+额度，上报之后售出的部分也计入额度
+		limit := req.Sold[productID] + resp.Allowance
+		if limit > quota.Granted {
+			limit = quota.Granted
+		}
+		if delta := limit - stat.Total; delta != 0 {
+			if err := n.pools.adjust(productID, delta); err != nil {
+				return err
+			}
+		}
+		if quota.Frozen != stat.Frozen {
+			if _, err := n.pools.Freeze(productID, quota.Frozen); err != nil {
+				return err
+			}
+		}
+	}
+	atomic.StoreInt64(&n.allowance, resp.Allowance)
+	atomic.StoreInt64(&n.leaseUntil, sendTime.Add(time.Duration(resp.Lease)*time.Millisecond).UnixNano())
+	return nil
+}
+
+// 停止心跳
+func (n *ClusterNode) Close() {
+	close(n.done)
+}
+
+type clusterBackend struct {
+	node    *ClusterNode
+	backend IBackend
+}
+
+func (c *clusterBackend) Restore() (map[int64]ICounter, error) {
+	counters, err := c.backend.Restore()
+	if err != nil {
+		return nil, err
+	}
+	for productID, counter := range counters {
+		counters[productID] = &quotaCounter{ICounter: counter, node: c.node}
+	}
+	return counters, nil
+}
+
+func (c *clusterBackend) Create(productID int64, productNum int64) (ICounter, error) {
+	counter, err := c.backend.Create(productID, productNum)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaCounter{ICounter: counter, node: c.node}, nil
+}
+
+// 受租约控制的计数器，额度不足时触发补充
+type quotaCounter struct {
+	ICounter
+	node *ClusterNode
+	// 售出次数，用于抽样检查剩余额度
+	calls uint32
+}
+
+// 每售出多少次检查一次剩余额度，统计已售数量需要读取所有分片
+const refillCheckEvery = 16
+
+// 单件直接从本地计数器的分片中扣减，分片用完时才租用并写日志
+func (q *quotaCounter) GetOne() (bool, error) {
+	if !q.node.LeaseValid() {
+		return false, nil
+	}
+	isOk, err := q.ICounter.GetOne()
+	if !isOk || atomic.AddUint32(&q.calls, 1)%refillCheckEvery == 0 {
+		q.checkRefill()
+	}
+	return isOk, err
+}
+
+func (q *quotaCounter) GetN(num int64) (int64, error) {
+	if !q.node.LeaseValid() {
+		return 0, nil
+	}
+	got, err := q.ICounter.GetN(num)
+	q.checkRefill()
+	return got, err
+}
+
+// 剩余额度不足一半时提前上报并补充
+func (q *quotaCounter) checkRefill() {
+	if remain := q.Total() - q.Sum(); remain*2 < atomic.LoadInt64(&q.node.allowance) {
+		q.node.Refill()
+	}
+}
@@ -0,0 +1,148 @@
+package stock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProductID = 1
+
+// 启动协调节点，商品总数productNum
+func newTestCoordinator(t *testing.T, productNum int64, chunk int64) (*Coordinator, *Pools, string) {
+	pools, err := NewPools(NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pools.Load(testProductID, productNum); err != nil {
+		t.Fatal(err)
+	}
+	coordinator := NewCoordinator(pools, chunk, time.Minute, time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &HeartbeatRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := coordinator.Heartbeat(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(func() {
+		server.Close()
+		coordinator.Close()
+		pools.Close()
+	})
+	return coordinator, pools, strings.TrimPrefix(server.URL, "http://")
+}
+
+// 创建售卖节点，不启动心跳循环，由测试手动发送心跳
+func newTestNode(t *testing.T, name string, coordinator string) (*ClusterNode, *Pools) {
+	node := NewClusterNode(name, coordinator, time.Second)
+	pools, err := NewPools(node.Backend(NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.pools = pools
+	t.Cleanup(pools.Close)
+	return node, pools
+}
+
+func sellAll(t *testing.T, pools *Pools) int64 {
+	var sold int64
+	for {
+		isOk, err := pools.GetOne(testProductID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isOk {
+			return sold
+		}
+		sold++
+	}
+}
+
+func TestClusterNodeSellsWithinAllowance(t *testing.T) {
+	coordinator, _, addr := newTestCoordinator(t, 1000, 100)
+	node, pools := newTestNode(t, "a", addr)
+	if isOk, _ := pools.GetOne(testProductID); isOk {
+		t.Fatal("没有租约时售出了商品")
+	}
+	if err := node.heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	// 两次上报之间最多售出allowance件
+	if sold := sellAll(t, pools); sold != coordinator.allowance {
+		t.Fatalf("售出%d件，应为%d", sold, coordinator.allowance)
+	}
+	if err := node.heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	if sold := sellAll(t, pools); sold != coordinator.allowance {
+		t.Fatalf("上报后售出%d件，应为%d", sold, coordinator.allowance)
+	}
+}
+
+// 节点失效后协调节点收回超出售出上限的配额，重新分配给其它节点，总售出不超过库存
+func TestClusterReclaimExpiredNode(t *testing.T) {
+	const productNum = 300
+	coordinator, coordinatorPools, addr := newTestCoordinator(t, productNum, 100)
+	a, poolsA := newTestNode(t, "a", addr)
+	b, poolsB := newTestNode(t, "b", addr)
+	c, poolsC := newTestNode(t, "c", addr)
+	for _, node := range []*ClusterNode{a, b, c} {
+		if err := node.heartbeat(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	soldA := sellAll(t, poolsA)
+	if stat, _ := coordinatorPools.Stat(testProductID); stat.Sum != productNum {
+		t.Fatalf("已授予%d件，应为%d", stat.Sum, productNum)
+	}
+
+	// a停止心跳，租约到期后收回a的配额，只保留售出上限
+	coordinator.Lock()
+	coordinator.nodes["a"].lastSeen = time.Now().Add(-2 * time.Minute)
+	coordinator.Unlock()
+	coordinator.expire(time.Now())
+	stat, _ := coordinatorPools.Stat(testProductID)
+	if want := int64(productNum - 100 + coordinator.allowance); stat.Sum != want {
+		t.Fatalf("收回后已授予%d件，应为%d", stat.Sum, want)
+	}
+
+	// 收回的配额分配给其它节点
+	var sold int64
+	for i := 0; i < 100; i++ {
+		for _, node := range []*ClusterNode{b, c} {
+			if err := node.heartbeat(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n := sellAll(t, poolsB) + sellAll(t, poolsC)
+		if n == 0 {
+			break
+		}
+		sold += n
+	}
+	if want := int64(productNum) - coordinator.allowance; sold != want {
+		t.Fatalf("其它节点售出%d件，应为%d", sold, want)
+	}
+
+	// a重新加入，上报真实售出数量，剩余额度也收回
+	if err := a.heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	stat, _ = coordinatorPools.Stat(testProductID)
+	if stat.Sum != productNum-coordinator.allowance+soldA {
+		t.Fatalf("a重新加入后已授予%d件，应为%d", stat.Sum, productNum-coordinator.allowance+soldA)
+	}
+	if total := soldA + sold; total > productNum {
+		t.Fatalf("总售出%d件，超过库存%d", total, productNum)
+	}
+}
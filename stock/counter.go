@@ -0,0 +1,121 @@
+package stock
+
+import (
+	"errors"
+	"sync"
+)
+
+var errNothingSold = errors.New("没有可退回的商品！")
+
+// 库存计数器接口
+// 数量控制服务只依赖该接口，Redis、etcd等后端实现该接口即可替换
+type ICounter interface {
+	// 获取一件商品，成功返回true
+	GetOne() (bool, error)
+	// 退回一件已售出的商品
+	Release() error
+	// 批量获取商品，返回实际获取的数量
+	GetN(num int64) (int64, error)
+	// 批量退回已售出的商品
+	ReleaseN(num int64) error
+	// 已售数量
+	Sum() int64
+	// 商品总数
+	Total() int64
+	// 补充库存
+	Add(num int64) error
+	// 冻结或解冻库存，冻结后不再售出
+	SetFrozen(frozen bool) error
+	// 是否冻结
+	Frozen() bool
+	// 关闭计数器，释放资源
+	Close() error
+}
+
+// 内存计数器，进程重启后数据丢失
+type MemoryCounter struct {
+	sum        int64
+	productNum int64
+	frozen     bool
+	// 互斥锁
+	sync.Mutex
+}
+
+func NewMemoryCounter(productNum int64) ICounter {
+	return &MemoryCounter{productNum: productNum}
+}
+
+func (m *MemoryCounter) GetOne() (bool, error) {
+	// 加锁
+	m.Lock()
+	defer m.Unlock()
+	// 判断数据是否超限
+	if !m.frozen && m.sum < m.productNum {
+		m.sum += 1
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *MemoryCounter) Release() error {
+	return m.ReleaseN(1)
+}
+
+func (m *MemoryCounter) GetN(num int64) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.frozen || m.sum >= m.productNum {
+		return 0, nil
+	}
+	if num > m.productNum-m.sum {
+		num = m.productNum - m.sum
+	}
+	m.sum += num
+	return num, nil
+}
+
+func (m *MemoryCounter) ReleaseN(num int64) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.sum < num {
+		return errNothingSold
+	}
+	m.sum -= num
+	return nil
+}
+
+func (m *MemoryCounter) Sum() int64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.sum
+}
+
+func (m *MemoryCounter) Total() int64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.productNum
+}
+
+func (m *MemoryCounter) Add(num int64) error {
+	m.Lock()
+	defer m.Unlock()
+	m.productNum += num
+	return nil
+}
+
+func (m *MemoryCounter) SetFrozen(frozen bool) error {
+	m.Lock()
+	defer m.Unlock()
+	m.frozen = frozen
+	return nil
+}
+
+func (m *MemoryCounter) Frozen() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.frozen
+}
+
+func (m *MemoryCounter) Close() error {
+	return nil
+}
@@ -0,0 +1,196 @@
+package stock
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// 日志记录超过该数量时压缩为快照
+var CompactThreshold = 100000
+
+// 基于预写日志的计数器
+// 每次变更先写日志再修改内存，重启后重放日志恢复已售数量和商品总数，保证不会超卖
+type FileCounter struct {
+	sum        int64
+	productNum int64
+	frozen     bool
+	wal        *WAL
+	sync.Mutex
+}
+
+// 打开文件计数器
+// 日志中已有商品总数时以日志为准，productNum只在首次创建时生效
+func NewFileCounter(path string, productNum int64) (ICounter, error) {
+	wal, state, err := openCounterWAL(path, productNum)
+	if err != nil {
+		return nil, err
+	}
+	return &FileCounter{wal: wal, sum: state.sum, productNum: state.productNum, frozen: state.frozen}, nil
+}
+
+// 计数器日志重放后的状态
+type counterState struct {
+	sum        int64
+	productNum int64
+	frozen     bool
+}
+
+// 打开计数器日志并重放，文件计数器和分片计数器共用同一种日志格式
+func openCounterWAL(path string, productNum int64) (*WAL, *counterState, error) {
+	wal, records, err := OpenWAL(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	state := &counterState{}
+	initialized := false
+	for _, record := range records {
+		switch record.Op {
+		case OpInit:
+			state.productNum = record.Num
+			initialized = true
+		case OpGet:
+			state.sum += record.Num
+		case OpRelease:
+			state.sum -= record.Num
+		case OpAdd:
+			state.productNum += record.Num
+		case OpFreeze:
+			state.frozen = record.Num == 1
+		}
+	}
+	if !initialized {
+		if err := wal.Append(&Record{Op: OpInit, Num: productNum}); err != nil {
+			wal.Close()
+			return nil, nil, err
+		}
+		state.productNum = productNum
+	}
+	if state.sum > state.productNum {
+		wal.Close()
+		return nil, nil, errors.New("日志数据异常，已售数量超过商品总数！")
+	}
+	return wal, state, nil
+}
+
+func (f *FileCounter) GetOne() (bool, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.frozen || f.sum >= f.productNum {
+		return false, nil
+	}
+	// 先落盘再修改内存，写入失败则本次不售出
+	if err := f.append(&Record{Op: OpGet, Num: 1}); err != nil {
+		return false, err
+	}
+	f.sum += 1
+	return true, nil
+}
+
+func (f *FileCounter) Release() error {
+	return f.ReleaseN(1)
+}
+
+func (f *FileCounter) GetN(num int64) (int64, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.frozen || f.sum >= f.productNum {
+		return 0, nil
+	}
+	if num > f.productNum-f.sum {
+		num = f.productNum - f.sum
+	}
+	if err := f.append(&Record{Op: OpGet, Num: num}); err != nil {
+		return 0, err
+	}
+	f.sum += num
+	return num, nil
+}
+
+func (f *FileCounter) ReleaseN(num int64) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.sum < num {
+		return errNothingSold
+	}
+	if err := f.append(&Record{Op: OpRelease, Num: num}); err != nil {
+		return err
+	}
+	f.sum -= num
+	return nil
+}
+
+func (f *FileCounter) Add(num int64) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.append(&Record{Op: OpAdd, Num: num}); err != nil {
+		return err
+	}
+	f.productNum += num
+	return nil
+}
+
+func (f *FileCounter) SetFrozen(frozen bool) error {
+	f.Lock()
+	defer f.Unlock()
+	var num int64
+	if frozen {
+		num = 1
+	}
+	if err := f.append(&Record{Op: OpFreeze, Num: num}); err != nil {
+		return err
+	}
+	f.frozen = frozen
+	return nil
+}
+
+// 写入日志，记录过多时先压缩为当前状态的快照
+// 压缩失败时保留原日志继续追加，日志已不可用时追加会返回错误
+func (f *FileCounter) append(record *Record) error {
+	if f.wal.Count() >= CompactThreshold {
+		if err := f.wal.Rewrite(f.snapshot()); err != nil {
+			log.Println("压缩库存日志失败：", f.wal.path, err)
+		}
+	}
+	return f.wal.Append(record)
+}
+
+// 生成当前状态的快照记录
+func (f *FileCounter) snapshot() []*Record {
+	return counterSnapshot(f.productNum, f.sum, f.frozen)
+}
+
+// 计数器状态的快照记录
+func counterSnapshot(productNum int64, sum int64, frozen bool) []*Record {
+	var frozenNum int64
+	if frozen {
+		frozenNum = 1
+	}
+	return []*Record{
+		{Op: OpInit, Num: productNum},
+		{Op: OpGet, Num: sum},
+		{Op: OpFreeze, Num: frozenNum},
+	}
+}
+
+func (f *FileCounter) Sum() int64 {
+	f.Lock()
+	defer f.Unlock()
+	return f.sum
+}
+
+func (f *FileCounter) Total() int64 {
+	f.Lock()
+	defer f.Unlock()
+	return f.productNum
+}
+
+func (f *FileCounter) Frozen() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.frozen
+}
+
+func (f *FileCounter) Close() error {
+	return f.wal.Close()
+}
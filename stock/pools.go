@@ -0,0 +1,246 @@
+package stock
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrNotLoaded = errors.New("商品库存未加载！")
+
+// 库存池状态
+type Stat struct {
+	ProductID int64 `json:"ProductID"`
+	Total     int64 `json:"Total"`
+	Sum       int64 `json:"Sum"`
+	Frozen    bool  `json:"Frozen"`
+}
+
+// 计数器后端，负责为每个商品创建和恢复计数器
+type IBackend interface {
+	// 恢复已有的全部商品计数器
+	Restore() (map[int64]ICounter, error)
+	// 为商品创建计数器
+	Create(productID int64, productNum int64) (ICounter, error)
+}
+
+// 文件后端，每个商品一个日志文件，使用持久化的分片计数器
+// 售出时只在分片上CAS扣减，每租用一批令牌才写一次日志
+type FileBackend struct {
+	dir string
+}
+
+func NewFileBackend(dir string) IBackend {
+	return &FileBackend{dir: dir}
+}
+
+const walPrefix = "product_"
+const walSuffix = ".wal"
+
+func (f *FileBackend) path(productID int64) string {
+	return filepath.Join(f.dir, walPrefix+strconv.FormatInt(productID, 10)+walSuffix)
+}
+
+func (f *FileBackend) Restore() (map[int64]ICounter, error) {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[int64]ICounter)
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, walPrefix) || !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		productID, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, walPrefix), walSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		counter, err := NewShardedFileCounter(f.path(productID), 0)
+		if err != nil {
+			return nil, err
+		}
+		counters[productID] = counter
+	}
+	return counters, nil
+}
+
+func (f *FileBackend) Create(productID int64, productNum int64) (ICounter, error) {
+	return NewShardedFileCounter(f.path(productID), productNum)
+}
+
+// 内存后端，使用分片无锁计数器，重启后数据丢失
+type MemoryBackend struct{}
+
+func NewMemoryBackend() IBackend {
+	return &MemoryBackend{}
+}
+
+func (m *MemoryBackend) Restore() (map[int64]ICounter, error) {
+	return make(map[int64]ICounter), nil
+}
+
+func (m *MemoryBackend) Create(productID int64, productNum int64) (ICounter, error) {
+	return NewShardedCounter(productNum), nil
+}
+
+// 按商品划分的库存池，每个商品拥有独立的计数器
+type Pools struct {
+	backend  IBackend
+	counters map[int64]ICounter
+	sync.RWMutex
+}
+
+// 创建库存池并恢复后端中已有的数据
+func NewPools(backend IBackend) (*Pools, error) {
+	counters, err := backend.Restore()
+	if err != nil {
+		return nil, err
+	}
+	return &Pools{backend: backend, counters: counters}, nil
+}
+
+// 获取商品计数器
+func (p *Pools) counter(productID int64) (ICounter, error) {
+	p.RLock()
+	defer p.RUnlock()
+	counter, ok := p.counters[productID]
+	if !ok {
+		return nil, ErrNotLoaded
+	}
+	return counter, nil
+}
+
+// 加载商品库存，已加载的商品保持原有数据不变
+func (p *Pools) Load(productID int64, productNum int64) (*Stat, error) {
+	p.Lock()
+	counter, ok := p.counters[productID]
+	if !ok {
+		var err error
+		counter, err = p.backend.Create(productID, productNum)
+		if err != nil {
+			p.Unlock()
+			return nil, err
+		}
+		p.counters[productID] = counter
+	}
+	p.Unlock()
+	return statOf(productID, counter), nil
+}
+
+// 补充库存
+func (p *Pools) TopUp(productID int64, num int64) (*Stat, error) {
+	if num <= 0 {
+		return nil, errors.New("补充数量必须大于0！")
+	}
+	counter, err := p.counter(productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := counter.Add(num); err != nil {
+		return nil, err
+	}
+	return statOf(productID, counter), nil
+}
+
+// 直接调整商品总数，供集群节点同步配额使用
+func (p *Pools) adjust(productID int64, delta int64) error {
+	counter, err := p.counter(productID)
+	if err != nil {
+		return err
+	}
+	return counter.Add(delta)
+}
+
+// 冻结或解冻库存
+func (p *Pools) Freeze(productID int64, frozen bool) (*Stat, error) {
+	counter, err := p.counter(productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := counter.SetFrozen(frozen); err != nil {
+		return nil, err
+	}
+	return statOf(productID, counter), nil
+}
+
+// 获取一件商品
+func (p *Pools) GetOne(productID int64) (bool, error) {
+	counter, err := p.counter(productID)
+	if err != nil {
+		return false, err
+	}
+	return counter.GetOne()
+}
+
+// 退回一件商品
+func (p *Pools) Release(productID int64) error {
+	counter, err := p.counter(productID)
+	if err != nil {
+		return err
+	}
+	return counter.Release()
+}
+
+// 批量获取商品，返回实际获取的数量
+func (p *Pools) GetN(productID int64, num int64) (int64, error) {
+	counter, err := p.counter(productID)
+	if err != nil {
+		return 0, err
+	}
+	return counter.GetN(num)
+}
+
+// 批量退回商品
+func (p *Pools) ReleaseN(productID int64, num int64) error {
+	counter, err := p.counter(productID)
+	if err != nil {
+		return err
+	}
+	return counter.ReleaseN(num)
+}
+
+// 已加载的全部商品ID
+func (p *Pools) ProductIDs() []int64 {
+	p.RLock()
+	defer p.RUnlock()
+	productIDs := make([]int64, 0, len(p.counters))
+	for productID := range p.counters {
+		productIDs = append(productIDs, productID)
+	}
+	return productIDs
+}
+
+// 查询库存池状态
+func (p *Pools) Stat(productID int64) (*Stat, error) {
+	counter, err := p.counter(productID)
+	if err != nil {
+		return nil, err
+	}
+	return statOf(productID, counter), nil
+}
+
+// 关闭全部计数器
+func (p *Pools) Close() {
+	p.Lock()
+	defer p.Unlock()
+	for _, counter := range p.counters {
+		counter.Close()
+	}
+}
+
+func statOf(productID int64, counter ICounter) *Stat {
+	return &Stat{
+		ProductID: productID,
+		Total:     counter.Total(),
+		Sum:       counter.Sum(),
+		Frozen:    counter.Frozen(),
+	}
+}
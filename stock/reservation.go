@@ -0,0 +1,180 @@
+package stock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrReservationNotFound = errors.New("预留不存在或已过期！")
+
+// 一次库存预留
+type Reservation struct {
+	ID        string
+	ProductID int64
+	// 超过该时间仍未确认则自动释放
+	Deadline time.Time
+}
+
+// 库存预留管理
+// 预留时即扣减库存，下游成功后确认，失败时释放；超时未确认的预留自动释放
+// 预留记录写入独立的预写日志，重启后恢复未完成的预留
+type Reservations struct {
+	pools *Pools
+	ttl   time.Duration
+	wal   *WAL
+	// 未完成的预留，key为预留ID
+	reserved map[string]*Reservation
+	done     chan struct{}
+	sync.Mutex
+}
+
+// 创建预留管理，ttl为预留的有效时间
+func NewReservations(pools *Pools, walPath string, ttl time.Duration) (*Reservations, error) {
+	wal, records, err := OpenWAL(walPath)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reservations{
+		pools:    pools,
+		ttl:      ttl,
+		wal:      wal,
+		reserved: make(map[string]*Reservation),
+		done:     make(chan struct{}),
+	}
+	for _, record := range records {
+		switch record.Op {
+		case OpReserve:
+			r.reserved[record.ID] = &Reservation{
+				ID:        record.ID,
+				ProductID: record.ProductID,
+				Deadline:  time.Unix(0, record.Deadline),
+			}
+		case OpConfirm, OpRelease:
+			delete(r.reserved, record.ID)
+		}
+	}
+	go r.expireLoop()
+	return r, nil
+}
+
+// 生成预留ID
+func newReservationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 预留一件商品，库存不足时返回nil
+func (r *Reservations) Reserve(productID int64) (*Reservation, error) {
+	isOk, err := r.pools.GetOne(productID)
+	if err != nil || !isOk {
+		return nil, err
+	}
+	id, err := newReservationID()
+	if err != nil {
+		r.pools.Release(productID)
+		return nil, err
+	}
+	reservation := &Reservation{ID: id, ProductID: productID, Deadline: time.Now().Add(r.ttl)}
+
+	r.Lock()
+	defer r.Unlock()
+	err = r.append(&Record{Op: OpReserve, ID: id, ProductID: productID, Deadline: reservation.Deadline.UnixNano()})
+	if err != nil {
+		// 预留未记录成功，立即退回库存
+		r.pools.Release(productID)
+		return nil, err
+	}
+	r.reserved[id] = reservation
+	return reservation, nil
+}
+
+// 确认预留，商品正式售出
+func (r *Reservations) Confirm(id string) error {
+	r.Lock()
+	defer r.Unlock()
+	reservation, ok := r.reserved[id]
+	if !ok {
+		return ErrReservationNotFound
+	}
+	if err := r.append(&Record{Op: OpConfirm, ID: id, ProductID: reservation.ProductID}); err != nil {
+		return err
+	}
+	delete(r.reserved, id)
+	return nil
+}
+
+// 释放预留，退回库存
+func (r *Reservations) Release(id string) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.release(id)
+}
+
+// 先记录日志再退回库存
+// 两步之间崩溃只会少卖一件，不会因为重复退回导致超卖
+func (r *Reservations) release(id string) error {
+	reservation, ok := r.reserved[id]
+	if !ok {
+		return ErrReservationNotFound
+	}
+	if err := r.append(&Record{Op: OpRelease, ID: id, ProductID: reservation.ProductID}); err != nil {
+		return err
+	}
+	delete(r.reserved, id)
+	return r.pools.Release(reservation.ProductID)
+}
+
+// 写入日志，记录过多时压缩为未完成的预留
+func (r *Reservations) append(record *Record) error {
+	if r.wal.Count() >= CompactThreshold {
+		records := make([]*Record, 0, len(r.reserved))
+		for _, v := range r.reserved {
+			records = append(records, &Record{Op: OpReserve, ID: v.ID, ProductID: v.ProductID, Deadline: v.Deadline.UnixNano()})
+		}
+		if err := r.wal.Rewrite(records); err != nil {
+			log.Println("压缩预留日志失败：", r.wal.path, err)
+		}
+	}
+	return r.wal.Append(record)
+}
+
+// 定时释放过期的预留
+func (r *Reservations) expireLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.expire(now)
+		}
+	}
+}
+
+func (r *Reservations) expire(now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	for id, reservation := range r.reserved {
+		if reservation.Deadline.Before(now) {
+			if err := r.release(id); err != nil {
+				log.Println("释放过期预留失败：", id, err)
+			}
+		}
+	}
+}
+
+// 停止过期检查并关闭日志
+func (r *Reservations) Close() error {
+	close(r.done)
+	r.Lock()
+	defer r.Unlock()
+	return r.wal.Close()
+}
@@ -0,0 +1,361 @@
+package stock
+
+import (
+	"log"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// 单个分片，填充到缓存行大小避免伪共享
+type stripe struct {
+	tokens int64
+	// 从该分片售出的数量，退回时扣减，保证退回数量不超过已售数量
+	sold int64
+	_    [48]byte
+}
+
+// 分片计数器
+// 库存以令牌的形式分散到多个分片，请求优先在当前P对应的分片上通过CAS扣减，
+// 分片为空时加锁从全局剩余令牌中批量租用，全局也为空时从其它分片窃取。
+// 令牌总数恒等于 商品总数-已售数量，因此不会超卖。
+// 使用预写日志时，租给分片的令牌先作为已售写入日志，售出时不再落盘，
+// 崩溃后分片中未售出的令牌不会再售出，最多少卖 分片数*每批数量，不会超卖；正常关闭时归还未售出的令牌
+type ShardedCounter struct {
+	stripes []stripe
+	// 尚未租给分片的令牌，只在mu保护下修改，可能因减少配额而为负数
+	remain int64
+	// 商品总数，只在mu保护下修改
+	total int64
+	// 每次补充到分片的令牌数量
+	batch  int64
+	frozen int32
+	// 每个P缓存一个分片下标，减少不同CPU之间的竞争
+	hint sync.Pool
+	next uint32
+	// 为nil时数据只保存在内存中
+	wal *WAL
+	// 租用令牌、退回和修改库存时加锁，售出时不加锁
+	mu sync.Mutex
+}
+
+// 每次租用的令牌数量上限，限制崩溃时少卖的数量
+const maxLeaseBatch = 64
+
+// 创建内存分片计数器，重启后数据丢失
+func NewShardedCounter(productNum int64) ICounter {
+	return newShardedCounter(productNum, 0, false, nil)
+}
+
+// 打开持久化的分片计数器，与文件计数器使用相同的日志格式
+// 日志中已有商品总数时以日志为准，productNum只在首次创建时生效
+func NewShardedFileCounter(path string, productNum int64) (ICounter, error) {
+	wal, state, err := openCounterWAL(path, productNum)
+	if err != nil {
+		return nil, err
+	}
+	return newShardedCounter(state.productNum, state.sum, state.frozen, wal), nil
+}
+
+func newShardedCounter(productNum int64, sold int64, frozen bool, wal *WAL) *ShardedCounter {
+	s := &ShardedCounter{
+		stripes: make([]stripe, runtime.GOMAXPROCS(0)*4),
+		remain:  productNum - sold,
+		total:   productNum,
+		wal:     wal,
+	}
+	s.stripes[0].sold = sold
+	if frozen {
+		s.frozen = 1
+	}
+	s.batch = s.leaseBatch(productNum)
+	s.hint.New = func() interface{} {
+		idx := int(atomic.AddUint32(&s.next, 1)) % len(s.stripes)
+		return &idx
+	}
+	return s
+}
+
+// 按商品总数计算每次租用的令牌数量
+func (s *ShardedCounter) leaseBatch(total int64) int64 {
+	batch := total / int64(len(s.stripes)*16)
+	if batch < 1 {
+		batch = 1
+	}
+	if batch > maxLeaseBatch {
+		batch = maxLeaseBatch
+	}
+	return batch
+}
+
+// 获取当前P对应的分片下标
+func (s *ShardedCounter) stripeIndex() int {
+	idx := s.hint.Get().(*int)
+	i := *idx
+	s.hint.Put(idx)
+	return i
+}
+
+// 从分片中扣减一个令牌
+func (s *ShardedCounter) take(i int) bool {
+	tokens := &s.stripes[i].tokens
+	for {
+		t := atomic.LoadInt64(tokens)
+		if t <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(tokens, t, t-1) {
+			atomic.AddInt64(&s.stripes[i].sold, 1)
+			return true
+		}
+	}
+}
+
+// 从全局剩余令牌中租用一批补充分片，同时为本次请求留下一个令牌
+func (s *ShardedCounter) refill(i int) (bool, error) {
+	if atomic.LoadInt64(&s.remain) <= 0 {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.batch
+	if n > s.remain {
+		n = s.remain
+	}
+	if n <= 0 {
+		return false, nil
+	}
+	// 先写日志再交给分片，写入失败则本次不租用
+	if err := s.append(&Record{Op: OpGet, Num: n}); err != nil {
+		return false, err
+	}
+	atomic.StoreInt64(&s.remain, s.remain-n)
+	if n > 1 {
+		atomic.AddInt64(&s.stripes[i].tokens, n-1)
+	}
+	atomic.AddInt64(&s.stripes[i].sold, 1)
+	return true, nil
+}
+
+// 直接从全局剩余令牌中获取最多num件，只写一条日志
+func (s *ShardedCounter) lease(i int, num int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := num
+	if n > s.remain {
+		n = s.remain
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	if err := s.append(&Record{Op: OpGet, Num: n}); err != nil {
+		return 0, err
+	}
+	atomic.StoreInt64(&s.remain, s.remain-n)
+	atomic.AddInt64(&s.stripes[i].sold, n)
+	return n, nil
+}
+
+// 从其它分片窃取一个令牌
+func (s *ShardedCounter) steal(i int) bool {
+	for j := 1; j < len(s.stripes); j++ {
+		if s.take((i + j) % len(s.stripes)) {
+			return true
+		}
+	}
+	return false
+}
+
+// 收回分片中最多num个未售出的令牌，返回收回的数量
+func (s *ShardedCounter) drain(num int64) int64 {
+	var drained int64
+	for i := range s.stripes {
+		tokens := &s.stripes[i].tokens
+		for drained < num {
+			t := atomic.LoadInt64(tokens)
+			if t <= 0 {
+				break
+			}
+			n := num - drained
+			if n > t {
+				n = t
+			}
+			if atomic.CompareAndSwapInt64(tokens, t, t-n) {
+				drained += n
+			}
+		}
+	}
+	return drained
+}
+
+func (s *ShardedCounter) GetOne() (bool, error) {
+	if atomic.LoadInt32(&s.frozen) == 1 {
+		return false, nil
+	}
+	i := s.stripeIndex()
+	if s.take(i) {
+		return true, nil
+	}
+	isOk, err := s.refill(i)
+	if isOk {
+		return true, nil
+	}
+	// 全局令牌已用完或日志写入失败，从其它分片窃取已租用的令牌
+	if s.steal(i) {
+		return true, nil
+	}
+	return false, err
+}
+
+func (s *ShardedCounter) Release() error {
+	return s.ReleaseN(1)
+}
+
+// 批量获取时直接从全局租用，不足时再从分片窃取
+func (s *ShardedCounter) GetN(num int64) (int64, error) {
+	if num <= 0 || atomic.LoadInt32(&s.frozen) == 1 {
+		return 0, nil
+	}
+	i := s.stripeIndex()
+	got, err := s.lease(i, num)
+	if err != nil {
+		return 0, err
+	}
+	for got < num && s.steal(i) {
+		got++
+	}
+	return got, nil
+}
+
+// 先通过CAS扣减各分片的已售数量，扣减成功后才归还令牌，并发退回也不会超过已售数量
+func (s *ShardedCounter) ReleaseN(num int64) error {
+	if !s.unsell(num) {
+		return errNothingSold
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(&Record{Op: OpRelease, Num: num}); err != nil {
+		atomic.AddInt64(&s.stripes[0].sold, num)
+		return err
+	}
+	atomic.StoreInt64(&s.remain, s.remain+num)
+	return nil
+}
+
+// 从各分片扣减共num件已售数量，不足时恢复已扣减的数量并返回false
+func (s *ShardedCounter) unsell(num int64) bool {
+	var taken int64
+	for i := range s.stripes {
+		sold := &s.stripes[i].sold
+		for taken < num {
+			v := atomic.LoadInt64(sold)
+			if v <= 0 {
+				break
+			}
+			n := num - taken
+			if n > v {
+				n = v
+			}
+			if atomic.CompareAndSwapInt64(sold, v, v-n) {
+				taken += n
+			}
+		}
+		if taken == num {
+			return true
+		}
+	}
+	atomic.AddInt64(&s.stripes[0].sold, taken)
+	return false
+}
+
+// 已售数量，并发修改时为近似值
+func (s *ShardedCounter) Sum() int64 {
+	var sum int64
+	for i := range s.stripes {
+		sum += atomic.LoadInt64(&s.stripes[i].sold)
+	}
+	return sum
+}
+
+func (s *ShardedCounter) Total() int64 {
+	return atomic.LoadInt64(&s.total)
+}
+
+// 调整商品总数，num为负数时先扣减全局剩余令牌，不足时收回分片中未售出的令牌
+func (s *ShardedCounter) Add(num int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	remain := s.remain + num
+	var drained int64
+	if remain < 0 {
+		drained = s.drain(-remain)
+	}
+	records := []*Record{{Op: OpAdd, Num: num}}
+	if drained > 0 {
+		// 收回的令牌已作为已售写入日志，需要一并退回
+		records = append(records, &Record{Op: OpRelease, Num: drained})
+	}
+	if err := s.appendBatch(records...); err != nil {
+		atomic.AddInt64(&s.stripes[0].tokens, drained)
+		return err
+	}
+	atomic.StoreInt64(&s.total, s.total+num)
+	atomic.StoreInt64(&s.remain, remain+drained)
+	s.batch = s.leaseBatch(s.total)
+	return nil
+}
+
+func (s *ShardedCounter) SetFrozen(frozen bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var v int32
+	if frozen {
+		v = 1
+	}
+	if err := s.append(&Record{Op: OpFreeze, Num: int64(v)}); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&s.frozen, v)
+	return nil
+}
+
+func (s *ShardedCounter) Frozen() bool {
+	return atomic.LoadInt32(&s.frozen) == 1
+}
+
+// 关闭时归还分片中未售出的令牌，重启后可以继续售卖
+func (s *ShardedCounter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return nil
+	}
+	if drained := s.drain(math.MaxInt64); drained > 0 {
+		if err := s.append(&Record{Op: OpRelease, Num: drained}); err != nil {
+			log.Println("归还未售出的令牌失败：", s.wal.path, err)
+		}
+		atomic.StoreInt64(&s.remain, s.remain+drained)
+	}
+	return s.wal.Close()
+}
+
+// 写入日志，调用方需持有mu
+func (s *ShardedCounter) append(record *Record) error {
+	return s.appendBatch(record)
+}
+
+// 写入日志，记录过多时先压缩为当前状态的快照，调用方需持有mu
+// 租给分片的令牌在日志中都记为已售，因此快照中的已售数量为 商品总数-全局剩余令牌
+func (s *ShardedCounter) appendBatch(records ...*Record) error {
+	if s.wal == nil {
+		return nil
+	}
+	if s.wal.Count() >= CompactThreshold {
+		snapshot := counterSnapshot(s.total, s.total-s.remain, atomic.LoadInt32(&s.frozen) == 1)
+		if err := s.wal.Rewrite(snapshot); err != nil {
+			log.Println("压缩库存日志失败：", s.wal.path, err)
+		}
+	}
+	return s.wal.AppendBatch(records...)
+}
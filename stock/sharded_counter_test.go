@@ -0,0 +1,128 @@
+package stock
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 每个P上的goroutine倍数
+var parallelisms = []int{1, 8, 64, 512}
+
+// 压测吞吐，库存足够大，保证每次都走成功路径
+func benchmarkCounter(b *testing.B, newCounter func(b *testing.B) ICounter) {
+	for _, parallelism := range parallelisms {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			counter := newCounter(b)
+			defer counter.Close()
+			b.SetParallelism(parallelism)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					counter.GetOne()
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMutexCounter(b *testing.B) {
+	benchmarkCounter(b, func(b *testing.B) ICounter {
+		return NewMemoryCounter(1 << 62)
+	})
+}
+
+func BenchmarkShardedCounter(b *testing.B) {
+	benchmarkCounter(b, func(b *testing.B) ICounter {
+		return NewShardedCounter(1 << 62)
+	})
+}
+
+func BenchmarkShardedFileCounter(b *testing.B) {
+	benchmarkCounter(b, func(b *testing.B) ICounter {
+		counter, err := NewShardedFileCounter(filepath.Join(b.TempDir(), "product.wal"), 1<<62)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return counter
+	})
+}
+
+// 大量goroutine抢购有限库存，同时并发退回，售出数量不能超过库存
+func TestShardedCounterNeverOversells(t *testing.T) {
+	const (
+		productNum = 10000
+		goroutines = 2000
+		releases   = 500
+	)
+	counters := map[string]func(t *testing.T) ICounter{
+		"memory": func(t *testing.T) ICounter {
+			return NewShardedCounter(productNum)
+		},
+		"file": func(t *testing.T) ICounter {
+			counter, err := NewShardedFileCounter(filepath.Join(t.TempDir(), "product.wal"), productNum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return counter
+		},
+	}
+	for name, newCounter := range counters {
+		t.Run(name, func(t *testing.T) {
+			counter := newCounter(t)
+			defer counter.Close()
+			var (
+				wg       sync.WaitGroup
+				success  int64
+				released int64
+			)
+			for i := 0; i < goroutines; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for {
+						isOk, err := counter.GetOne()
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if !isOk {
+							break
+						}
+						atomic.AddInt64(&success, 1)
+						// 部分请求退回后重新抢购
+						if i < releases && counter.Release() == nil {
+							atomic.AddInt64(&released, 1)
+							i = releases
+						}
+					}
+				}(i)
+			}
+			// 没有售出的数量时并发退回必须失败
+			for i := 0; i < releases; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if counter.Release() == nil {
+						atomic.AddInt64(&released, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			// 抢购结束后退回的商品重新售出
+			for {
+				isOk, _ := counter.GetOne()
+				if !isOk {
+					break
+				}
+				success++
+			}
+			sold := success - released
+			if sold != productNum || counter.Sum() != productNum {
+				t.Fatalf("库存%d，成功%d，退回%d，已售%d", productNum, success, released, counter.Sum())
+			}
+		})
+	}
+}
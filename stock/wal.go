@@ -0,0 +1,227 @@
+package stock
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 日志操作类型
+const (
+	// 初始化商品总数
+	OpInit = "init"
+	// 售出商品
+	OpGet = "get"
+	// 补充库存
+	OpAdd = "add"
+	// 冻结库存，Num为1表示冻结，0表示解冻
+	OpFreeze = "freeze"
+	// 退回商品或释放预留
+	OpRelease = "release"
+	// 预留商品
+	OpReserve = "reserve"
+	// 确认预留
+	OpConfirm = "confirm"
+)
+
+// 预写日志中的一条记录，每条记录占一行json
+type Record struct {
+	Op  string `json:"op"`
+	Num int64  `json:"num"`
+	// 以下字段仅预留日志使用
+	ID        string `json:"id,omitempty"`
+	ProductID int64  `json:"productID,omitempty"`
+	// 预留过期时间，unix纳秒
+	Deadline int64 `json:"deadline,omitempty"`
+}
+
+// 预写日志(write-ahead log)
+// 每次变更先追加写入并落盘，再修改内存数据，重启时通过重放日志恢复
+type WAL struct {
+	path string
+	file walFile
+	// 当前日志中的记录数量
+	count int
+	// 已完整写入的数据长度，写入失败时截断到该位置
+	size int64
+	// 日志不可用的原因，重写后无法重新打开时设置，之后的追加全部失败
+	failed error
+	sync.Mutex
+}
+
+var errWALFailed = errors.New("预写日志不可用")
+
+// 日志文件，测试时替换为模拟写入失败的实现
+type walFile interface {
+	Write(b []byte) (int, error)
+	Sync() error
+	Truncate(size int64) error
+	Seek(offset int64, whence int) (int64, error)
+	Close() error
+}
+
+// 打开预写日志，返回已有的全部记录
+// 崩溃时最后一行可能只写了一半，读取到无法解析的记录时截断日志
+func OpenWAL(path string) (*WAL, []*Record, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		records []*Record
+		offset  int64
+	)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// 没有换行符的数据属于未写完的记录
+			break
+		}
+		record := &Record{}
+		if err := json.Unmarshal(line, record); err != nil {
+			break
+		}
+		records = append(records, record)
+		offset += int64(len(line))
+	}
+	// 丢弃损坏的尾部数据
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(offset, 0); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return &WAL{path: path, file: file, count: len(records), size: offset}, records, nil
+}
+
+// 追加一条记录并同步到磁盘
+func (w *WAL) Append(record *Record) error {
+	return w.AppendBatch(record)
+}
+
+// 追加多条记录，只同步一次磁盘
+// 崩溃时可能只写入了前几条，调用方需保证任意前缀重放后都不会超卖
+func (w *WAL) AppendBatch(records ...*Record) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.failed != nil {
+		return w.failed
+	}
+	var buf []byte
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+	if _, err := w.file.Write(buf); err != nil {
+		return w.rollback(err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return w.rollback(err)
+	}
+	w.count += len(records)
+	w.size += int64(len(buf))
+	return nil
+}
+
+// 写入失败时截断掉可能只写了一半的数据，否则之后追加的记录跟在损坏的行后面，
+// 重放时在损坏处截断会丢掉之后所有的记录。截断失败时标记日志不可用
+func (w *WAL) rollback(err error) error {
+	if terr := w.file.Truncate(w.size); terr != nil {
+		w.failed = fmt.Errorf("%w：写入失败后无法截断：%v", errWALFailed, terr)
+		return w.failed
+	}
+	if _, serr := w.file.Seek(w.size, 0); serr != nil {
+		w.failed = fmt.Errorf("%w：写入失败后无法定位：%v", errWALFailed, serr)
+		return w.failed
+	}
+	return err
+}
+
+// 记录数量
+func (w *WAL) Count() int {
+	w.Lock()
+	defer w.Unlock()
+	return w.count
+}
+
+// 使用快照记录重写日志，用于压缩日志体积
+// 先写临时文件再重命名，保证任意时刻崩溃都有一份完整日志
+// 重命名后无法重新打开时，原文件句柄已指向被替换的文件，继续追加会丢数据，因此标记日志不可用
+func (w *WAL) Rewrite(records []*Record) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.failed != nil {
+		return w.failed
+	}
+	tmpPath := w.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	var size int64
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		writer.Write(data)
+		writer.WriteByte('\n')
+		size += int64(len(data)) + 1
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(w.path))
+
+	// 重新打开新日志用于追加
+	file, err := os.OpenFile(w.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		w.failed = fmt.Errorf("%w：重写后重新打开失败：%v", errWALFailed, err)
+		return w.failed
+	}
+	w.file.Close()
+	w.file = file
+	w.count = len(records)
+	w.size = size
+	return nil
+}
+
+// 关闭日志
+func (w *WAL) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	return w.file.Close()
+}
+
+// 同步目录，保证重命名操作落盘
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,99 @@
+package stock
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// 模拟磁盘写满等情况，只写入一部分数据后返回错误
+type partialFile struct {
+	walFile
+	fail bool
+}
+
+func (f *partialFile) Write(b []byte) (int, error) {
+	if !f.fail {
+		return f.walFile.Write(b)
+	}
+	f.fail = false
+	n, _ := f.walFile.Write(b[:len(b)/2])
+	return n, errors.New("no space left on device")
+}
+
+// 写入失败后截断半条记录，之后追加的记录重放时不会丢失
+func TestWALPartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter.wal")
+	wal, _, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &partialFile{walFile: wal.file}
+	wal.file = file
+	if err := wal.Append(&Record{Op: OpInit, Num: 100}); err != nil {
+		t.Fatal(err)
+	}
+	file.fail = true
+	if err := wal.Append(&Record{Op: OpGet, Num: 1}); err == nil {
+		t.Fatal("部分写入没有返回错误")
+	}
+	for i := 0; i < 3; i++ {
+		if err := wal.Append(&Record{Op: OpGet, Num: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wal.Count() != 4 {
+		t.Fatalf("记录数量为%d，应为4", wal.Count())
+	}
+	wal.Close()
+
+	_, records, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("重放得到%d条记录，应为4", len(records))
+	}
+	var sold int64
+	for _, record := range records[1:] {
+		if record.Op != OpGet {
+			t.Fatalf("重放得到%s记录", record.Op)
+		}
+		sold += record.Num
+	}
+	if sold != 3 {
+		t.Fatalf("重放后已售%d，应为3", sold)
+	}
+}
+
+// 重写后的日志写入失败同样截断
+func TestWALPartialWriteAfterRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter.wal")
+	wal, _, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		wal.Append(&Record{Op: OpGet, Num: 1})
+	}
+	if err := wal.Rewrite([]*Record{{Op: OpInit, Num: 100}, {Op: OpGet, Num: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	file := &partialFile{walFile: wal.file, fail: true}
+	wal.file = file
+	if err := wal.Append(&Record{Op: OpGet, Num: 1}); err == nil {
+		t.Fatal("部分写入没有返回错误")
+	}
+	if err := wal.Append(&Record{Op: OpGet, Num: 2}); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	_, records, err := OpenWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[2].Num != 2 {
+		t.Fatalf("重放得到%d条记录，应为3", len(records))
+	}
+}
@@ -1,38 +1,195 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"imoc-product/common"
 	"imoc-product/datamodels"
 	"imoc-product/encrypt"
 	"imoc-product/rabbitmq"
+	"imoc-product/repositories"
+	"imoc-product/rpc"
+	"imoc-product/services"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// 设置集群地址 最好内网IP
-var hostArray = []string{"172.20.32.184"}
+// 验证服务配置
+// 优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+type ValidateConfig struct {
+	// 监听端口
+	Port string `json:"port"`
+	// 本机在集群中的地址，为空时使用内网IP和监听端口
+	LocalHost string `json:"localHost"`
+	// 验证集群节点地址 ip:port，最好内网IP，未写端口时使用监听端口
+	Hosts []string `json:"hosts"`
+	// 数量控制集群售卖节点的内网地址，单机部署时只配置一个
+	GetOneHosts []string `json:"getOneHosts"`
+	// 节点健康检查间隔
+	HealthInterval string `json:"healthInterval"`
+	// 一致性hash函数 crc32/fnv/murmur3/xxhash，所有节点必须一致
+	HashFunc string `json:"hashFunc"`
+	// 每个权重单位的虚拟节点个数
+	VirtualNode int `json:"virtualNode"`
+	// 节点权重，机器配置不同时按权重分配用户，未配置的节点权重为1
+	Weights map[string]int `json:"weights"`
+	// 每个用户的访问记录和黑名单保存的节点数，包括所属节点
+	Replicas int `json:"replicas"`
+	// 节点间RPC调用的超时时间
+	ProxyTimeout string `json:"proxyTimeout"`
+	// 节点连续失败多少次后熔断
+	BreakerThreshold int `json:"breakerThreshold"`
+	// 熔断后多久放行探测请求
+	BreakerCooldown string `json:"breakerCooldown"`
+	// 从数据库同步黑名单的间隔
+	BlacklistSync string `json:"blacklistSync"`
+	// 是否从X-Forwarded-For获取客户端IP，只在部署于可信的反向代理之后时开启
+	TrustForwarded bool `json:"trustForwarded"`
+	// 异常请求检测阈值，超过阈值的用户和IP自动拉黑
+	Detector common.DetectorConfig `json:"detector"`
+	// 按路由配置的令牌桶限流，key为路径，未配置的路由不限流
+	RateLimits map[string]common.RouteRateLimitConfig `json:"rateLimits"`
+}
+
+var config = &ValidateConfig{
+	Port:             "8083",
+	Hosts:            []string{"172.20.32.184"},
+	GetOneHosts:      []string{"127.0.0.1:8084"},
+	HealthInterval:   "2s",
+	HashFunc:         "crc32",
+	VirtualNode:      20,
+	Replicas:         2,
+	ProxyTimeout:     "500ms",
+	BreakerThreshold: 5,
+	BreakerCooldown:  "5s",
+	BlacklistSync:    "30s",
+	Detector:         common.DefaultDetectorConfig(),
+	RateLimits: map[string]common.RouteRateLimitConfig{
+		"/check": {
+			Global:  common.RateLimitConfig{Rate: 2000, Burst: 4000},
+			IP:      common.RateLimitConfig{Rate: 5, Burst: 10},
+			User:    common.RateLimitConfig{Rate: 1, Burst: 3},
+			Product: common.RateLimitConfig{Rate: 1000, Burst: 2000},
+		},
+		"/checkRight": {
+			Global: common.RateLimitConfig{Rate: 5000, Burst: 10000},
+			IP:     common.RateLimitConfig{Rate: 10, Burst: 20},
+			User:   common.RateLimitConfig{Rate: 2, Burst: 5},
+		},
+	},
+}
+
+var (
+	configFile     = flag.String("config", "./validate.json", "配置文件，不存在时忽略")
+	portFlag       = flag.String("port", "", "监听端口，环境变量VALIDATE_PORT")
+	localFlag      = flag.String("local", "", "本机在集群中的地址，环境变量VALIDATE_LOCAL")
+	hostsFlag      = flag.String("hosts", "", "验证集群节点，逗号分隔，环境变量VALIDATE_HOSTS")
+	getOneFlag     = flag.String("getOne", "", "数量控制售卖节点，逗号分隔，环境变量VALIDATE_GETONE_HOSTS")
+	healthInterval = flag.String("healthInterval", "", "节点健康检查间隔，环境变量VALIDATE_HEALTH_INTERVAL")
+	hashFuncFlag   = flag.String("hash", "", "一致性hash函数，环境变量VALIDATE_HASH")
+)
+
+// 按优先级合并配置
+func loadConfig() error {
+	data, err := ioutil.ReadFile(*configFile)
+	if err == nil {
+		if err = json.Unmarshal(data, config); err != nil {
+			return fmt.Errorf("配置文件格式错误：%w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	override := func(value *string, env string, flagValue string) {
+		if v := os.Getenv(env); v != "" {
+			*value = v
+		}
+		if flagValue != "" {
+			*value = flagValue
+		}
+	}
+	overrideList := func(value *[]string, env string, flagValue string) {
+		list := ""
+		override(&list, env, flagValue)
+		if list != "" {
+			*value = splitList(list)
+		}
+	}
+	override(&config.Port, "VALIDATE_PORT", *portFlag)
+	override(&config.LocalHost, "VALIDATE_LOCAL", *localFlag)
+	override(&config.HealthInterval, "VALIDATE_HEALTH_INTERVAL", *healthInterval)
+	override(&config.HashFunc, "VALIDATE_HASH", *hashFuncFlag)
+	overrideList(&config.Hosts, "VALIDATE_HOSTS", *hostsFlag)
+	overrideList(&config.GetOneHosts, "VALIDATE_GETONE_HOSTS", *getOneFlag)
+	if len(config.GetOneHosts) == 0 {
+		return errors.New("至少需要配置一个数量控制节点")
+	}
+	for i, host := range config.Hosts {
+		config.Hosts[i] = withPort(host, config.Port)
+	}
+	weights := make(map[string]int, len(config.Weights))
+	for host, weight := range config.Weights {
+		weights[withPort(host, config.Port)] = weight
+	}
+	config.Weights = weights
+	return nil
+}
 
+// 拆分逗号分隔的列表
+func splitList(list string) []string {
+	var result []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// 地址未写端口时补上默认端口
+func withPort(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// 本机在集群中的地址 ip:port
 var localHost = ""
 
-// 数量控制接口服务器内网IP或者getOne的SLB内网IP
-var GetOneIp = "127.0.0.1"
+// 集群成员，节点失效时从hash环中移除
+var membership *common.Membership
 
-var GetOnePort = "8084"
+// 轮询售卖节点的游标
+var getOneCursor uint32
 
-var port = "8083"
+// 确认预留的重试次数
+var confirmRetry = 3
 
 var hashConsistent *common.Consistent
 
 // rabbitmq
 var rabbitMqValidate *rabbitmq.RabbitMQ
 
+// 秒杀活动，只在活动时间内接受下单
+var campaignService services.ICampaignService
+
+// 秒杀令牌，由前端在活动开始后发放
+var seckillTokenService services.ISeckillTokenService
+
+// 用户购买记录，下单前检查是否超过限购数量
+var purchaseService services.IPurchaseService
+
 // 用来存放控制信息
 type AccessControl struct {
 	// 用来存放用户想要存放的信息
@@ -53,67 +210,487 @@ func (m *AccessControl) GetNewRecord(uid int) time.Time {
 }
 
 // 设置记录
-func (m *AccessControl) SetNewRecord(uid int) {
+func (m *AccessControl) SetNewRecord(uid int) time.Time {
 	m.RWMutex.Lock()
 	defer m.RWMutex.Unlock()
-	m.sourceArray[uid] = time.Now()
+	now := time.Now()
+	m.sourceArray[uid] = now
+	return now
 }
 
-// 黑名单
+// 合并副本记录，保留较新的访问时间
+func (m *AccessControl) MergeRecord(uid int, record time.Time) {
+	m.RWMutex.Lock()
+	defer m.RWMutex.Unlock()
+	if record.After(m.sourceArray[uid]) {
+		m.sourceArray[uid] = record
+	}
+}
+
+// 返回未过期的访问记录，并清理过期记录
+func (m *AccessControl) Records() map[int]time.Time {
+	m.RWMutex.Lock()
+	defer m.RWMutex.Unlock()
+	expire := time.Now().Add(-time.Duration(interval) * time.Second)
+	records := make(map[int]time.Time, len(m.sourceArray))
+	for uid, record := range m.sourceArray {
+		if record.Before(expire) {
+			delete(m.sourceArray, uid)
+			continue
+		}
+		records[uid] = record
+	}
+	return records
+}
+
+// 黑名单记录
+type BlackEntry struct {
+	Reason string
+	// 过期时间，零值表示永久有效
+	Expire time.Time
+	// 本机最后修改的时间，同步数据库时只移除同步开始前修改的记录
+	updated time.Time
+}
+
+// 记录在t时刻是否有效
+func (e *BlackEntry) Active(t time.Time) bool {
+	return e.Expire.IsZero() || t.Before(e.Expire)
+}
+
+// 黑名单，以数据库为准，本机只保存hash环上属于自己和作为副本的用户
 type BlackList struct {
-	listArray map[int]bool
+	listArray map[int]*BlackEntry
 	sync.RWMutex
 }
 
-var blackList = &BlackList{listArray: make(map[int]bool)}
+var blackList = &BlackList{listArray: make(map[int]*BlackEntry)}
+
+// 黑名单，持久化到数据库并通知验证集群
+var blacklistService services.IBlacklistService
 
 // 获取黑名单
 func (m *BlackList) GetBlackListByID(uid int) bool {
 	m.RLock()
 	defer m.RUnlock()
-	return m.listArray[uid]
+	entry, ok := m.listArray[uid]
+	return ok && entry.Active(time.Now())
+}
+
+// 添加黑名单，ttl<=0为永久有效
+// 先写入数据库，再按hash环同步到用户所属的节点和副本节点
+func (m *BlackList) SetBlackListByID(uid int, reason string, ttl time.Duration, operator string) error {
+	_, err := blacklistService.Add(int64(uid), reason, ttl, operator)
+	return err
+}
+
+// 合并其他节点同步的黑名单
+func (m *BlackList) MergeBlackList(uid int, reason string, expire time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	m.listArray[uid] = &BlackEntry{Reason: reason, Expire: expire, updated: time.Now()}
+}
+
+// 移出黑名单
+func (m *BlackList) RemoveBlackList(uid int) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.listArray, uid)
+}
+
+// 有效的黑名单记录，并清理过期记录
+func (m *BlackList) Entries() map[int]BlackEntry {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	entries := make(map[int]BlackEntry, len(m.listArray))
+	for uid, entry := range m.listArray {
+		if !entry.Active(now) {
+			delete(m.listArray, uid)
+			continue
+		}
+		entries[uid] = *entry
+	}
+	return entries
 }
 
-// 添加黑名单
-func (m *BlackList) SetBlackListByID(uid int) bool {
+// 与数据库中有效的黑名单对齐，owned判断用户是否由本机负责
+// since之后修改的记录可能还没有写入查询结果，不移除
+func (m *BlackList) Sync(active []*datamodels.Blacklist, owned func(uid int) bool, since time.Time) {
 	m.Lock()
 	defer m.Unlock()
-	m.listArray[uid] = true
-	return true
+	keep := make(map[int]bool, len(active))
+	for _, blacklist := range active {
+		uid := int(blacklist.UserID)
+		if !owned(uid) {
+			continue
+		}
+		keep[uid] = true
+		if entry, ok := m.listArray[uid]; ok && entry.updated.After(since) {
+			continue
+		}
+		m.listArray[uid] = &BlackEntry{Reason: blacklist.Reason, Expire: blacklist.ExpireTime, updated: since}
+	}
+	for uid, entry := range m.listArray {
+		if !keep[uid] && !entry.updated.After(since) {
+			delete(m.listArray, uid)
+		}
+	}
+}
+
+// 异常请求检测
+var detector *common.BotDetector
+
+// 自动拉黑的操作人
+const detectorOperator = "detector"
+
+// 用户触发检测规则，写入黑名单并同步到集群
+// 数据库不可用时先在本机生效，数据库恢复后的同步会移除
+func banUser(uid int, offense *common.Offense) {
+	fmt.Println("检测到异常用户，自动拉黑：", offense)
+	err := blackList.SetBlackListByID(uid, offense.String(), detector.BanTTL, detectorOperator)
+	if err == nil {
+		return
+	}
+	fmt.Println("写入黑名单失败，只在本机生效：", uid, err)
+	var expire time.Time
+	if detector.BanTTL > 0 {
+		expire = time.Now().Add(detector.BanTTL)
+	}
+	blackList.MergeBlackList(uid, offense.String(), expire)
+}
+
+// IP触发检测规则，在本机封禁并写入审计记录
+func banIP(ip string, offense *common.Offense) {
+	fmt.Println("检测到异常IP，自动封禁：", offense)
+	detector.BanIP(ip, detector.BanTTL)
+	if err := blacklistService.AuditBanIP(ip, offense.String(), detectorOperator); err != nil {
+		fmt.Println("写入IP封禁审计记录失败：", ip, err)
+	}
+}
+
+// 定期从数据库同步黑名单，补上通知失败和成员变化期间遗漏的修改
+func syncBlacklist(interval time.Duration) {
+	for {
+		since := time.Now()
+		active, err := blacklistService.GetActive()
+		if err != nil {
+			fmt.Println("同步黑名单失败：", err)
+		} else {
+			blackList.Sync(active, func(uid int) bool {
+				nodes, _ := hashConsistent.GetN(strconv.Itoa(uid), config.Replicas)
+				for _, node := range nodes {
+					if node == localHost {
+						return true
+					}
+				}
+				return false
+			}, since)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// 把黑名单变化发送给用户所属的节点和副本节点，本机在其中时直接修改
+func routeBlacklist(request *rpc.BlacklistRequest) error {
+	uid := int(request.UID)
+	nodes, err := hashConsistent.GetN(strconv.Itoa(uid), config.Replicas)
+	if err != nil {
+		return err
+	}
+	record := ReplicaRecord{UID: uid, Black: !request.Remove, BlackReason: request.Reason,
+		BlackExpire: request.Expire, Unblack: request.Remove}
+	var lastErr error
+	for _, node := range nodes {
+		if node == localHost {
+			applyReplica(record)
+			continue
+		}
+		if err := replicator.Push(node, []ReplicaRecord{record}); err != nil {
+			fmt.Println("同步黑名单失败：", node, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// 本机产生的黑名单变化，通过hash环发送给负责的节点
+type ringBlacklistNotifier struct{}
+
+func (ringBlacklistNotifier) NotifyBlacklist(blacklist *datamodels.Blacklist, removed bool) error {
+	return routeBlacklist(rpc.NewBlacklistRequest(blacklist, removed))
+}
+
+// 同步给副本节点的用户记录
+type ReplicaRecord struct {
+	UID int `json:"uid"`
+	// 最近访问时间，纳秒时间戳，0为没有记录
+	Access int64 `json:"access,omitempty"`
+	Black  bool  `json:"black,omitempty"`
+	// 黑名单原因和过期时间，过期时间为纳秒时间戳，0为永久有效
+	BlackReason string `json:"blackReason,omitempty"`
+	BlackExpire int64  `json:"blackExpire,omitempty"`
+	// 移出黑名单
+	Unblack bool `json:"unblack,omitempty"`
+}
+
+// 节点间同步副本的签名密钥，启动时从环境变量VALIDATE_REPLICA_SECRET读取
+var replicaSecret string
+
+// 副本同步请求的有效期
+var replicaMaxAge = time.Minute
+
+// 副本同步，写操作先放入队列，按节点批量异步发送
+type Replicator struct {
+	queue  chan replicaTask
+	client *http.Client
+	// 批量发送的间隔和数量
+	flushInterval time.Duration
+	batchSize     int
+}
+
+type replicaTask struct {
+	host   string
+	record ReplicaRecord
+}
+
+func NewReplicator(queueSize int) *Replicator {
+	r := &Replicator{
+		queue:         make(chan replicaTask, queueSize),
+		client:        &http.Client{Timeout: time.Second},
+		flushInterval: 100 * time.Millisecond,
+		batchSize:     200,
+	}
+	go r.run()
+	return r
+}
+
+// 放入发送队列，队列满时丢弃，由成员变化时的副本修复兜底
+func (r *Replicator) Send(host string, record ReplicaRecord) {
+	select {
+	case r.queue <- replicaTask{host: host, record: record}:
+	default:
+		fmt.Println("副本同步队列已满，丢弃：", host, record.UID)
+	}
+}
+
+func (r *Replicator) run() {
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+	batches := make(map[string][]ReplicaRecord)
+	flush := func(host string) {
+		if err := r.Push(host, batches[host]); err != nil {
+			fmt.Println("副本同步失败：", host, err)
+		}
+		delete(batches, host)
+	}
+	for {
+		select {
+		case task := <-r.queue:
+			batches[task.host] = append(batches[task.host], task.record)
+			if len(batches[task.host]) >= r.batchSize {
+				flush(task.host)
+			}
+		case <-ticker.C:
+			for host := range batches {
+				flush(host)
+			}
+		}
+	}
+}
+
+// 同步发送一批记录到节点
+func (r *Replicator) Push(host string, records []ReplicaRecord) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	values := url.Values{}
+	values.Set("records", string(data))
+	values.Set("time", strconv.FormatInt(time.Now().Unix(), 10))
+	values.Set(common.SignKey, common.SignParams(values, replicaSecret))
+	response, err := r.client.PostForm("http://"+host+"/replicate", values)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("副本节点返回状态 %d", response.StatusCode)
+	}
+	return nil
+}
+
+var replicator *Replicator
+
+// 用户记录的副本节点，不包括本机
+func replicaHosts(uid int) []string {
+	nodes, err := hashConsistent.GetN(strconv.Itoa(uid), config.Replicas)
+	if err != nil {
+		return nil
+	}
+	hosts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node != localHost {
+			hosts = append(hosts, node)
+		}
+	}
+	return hosts
+}
+
+// 把写操作同步到副本节点
+func replicateRecord(record ReplicaRecord) {
+	if replicator == nil {
+		return
+	}
+	for _, host := range replicaHosts(record.UID) {
+		replicator.Send(host, record)
+	}
+}
+
+// 成员变化后修复副本
+// 把本机保存的记录推送给当前负责该用户的其他节点，新加入或接替的节点由此获得历史记录
+func repairReplicas() {
+	records := make(map[int]*ReplicaRecord)
+	for uid, access := range accessControl.Records() {
+		records[uid] = &ReplicaRecord{UID: uid, Access: access.UnixNano()}
+	}
+	for uid, entry := range blackList.Entries() {
+		record, ok := records[uid]
+		if !ok {
+			record = &ReplicaRecord{UID: uid}
+			records[uid] = record
+		}
+		record.Black = true
+		record.BlackReason = entry.Reason
+		if !entry.Expire.IsZero() {
+			record.BlackExpire = entry.Expire.UnixNano()
+		}
+	}
+	batches := make(map[string][]ReplicaRecord)
+	for uid, record := range records {
+		for _, host := range replicaHosts(uid) {
+			batches[host] = append(batches[host], *record)
+		}
+	}
+	for host, batch := range batches {
+		for start := 0; start < len(batch); start += replicator.batchSize {
+			end := start + replicator.batchSize
+			if end > len(batch) {
+				end = len(batch)
+			}
+			if err := replicator.Push(host, batch[start:end]); err != nil {
+				fmt.Println("副本修复失败：", host, err)
+				break
+			}
+		}
+	}
+}
+
+// 成员变化的通知，多次变化合并为一次修复
+var repairSignal = make(chan struct{}, 1)
+
+func runReplicaRepair() {
+	for range repairSignal {
+		// 等待其他节点也感知到成员变化
+		time.Sleep(time.Second)
+		repairReplicas()
+	}
 }
 
-func (m *AccessControl) GetDistributedRight(req *http.Request) bool {
+// 接收其他节点同步的副本
+func Replicate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || !common.VerifyParams(r.PostForm, replicaSecret) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	sent, err := strconv.ParseInt(r.PostForm.Get("time"), 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > replicaMaxAge {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var records []ReplicaRecord
+	if err := json.Unmarshal([]byte(r.PostForm.Get("records")), &records); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, record := range records {
+		applyReplica(record)
+	}
+	w.Write([]byte("true"))
+}
+
+// 合并一条副本记录
+func applyReplica(record ReplicaRecord) {
+	if record.Access > 0 {
+		accessControl.MergeRecord(record.UID, time.Unix(0, record.Access))
+	}
+	if record.Unblack {
+		blackList.RemoveBlackList(record.UID)
+	} else if record.Black {
+		var expire time.Time
+		if record.BlackExpire > 0 {
+			expire = time.Unix(0, record.BlackExpire)
+		}
+		blackList.MergeBlackList(record.UID, record.BlackReason, expire)
+	}
+}
+
+func (m *AccessControl) GetDistributedRight(req *http.Request) (bool, rpc.Reason) {
 	uid, err := req.Cookie("uid")
 	if err != nil {
-		return false
+		return false, rpc.ReasonInvalid
 	}
-	// 采用一致性hash算法，根据用户ID，判断获取具体机器
-	hostRequest, err := hashConsistent.Get(uid.Value)
+	uidInt, err := strconv.ParseInt(uid.Value, 10, 64)
 	if err != nil {
-		return false
+		return false, rpc.ReasonInvalid
+	}
+	// 采用一致性hash算法，根据用户ID，获取所属节点和保存副本的后继节点
+	hosts, err := hashConsistent.GetN(uid.Value, proxyAttempts())
+	if err != nil {
+		return false, rpc.ReasonUnavailable
 	}
 
-	// 判断是否为本机
-	if hostRequest == localHost {
-		// 执行本机数据读取和校验
-		return m.GetDataFromMap(uid.Value)
-	} else {
+	// 所属节点不可用时依次尝试后继节点
+	for _, hostRequest := range hosts {
+		// 判断是否为本机
+		if hostRequest == localHost {
+			// 执行本机数据读取和校验
+			return m.GetDataFromMap(uid.Value)
+		}
 		// 不是本机充当代理访问数据返回结果
-		return GetDataFromOtherMap(hostRequest, req)
+		right, reason, err := GetDataFromOtherMap(req.Context(), hostRequest, uidInt)
+		if err == nil {
+			return right, reason
+		}
+		fmt.Println("节点代理失败，尝试下一个节点：", hostRequest, err)
 	}
+	return false, rpc.ReasonUnavailable
 }
 
-// 获取本机map，并且处理业务逻辑,返回的结果类型为bool类型
-func (m *AccessControl) GetDataFromMap(uid string) (isOk bool) {
+// 代理时最多尝试的节点数，后继节点保存了副本，至少尝试一个后继节点
+func proxyAttempts() int {
+	if config.Replicas < 2 {
+		return 2
+	}
+	return config.Replicas
+}
+
+// 获取本机map，并且处理业务逻辑，不允许访问时返回原因
+func (m *AccessControl) GetDataFromMap(uid string) (bool, rpc.Reason) {
 	uidInt, err := strconv.Atoi(uid)
 	if err != nil {
-		return false
+		return false, rpc.ReasonInvalid
 	}
 
 	// 添加黑名单
 	if blackList.GetBlackListByID(uidInt) {
 		// 判断是否被添加到黑名单中
-		return false
+		return false, rpc.ReasonBlacklisted
+	}
+
+	// 用户的请求都在所属节点处理，在这里统计请求频率和突发请求
+	if offense := detector.ObserveUser(uid, time.Now()); offense != nil {
+		banUser(uidInt, offense)
+		return false, rpc.ReasonBlacklisted
 	}
 
 	// 获取记录
@@ -121,86 +698,102 @@ func (m *AccessControl) GetDataFromMap(uid string) (isOk bool) {
 	if !dataRecord.IsZero() {
 		// 业务判断。是否在指定时间之后
 		if dataRecord.Add(time.Duration(interval) * time.Second).After(time.Now()) {
-			return false
+			return false, rpc.ReasonRateLimited
 		}
 	}
-	m.SetNewRecord(uidInt)
-	return true
+	record := m.SetNewRecord(uidInt)
+	replicateRecord(ReplicaRecord{UID: uidInt, Access: record.UnixNano()})
+	return true, rpc.ReasonOK
 }
 
-// 获取其他节点处理结果
-func GetDataFromOtherMap(host string, request *http.Request) bool {
-	hostUrl := "http://" + host + ":" + port + "/CheckRight"
-	response, body, err := GetCurl(hostUrl, request)
+// 获取其他节点处理结果，节点不可用时返回错误
+func GetDataFromOtherMap(ctx context.Context, host string, uid int64) (bool, rpc.Reason, error) {
+	var reason rpc.Reason
+	err := callPeer(ctx, host, func(ctx context.Context) (err error) {
+		reason, err = validatorClient.CheckRight(ctx, host, uid)
+		return
+	})
 	if err != nil {
-		return false
+		return false, rpc.ReasonUnavailable, err
 	}
-
-	// 判断状态
-	if response.StatusCode == 200 {
-		if string(body) == "true" {
-			return true
-		} else {
-			return false
-		}
-	}
-	return false
+	return reason == rpc.ReasonOK, reason, nil
 }
 
-// 模拟请求
-func GetCurl(hostUrl string, request *http.Request) (response *http.Response, body []byte, err error) {
-	// 获取uid
-	uidPre, err := request.Cookie("uid")
-	if err != nil {
-		return
-	}
+// 节点间RPC连接池，验证节点和数量控制服务共用
+var rpcPool *rpc.Pool
 
-	// 获取sign
-	uidSign, err := request.Cookie("sign")
-	if err != nil {
-		return
-	}
+var validatorClient *rpc.ValidatorClient
 
-	// 模拟接口访问
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", hostUrl, nil)
-	if err != nil {
-		return
-	}
+var stockClient *rpc.StockClient
 
-	// 手动指定，排查多余的cookies
-	cookieUid := &http.Cookie{Name: "uid", Value: uidPre.Value, Path: "/"}
-	cookieSign := &http.Cookie{Name: "sign", Value: uidSign.Value, Path: "/"}
-	req.AddCookie(cookieUid)
-	req.AddCookie(cookieSign)
+// 单次RPC调用的超时时间
+var proxyTimeout = 500 * time.Millisecond
 
-	response, err = client.Do(req)
-	if err != nil {
-		return
+// 节点熔断，连续失败的节点在冷却时间内直接跳过
+var breakers *common.BreakerGroup
+
+// 调用其他节点，不超过proxyTimeout，节点熔断时直接返回ErrCircuitOpen
+// 连接失败和超时计入熔断，节点返回的业务错误不计入
+func callPeer(ctx context.Context, host string, call func(ctx context.Context) error) error {
+	if !breakers.Allow(host) {
+		return common.ErrCircuitOpen
 	}
-	defer response.Body.Close()
+	callCtx, cancel := context.WithTimeout(ctx, proxyTimeout)
+	defer cancel()
+	err := call(callCtx)
+	if _, remote := err.(*rpc.RemoteError); err == nil || remote {
+		breakers.Success(host)
+		return err
+	}
+	// 调用方取消不计入节点失败，但要释放半开状态的探测名额
+	if ctx.Err() != nil {
+		breakers.Cancel(host)
+		return err
+	}
+	breakers.Failure(host)
+	return err
+}
 
-	body, err = ioutil.ReadAll(response.Body)
-	return
+// 其他验证节点通过RPC调用的接口
+type validatorService struct{}
+
+// 请求已经由调用方按hash环转发，直接在本机处理
+func (validatorService) CheckRight(ctx context.Context, uid int64) (rpc.Reason, error) {
+	_, reason := accessControl.GetDataFromMap(strconv.FormatInt(uid, 10))
+	return reason, nil
+}
+
+// 后台修改黑名单，转发给用户所属的节点
+func (validatorService) SetBlacklist(ctx context.Context, request *rpc.BlacklistRequest) (rpc.Reason, error) {
+	if err := routeBlacklist(request); err != nil {
+		return rpc.ReasonUnavailable, nil
+	}
+	return rpc.ReasonOK, nil
 }
 
 func CheckRight(w http.ResponseWriter, r *http.Request) {
-	right := accessControl.GetDistributedRight(r)
+	right, reason := accessControl.GetDistributedRight(r)
 	if !right {
-		w.Write([]byte("false"))
+		common.WriteResponse(w, reason.Code(), "", nil)
 		return
 	}
-	w.Write([]byte("true"))
+	common.WriteOK(w, nil)
 	return
 }
 
+// 下单成功的响应数据
+type CheckResult struct {
+	// 买家通过requestID在前端查询订单处理结果
+	RequestID string `json:"requestID"`
+}
+
 // 执行正常业务逻辑
 func Check(w http.ResponseWriter, r *http.Request) {
 	// 执行正常业务逻辑
 	fmt.Println("执行check!")
 	queryForm, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil || len(queryForm["productID"]) <= 0 {
-		w.Write([]byte("false"))
+		common.WriteResponse(w, common.CodeInvalidRequest, "缺少商品ID", nil)
 		return
 	}
 	productString := queryForm["productID"][0]
@@ -208,68 +801,195 @@ func Check(w http.ResponseWriter, r *http.Request) {
 	// 获取用户cookie
 	userCookie, err := r.Cookie("uid")
 	if err != nil {
-		w.Write([]byte("false"))
+		common.WriteResponse(w, common.CodeUnauthenticated, "", nil)
 		return
 	}
 
-	// 1.分布式权限验证
-	right := accessControl.GetDistributedRight(r)
+	// 获取用户id和商品id
+	productID, err := strconv.ParseInt(productString, 10, 64)
+	if err != nil {
+		common.WriteResponse(w, common.CodeInvalidRequest, "商品ID格式错误", nil)
+		return
+	}
+	userID, err := strconv.ParseInt(userCookie.Value, 10, 64)
+	if err != nil {
+		common.WriteResponse(w, common.CodeUnauthenticated, "", nil)
+		return
+	}
+	// 活动时间检查，商品只在秒杀活动中售卖，没有活动的商品同样拒绝
+	campaign, err := campaignService.CheckWindow(productID, time.Now())
+	if err != nil {
+		common.WriteResponse(w, common.CodeNotInCampaign, err.Error(), nil)
+		return
+	}
+	// 秒杀令牌检查，令牌只在活动开始后发放，防止提前请求
+	if err := seckillTokenService.VerifyToken(queryForm.Get("token"), userID, productID); err != nil {
+		common.WriteResponse(w, common.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+	// 1.分布式权限验证，放在本地检查之后，无效请求不再转发到用户所属节点
+	right, reason := accessControl.GetDistributedRight(r)
 	if right == false {
-		w.Write([]byte("false"))
+		fmt.Println("权限验证未通过：", reason)
+		common.WriteResponse(w, reason.Code(), "", nil)
 		return
 	}
-	// 2.获取数量控制权限，防止秒杀出现超买现象
-	hostUrl := "http://" + GetOneIp + ":" + GetOnePort + "/getOne"
-	responseValidate, validateBody, err := GetCurl(hostUrl, r)
+	// 限购检查，限购数量取自活动配置，消费端下单时会再次校验
+	canPurchase, err := purchaseService.CanPurchase(userID, productID, campaign.ID)
 	if err != nil {
-		w.Write([]byte("false"))
+		fmt.Println(err)
+		common.WriteResponse(w, common.CodeUnavailable, "", nil)
+		return
+	}
+	if !canPurchase {
+		common.WriteResponse(w, common.CodePurchaseLimited, "", nil)
+		return
+	}
+	// 2.预留库存，防止秒杀出现超买现象
+	getOneHost, reservationID, reason, err := ReserveProduct(productString, r)
+	if err != nil || reason != rpc.ReasonOK {
+		fmt.Println("预留商品失败：", reason, err)
+		common.WriteResponse(w, reason.Code(), "", nil)
+		return
+	}
+	// 创建消息体，客户端重试时携带同一个requestID，保证只生成一个订单
+	message := datamodels.NewMessage(userID, productID, common.RequestIDFrom(r.URL.Query().Get("requestID")))
+	message.CampaignID = campaign.ID
+	// 类型转化
+	byteMessage, err := json.Marshal(message)
+	if err != nil {
+		// 下单失败，释放预留的库存
+		ReleaseProduct(getOneHost, reservationID, r)
+		common.WriteResponse(w, common.CodeInternal, "", nil)
 		return
 	}
-	// 判断数量控制接口请求状态
-	if responseValidate.StatusCode == http.StatusOK {
-		if string(validateBody) == "true" {
-			// 整合下单逻辑，获取用户id和商品id
-			productID, err := strconv.ParseInt(productString, 10, 64)
-			if err != nil {
-				w.Write([]byte("false"))
-				return
-			}
-			userID, err := strconv.ParseInt(userCookie.Value, 10, 64)
-			if err != nil {
-				w.Write([]byte("false"))
-				return
-			}
-			// 创建消息体
-			message := datamodels.NewMessage(userID, productID)
-			// 类型转化
-			byteMessage, err := json.Marshal(message)
-			if err != nil {
-				w.Write([]byte("false"))
-				return
-			}
 
-			// 生产消息
-			err = rabbitMqValidate.PublishSimple(string(byteMessage))
-			if err != nil {
-				w.Write([]byte("false"))
-				return
-			}
-			w.Write([]byte("true"))
+	// 生产消息
+	err = rabbitMqValidate.PublishSimple(string(byteMessage))
+	if err != nil {
+		ReleaseProduct(getOneHost, reservationID, r)
+		common.WriteResponse(w, common.CodeUnavailable, "", nil)
+		return
+	}
+	// 消息已发送，确认预留
+	ConfirmProduct(getOneHost, reservationID, r)
+	// 买家通过requestID在前端查询订单处理结果
+	w.Header().Set("X-Request-ID", message.RequestID)
+	common.WriteOK(w, &CheckResult{RequestID: message.RequestID})
+	return
+
+}
+
+// 向数量控制服务预留一件商品，成功返回预留所在的节点和预留ID
+// 轮流选择起始节点分摊压力，节点故障或配额不足时尝试下一个节点，所有节点都不可用时返回错误
+func ReserveProduct(productString string, r *http.Request) (string, string, rpc.Reason, error) {
+	productID, err := strconv.ParseInt(productString, 10, 64)
+	if err != nil {
+		return "", "", rpc.ReasonInvalid, nil
+	}
+	start := int(atomic.AddUint32(&getOneCursor, 1))
+	getOneHosts := config.GetOneHosts
+	var (
+		lastErr   error
+		available bool
+	)
+	for i := 0; i < len(getOneHosts); i++ {
+		host := getOneHosts[(start+i)%len(getOneHosts)]
+		var (
+			reservationID string
+			reason        rpc.Reason
+		)
+		err := callPeer(r.Context(), host, func(ctx context.Context) (err error) {
+			reservationID, reason, err = stockClient.Reserve(ctx, host, productID)
 			return
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		available = true
+		switch reason {
+		case rpc.ReasonOK:
+			return host, reservationID, reason, nil
+		case rpc.ReasonNotInCampaign:
+			// 活动时间对所有节点相同，不需要再尝试
+			return "", "", reason, nil
 		}
 	}
+	if !available {
+		return "", "", rpc.ReasonUnavailable, lastErr
+	}
+	return "", "", rpc.ReasonSoldOut, nil
+}
 
-	w.Write([]byte("false"))
-	return
+// 释放预留的商品，失败时由数量控制服务超时自动释放
+// 客户端断开不影响释放，因此不使用请求的context
+func ReleaseProduct(host string, reservationID string, r *http.Request) {
+	var reason rpc.Reason
+	err := callPeer(context.Background(), host, func(ctx context.Context) (err error) {
+		reason, err = stockClient.Release(ctx, host, reservationID)
+		return
+	})
+	if err != nil || reason != rpc.ReasonOK {
+		fmt.Println("释放预留失败，等待超时释放：", reservationID, reason, err)
+	}
+}
 
+// 确认预留的商品
+// 确认失败时预留会超时释放，因此需要重试
+func ConfirmProduct(host string, reservationID string, r *http.Request) {
+	for i := 0; i < confirmRetry; i++ {
+		var reason rpc.Reason
+		err := callPeer(context.Background(), host, func(ctx context.Context) (err error) {
+			reason, err = stockClient.Confirm(ctx, host, reservationID)
+			return
+		})
+		if err == nil && reason == rpc.ReasonOK {
+			return
+		}
+		// 预留已不存在，重试没有意义
+		if reason == rpc.ReasonNotFound {
+			fmt.Println("确认预留失败，预留已过期：", reservationID)
+			return
+		}
+		fmt.Println("确认预留失败：", reservationID, reason, err)
+	}
+}
+
+// 注册路由的拦截器
+// 总量和IP限流在身份验证之前，尽早拒绝洪水请求；用户和商品限流在身份验证之后，避免伪造uid绕过
+func registerFilters(filter *common.Filter, uri string) {
+	limits := config.RateLimits[uri]
+	filter.RegisterFilterUri(uri,
+		common.RateLimitFilter(common.NewRateLimiter(limits.Global), common.GlobalKey),
+		common.RateLimitFilter(common.NewRateLimiter(limits.IP), common.IPKey(config.TrustForwarded)),
+		Auth,
+		common.RateLimitFilter(common.NewRateLimiter(limits.User), common.CookieKey("uid")),
+		common.RateLimitFilter(common.NewRateLimiter(limits.Product), common.QueryKey("productID")),
+	)
 }
 
 // 统一验证拦截器，每个接口都需要提前验证
 func Auth(rw http.ResponseWriter, r *http.Request) error {
 	fmt.Println("执行验证！")
+	ip := common.GetClientIp(r, config.TrustForwarded)
+	if detector.IPBanned(ip) {
+		return common.NewCodeError(common.CodeBlacklisted, "")
+	}
+	if offense := detector.ObserveIP(ip, time.Now()); offense != nil {
+		banIP(ip, offense)
+		return common.NewCodeError(common.CodeBlacklisted, "")
+	}
 	// 添加基于cookie的权限验证
 	err := CheckUserInfo(r)
 	if err != nil {
+		// 只统计带签名但校验失败的请求
+		// cookie中的用户ID可能是被冒用的，因此只封禁IP，不拉黑用户
+		if _, signErr := r.Cookie("sign"); signErr == nil {
+			if offense := detector.ObserveSignFailure(ip, time.Now()); offense != nil {
+				banIP(ip, offense)
+			}
+		}
 		return err
 	}
 	return nil
@@ -279,18 +999,18 @@ func CheckUserInfo(r *http.Request) error {
 	// 获取Uid, cookie
 	uidCookie, err := r.Cookie("uid")
 	if err != nil {
-		return errors.New("用户UID Cookie 获取失败！")
+		return common.NewCodeError(common.CodeUnauthenticated, "用户UID Cookie 获取失败！")
 	}
 	// 获取用户加密串
 	signCookie, err := r.Cookie("sign")
 	if err != nil {
-		return errors.New("用户加密串 cookie获取失败！")
+		return common.NewCodeError(common.CodeUnauthenticated, "用户加密串 cookie获取失败！")
 	}
 
 	// 解密
 	signByte, err := encrypt.DePwdCode(signCookie.Value)
 	if err != nil {
-		return errors.New("加密串已被篡改！")
+		return common.NewCodeError(common.CodeUnauthenticated, "加密串已被篡改！")
 	}
 
 	fmt.Println("结果比对")
@@ -300,7 +1020,7 @@ func CheckUserInfo(r *http.Request) error {
 	if checkInfo(uidCookie.Value, string(signByte)) {
 		return nil
 	}
-	return errors.New("身份验证失败！")
+	return common.NewCodeError(common.CodeUnauthenticated, "身份验证失败！")
 }
 
 // 自定义逻辑判断
@@ -311,35 +1031,151 @@ func checkInfo(checkStr string, signStr string) bool {
 	return false
 }
 
+// 健康检查
+func Health(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// 当前集群成员
+func Members(w http.ResponseWriter, r *http.Request) {
+	data, _ := json.Marshal(membership.Members())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
 func main() {
+	flag.Parse()
+	if err := loadConfig(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	// RPC和副本同步接口与外部请求共用端口，密钥未配置时拒绝启动
+	var err error
+	for name, secret := range map[string]*string{
+		"RPC_SECRET":              &rpc.Secret,
+		"VALIDATE_REPLICA_SECRET": &replicaSecret,
+		"SECKILL_SECRET":          &services.SeckillSecret,
+	} {
+		if *secret, err = common.SecretFromEnv(name); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	healthInterval, err := time.ParseDuration(config.HealthInterval)
+	if err != nil {
+		fmt.Println("健康检查间隔格式错误：", err)
+		return
+	}
+	proxyTimeout, err = time.ParseDuration(config.ProxyTimeout)
+	if err != nil {
+		fmt.Println("代理超时时间格式错误：", err)
+		return
+	}
+	breakerCooldown, err := time.ParseDuration(config.BreakerCooldown)
+	if err != nil {
+		fmt.Println("熔断冷却时间格式错误：", err)
+		return
+	}
+	breakers = common.NewBreakerGroup(config.BreakerThreshold, breakerCooldown)
+	detector, err = common.NewBotDetector(config.Detector)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer detector.Close()
+	blacklistSync, err := time.ParseDuration(config.BlacklistSync)
+	if err != nil {
+		fmt.Println("黑名单同步间隔格式错误：", err)
+		return
+	}
+
+	// 本机地址
+	localHost = config.LocalHost
+	if localHost == "" {
+		// 自动获取本机ip
+		localIp, err := common.GetIntranetIp()
+		if err != nil {
+			fmt.Println(err)
+		}
+		localHost = localIp
+	}
+	localHost = withPort(localHost, config.Port)
+	fmt.Println("Local Host:", localHost)
+
 	// 负载均衡器设置
-	// 采用一致性哈希算法
+	// 采用一致性哈希算法，节点由集群成员管理按健康检查结果动态加入和移出
+	// 用户的访问记录保存在所属节点上，所以这里使用Get而不是有界负载的Acquire
 	hashConsistent = common.NewConsistent()
-	// 采用一致性hash算法，添加节点
-	for _, v := range hostArray {
-		hashConsistent.Add(v)
+	hashConsistent.Hash, err = common.HashFuncByName(config.HashFunc)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if config.VirtualNode > 0 {
+		hashConsistent.VirtualNode = config.VirtualNode
+	}
+	for host, weight := range config.Weights {
+		hashConsistent.SetWeight(host, weight)
 	}
+	// 节点间通过RPC通信
+	rpcPool = rpc.NewPool(localHost, rpc.Secret)
+	defer rpcPool.Close()
+	validatorClient = rpc.NewValidatorClient(rpcPool)
+	stockClient = rpc.NewStockClient(rpcPool)
+
+	// 访问记录和黑名单同步到顺时针方向的后继节点，所属节点失效后由后继节点接替
+	replicator = NewReplicator(10000)
+	membership = common.NewMembership(hashConsistent, localHost, config.Hosts, healthInterval,
+		common.NewHTTPHealthCheck("/health", time.Second))
+	defer membership.Close()
+	membership.OnChange(func(node string, alive bool) {
+		select {
+		case repairSignal <- struct{}{}:
+		default:
+		}
+	})
+	go runReplicaRepair()
 
-	// 自动获取本机ip
-	localIp, err := common.GetIntranetIp()
+	db, err := common.NewMysqlConn()
 	if err != nil {
 		fmt.Println(err)
+		return
 	}
-	localHost = localIp
-	fmt.Println("Local Host:", localHost)
+	campaignService = services.NewCampaignService(repositories.NewCampaignManager("campaign", db))
+	seckillTokenService = services.NewSeckillTokenService(campaignService, services.SeckillSecret)
+	purchaseService = services.NewPurchaseService(repositories.NewPurchaseManager("purchase_ledger", db), campaignService)
+	blacklistService = services.NewBlacklistService(repositories.NewBlacklistManager("blacklist", db),
+		repositories.NewBlacklistAuditManager("blacklist_audit", db), ringBlacklistNotifier{})
+	go syncBlacklist(blacklistSync)
 
-	rabbitMqValidate = rabbitmq.NewRabbitMQSimple("imoocProduct")
+	// 可靠投递，未确认的消息写入本地发件箱重试
+	rabbitMqValidate, err = rabbitmq.NewRabbitMQSimpleReliable("imoocProduct", "./outbox/validate")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	defer rabbitMqValidate.Destory()
 
 	// 1.过滤器
 	filter := common.NewFilter()
 	// 注册拦截器
-	filter.RegisterFilterUri("/check", Auth)
-	filter.RegisterFilterUri("/checkRight", Auth)
+	registerFilters(filter, "/check")
+	registerFilters(filter, "/checkRight")
 	// 2.启动服务
 	http.HandleFunc("/check", filter.Handle(Check))
 	http.HandleFunc("/checkRight", filter.Handle(CheckRight))
+	http.HandleFunc("/health", Health)
+	http.HandleFunc("/members", Members)
+	// 其他验证节点通过RPC检查用户权限
+	rpcServer := rpc.NewServer(localHost, rpc.Secret)
+	rpc.RegisterValidator(rpcServer, validatorService{})
+	http.Handle(rpc.Path, rpcServer)
+	// 节点间副本同步，使用签名校验
+	http.HandleFunc("/replicate", Replicate)
 	// 启动服务
-	http.ListenAndServe(":8083", nil)
+	err = http.ListenAndServe(":"+config.Port, nil)
+	if err != nil {
+		fmt.Println(err)
+	}
 
 }